@@ -2,15 +2,22 @@ package handlers
 
 import (
 	"ai-api-platform/backend/models"
+	"ai-api-platform/backend/providers"
 	"ai-api-platform/backend/services"
 	"ai-api-platform/backend/utils"
+	"context"
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
 // --- Auth ---
@@ -70,6 +77,12 @@ func CreateProvider(c *gin.Context) {
 		return
 	}
 
+	if !providers.IsSupported(provider.Type) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported provider type: " + provider.Type})
+		return
+	}
+	provider.Type = providers.NormalizeType(provider.Type)
+
 	if err := models.DB.Create(&provider).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create provider"})
 		return
@@ -94,6 +107,12 @@ func UpdateProvider(c *gin.Context) {
 		return
 	}
 
+	if !providers.IsSupported(provider.Type) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported provider type: " + provider.Type})
+		return
+	}
+	provider.Type = providers.NormalizeType(provider.Type)
+
 	if err := models.DB.Save(&provider).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save provider"})
 		return
@@ -110,18 +129,499 @@ func UpdateProvider(c *gin.Context) {
 
 func DeleteProvider(c *gin.Context) {
 	id := c.Param("id")
+	var provider models.AIProvider
+	if err := models.DB.First(&provider, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Provider not found"})
+		return
+	}
+
+	models.DB.Where("provider_id = ?", provider.ID).Delete(&models.ProviderKey{})
 	models.DB.Delete(&models.AIProvider{}, id)
+	services.ReloadProviderKeys(provider.ID)
+	c.JSON(http.StatusOK, gin.H{"message": "Deleted"})
+}
+
+// --- Provider Keys ---
+
+// GetProviderKeys 获取供应商的上游 Key 池及其健康状态
+func GetProviderKeys(c *gin.Context) {
+	var provider models.AIProvider
+	if err := models.DB.First(&provider, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Provider not found"})
+		return
+	}
+	keys := services.GetProviderKeys(provider.ID)
+	for i := range keys {
+		keys[i] = maskProviderKey(keys[i])
+	}
+	c.JSON(http.StatusOK, keys)
+}
+
+// maskProviderKey 只保留上游 Key 的前 4 位与后 4 位，管理接口不返回完整的 Key
+func maskProviderKey(key models.ProviderKey) models.ProviderKey {
+	if len(key.Key) < 12 {
+		key.Key = "****"
+	} else {
+		key.Key = key.Key[:4] + "****" + key.Key[len(key.Key)-4:]
+	}
+	return key
+}
+
+// CreateProviderKey 为供应商添加一个上游 Key
+func CreateProviderKey(c *gin.Context) {
+	var provider models.AIProvider
+	if err := models.DB.First(&provider, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Provider not found"})
+		return
+	}
+
+	var input struct {
+		Name string `json:"Name"`
+		Key  string `json:"Key" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if strings.TrimSpace(input.Key) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Key cannot be empty"})
+		return
+	}
+
+	key := models.ProviderKey{
+		ProviderID: provider.ID,
+		Name:       strings.TrimSpace(input.Name),
+		Key:        strings.TrimSpace(input.Key),
+		Status:     models.ProviderKeyActive,
+	}
+	if err := models.DB.Create(&key).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create provider key"})
+		return
+	}
+	if err := services.ReloadProviderKeys(provider.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reload provider keys"})
+		return
+	}
+	c.JSON(http.StatusOK, maskProviderKey(key))
+}
+
+// UpdateProviderKey 修改上游 Key 的名称或状态，重新启用时清除暂停状态
+func UpdateProviderKey(c *gin.Context) {
+	var key models.ProviderKey
+	if err := models.DB.Where("id = ? AND provider_id = ?", c.Param("keyId"), c.Param("id")).First(&key).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Provider key not found"})
+		return
+	}
+
+	var input struct {
+		Name   *string `json:"Name"`
+		Status string  `json:"Status"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updates := map[string]interface{}{}
+	if input.Name != nil {
+		updates["name"] = strings.TrimSpace(*input.Name)
+	}
+	switch input.Status {
+	case "":
+	case models.ProviderKeyActive:
+		updates["status"] = models.ProviderKeyActive
+		updates["cooldown_until"] = nil
+		updates["failure_count"] = 0
+	case models.ProviderKeyDisabled:
+		updates["status"] = models.ProviderKeyDisabled
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Status must be active or disabled"})
+		return
+	}
+
+	if len(updates) > 0 {
+		if err := models.DB.Model(&key).Updates(updates).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update provider key"})
+			return
+		}
+	}
+	if err := services.ReloadProviderKeys(key.ProviderID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reload provider keys"})
+		return
+	}
+
+	models.DB.First(&key, key.ID)
+	c.JSON(http.StatusOK, maskProviderKey(key))
+}
+
+// DeleteProviderKey 删除上游 Key
+func DeleteProviderKey(c *gin.Context) {
+	var key models.ProviderKey
+	if err := models.DB.Where("id = ? AND provider_id = ?", c.Param("keyId"), c.Param("id")).First(&key).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Provider key not found"})
+		return
+	}
+
+	if err := models.DB.Delete(&key).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete provider key"})
+		return
+	}
+	services.ReloadProviderKeys(key.ProviderID)
 	c.JSON(http.StatusOK, gin.H{"message": "Deleted"})
 }
 
+// GetProviderTypes 获取所有支持的供应商类型
+func GetProviderTypes(c *gin.Context) {
+	c.JSON(http.StatusOK, providers.Types())
+}
+
+// listModelsTimeout 是查询供应商模型列表的超时时间，默认 HTTP 客户端本身不设置超时
+const listModelsTimeout = 30 * time.Second
+
+// GetProviderModels 通过适配器查询供应商可用的模型列表
+func GetProviderModels(c *gin.Context) {
+	id := c.Param("id")
+	var provider models.AIProvider
+	if err := models.DB.First(&provider, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Provider not found"})
+		return
+	}
+
+	adapter, err := providers.New(&provider, defaultHTTPClient)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), listModelsTimeout)
+	defer cancel()
+	modelNames, err := adapter.ListModels(ctx)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to list models: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, modelNames)
+}
+
 // --- Endpoints ---
 
 func GetEndpoints(c *gin.Context) {
 	var endpoints []models.APIEndpoint
-	models.DB.Preload("Provider").Find(&endpoints)
+	models.PreloadEndpoint(models.DB).Find(&endpoints)
 	c.JSON(http.StatusOK, endpoints)
 }
 
+// normalizeAttempts 校验备用模型列表并按数组顺序重排 Position
+func normalizeAttempts(attempts []models.EndpointAttempt) error {
+	for i := range attempts {
+		attempt := &attempts[i]
+		var provider models.AIProvider
+		if err := models.DB.First(&provider, attempt.ProviderID).Error; err != nil {
+			return fmt.Errorf("fallback provider %d not found", attempt.ProviderID)
+		}
+
+		attempt.ID = 0
+		attempt.Position = i
+		attempt.Provider = models.AIProvider{}
+		attempt.ModelName = strings.TrimSpace(attempt.ModelName)
+		if attempt.ModelName == "" {
+			attempt.ModelName = defaultModelName(provider.ModelName)
+		}
+		if attempt.ModelName == "" {
+			return fmt.Errorf("fallback model for provider %d is required", attempt.ProviderID)
+		}
+		if attempt.Timeout < 0 {
+			return fmt.Errorf("fallback timeout cannot be negative")
+		}
+	}
+	return nil
+}
+
+// normalizeRouting 校验路由模式与负载均衡池成员
+func normalizeRouting(routingMode *string, members []models.EndpointPoolMember) error {
+	switch *routingMode {
+	case "":
+		*routingMode = models.RoutingModeFailover
+	case models.RoutingModeFailover, models.RoutingModeWeighted, models.RoutingModeRoundRobin, models.RoutingModeLeastInFlight:
+	default:
+		return fmt.Errorf("unsupported routing mode: %s", *routingMode)
+	}
+	if *routingMode != models.RoutingModeFailover && len(members) == 0 {
+		return fmt.Errorf("routing mode %s requires at least one pool member", *routingMode)
+	}
+
+	for i := range members {
+		member := &members[i]
+		var provider models.AIProvider
+		if err := models.DB.First(&provider, member.ProviderID).Error; err != nil {
+			return fmt.Errorf("pool provider %d not found", member.ProviderID)
+		}
+
+		member.ID = 0
+		member.Provider = models.AIProvider{}
+		member.ModelName = strings.TrimSpace(member.ModelName)
+		if member.ModelName == "" {
+			member.ModelName = defaultModelName(provider.ModelName)
+		}
+		if member.ModelName == "" {
+			return fmt.Errorf("pool model for provider %d is required", member.ProviderID)
+		}
+		if member.Weight < 0 {
+			return fmt.Errorf("pool member weight cannot be negative")
+		}
+		if member.Weight == 0 {
+			member.Weight = 1
+		}
+	}
+	return nil
+}
+
+// normalizeStreamFallbackPolicy 校验流式输出的备用模型策略
+func normalizeStreamFallbackPolicy(policy *string) error {
+	switch *policy {
+	case "":
+		*policy = models.StreamFallbackBuffer
+	case models.StreamFallbackBuffer, models.StreamFallbackReset, models.StreamFallbackFail:
+	default:
+		return fmt.Errorf("unsupported stream fallback policy: %s", *policy)
+	}
+	return nil
+}
+
+// maxHedgeDelay 是对冲延迟的上限（毫秒）
+const maxHedgeDelay = 60000
+
+// validateHedgeDelay 检查对冲延迟
+func validateHedgeDelay(delay int) error {
+	if delay < 0 || delay > maxHedgeDelay {
+		return fmt.Errorf("HedgeDelay must be between 0 and %d", maxHedgeDelay)
+	}
+	return nil
+}
+
+// validateQuotas 检查每日与每月的 Token 数与费用配额，0 表示不限制
+func validateQuotas(dailyTokens, monthlyTokens int64, dailyCost, monthlyCost float64) error {
+	if dailyTokens < 0 || monthlyTokens < 0 || dailyCost < 0 || monthlyCost < 0 {
+		return fmt.Errorf("quotas cannot be negative")
+	}
+	return nil
+}
+
+// validateRateLimits 检查每分钟请求数与 Token 数上限，0 表示不限制
+func validateRateLimits(rpm, tpm int64) error {
+	if rpm < 0 || tpm < 0 {
+		return fmt.Errorf("RateLimitRPM and RateLimitTPM cannot be negative")
+	}
+	return nil
+}
+
+// validateEndpointTemplates 在开启 PromptTemplating 时检查系统提示词与用户消息模板的语法，
+// 未开启时系统提示词原样使用，不能配置用户消息模板
+func validateEndpointTemplates(systemPrompt, userTemplate string, templating bool) error {
+	if !templating {
+		if userTemplate != "" {
+			return fmt.Errorf("UserTemplate requires PromptTemplating to be enabled")
+		}
+		return nil
+	}
+	if err := services.ValidatePromptTemplate(systemPrompt); err != nil {
+		return fmt.Errorf("invalid SystemPrompt template: %v", err)
+	}
+	if err := services.ValidatePromptTemplate(userTemplate); err != nil {
+		return fmt.Errorf("invalid UserTemplate template: %v", err)
+	}
+	return nil
+}
+
+// maxSchemaRepairRetries 是结构化输出纠正重试次数的上限
+const maxSchemaRepairRetries = 5
+
+// validateStructuredOutput 检查结构化输出配置：Schema 需能编译，且不能与流式输出同时开启
+func validateStructuredOutput(jsonSchema string, repairRetries int, streamOutput bool) error {
+	if strings.TrimSpace(jsonSchema) == "" {
+		return nil
+	}
+	if _, err := services.CompileJSONSchema(jsonSchema); err != nil {
+		return fmt.Errorf("invalid JSONSchema: %v", err)
+	}
+	if streamOutput {
+		return fmt.Errorf("JSONSchema cannot be used together with StreamOutput")
+	}
+	if repairRetries < 0 || repairRetries > maxSchemaRepairRetries {
+		return fmt.Errorf("SchemaRepairRetries must be between 0 and %d", maxSchemaRepairRetries)
+	}
+	return nil
+}
+
+// maxToolRoundsLimit 是 server 模式下工具调用轮数的上限
+const maxToolRoundsLimit = 20
+
+// defaultMaxToolRounds 是未设置 MaxToolRounds 时 server 模式的工具调用轮数
+const defaultMaxToolRounds = 5
+
+// normalizeToolConfig 检查工具定义，默认使用 passthrough 模式；server 模式需要在返回前执行工具，不能与流式输出同时开启
+func normalizeToolConfig(tools *string, mode *string, maxRounds *int, streamOutput bool) error {
+	*tools = strings.TrimSpace(*tools)
+	if *mode == "" {
+		*mode = models.ToolModePassthrough
+	}
+	if *maxRounds == 0 {
+		*maxRounds = defaultMaxToolRounds
+	}
+	if _, err := services.ParseEndpointTools(*tools, *mode); err != nil {
+		return fmt.Errorf("invalid Tools: %v", err)
+	}
+	if *mode != models.ToolModeServer || *tools == "" {
+		return nil
+	}
+	if streamOutput {
+		return fmt.Errorf("server ToolMode cannot be used together with StreamOutput")
+	}
+	if *maxRounds < 1 || *maxRounds > maxToolRoundsLimit {
+		return fmt.Errorf("MaxToolRounds must be between 1 and %d", maxToolRoundsLimit)
+	}
+	return nil
+}
+
+// validateToolProviders 检查配置了工具时，主供应商、负载均衡池与备用模型的供应商类型都支持工具调用，
+// 否则这些尝试每次都会因不支持工具而被跳过
+func validateToolProviders(tools string, providerID uint, attempts []models.EndpointAttempt, members []models.EndpointPoolMember) error {
+	if tools == "" {
+		return nil
+	}
+	ids := []uint{providerID}
+	for _, attempt := range attempts {
+		ids = append(ids, attempt.ProviderID)
+	}
+	for _, member := range members {
+		ids = append(ids, member.ProviderID)
+	}
+
+	var providerList []models.AIProvider
+	if err := models.DB.Where("id IN ?", ids).Find(&providerList).Error; err != nil {
+		return err
+	}
+	probe := &providers.ChatRequest{Tools: []providers.Tool{{Name: "probe"}}}
+	for _, provider := range providerList {
+		if providers.CheckRequest(provider.Type, probe) != nil {
+			return fmt.Errorf("Tools cannot be used with provider %q: provider type %q does not support tool calls", provider.Name, providers.NormalizeType(provider.Type))
+		}
+	}
+	return nil
+}
+
+// 多模态输入的默认值与上限
+const (
+	defaultMaxImages     = 4
+	maxImagesLimit       = 20
+	defaultMaxImageBytes = 5 << 20
+	maxImageBytesLimit   = 20 << 20
+)
+
+// normalizeVisionLimits 检查图片数量与大小上限，未设置时使用默认值
+func normalizeVisionLimits(maxImages *int, maxImageBytes *int64) error {
+	if *maxImages == 0 {
+		*maxImages = defaultMaxImages
+	}
+	if *maxImageBytes == 0 {
+		*maxImageBytes = defaultMaxImageBytes
+	}
+	if *maxImages < 1 || *maxImages > maxImagesLimit {
+		return fmt.Errorf("MaxImages must be between 1 and %d", maxImagesLimit)
+	}
+	if *maxImageBytes < 1 || *maxImageBytes > maxImageBytesLimit {
+		return fmt.Errorf("MaxImageBytes must be between 1 and %d", maxImageBytesLimit)
+	}
+	return nil
+}
+
+// normalizeSessionLimits 检查会话历史的回放上限，SessionMaxTokens 未设置时使用默认值
+func normalizeSessionLimits(maxTurns int, maxTokens *int) error {
+	if maxTurns < 0 || *maxTokens < 0 {
+		return fmt.Errorf("SessionMaxTurns and SessionMaxTokens cannot be negative")
+	}
+	if *maxTokens == 0 {
+		*maxTokens = services.DefaultSessionMaxTokens
+	}
+	return nil
+}
+
+// maxResponseCacheTTL 是响应缓存有效期的上限（秒）
+const maxResponseCacheTTL = 30 * 24 * 3600
+
+// validateResponseCacheTTL 检查响应缓存的有效期
+func validateResponseCacheTTL(ttl int) error {
+	if ttl < 0 || ttl > maxResponseCacheTTL {
+		return fmt.Errorf("ResponseCacheTTL must be between 0 and %d", maxResponseCacheTTL)
+	}
+	return nil
+}
+
+// normalizeSemanticCache 检查语义缓存配置：有效期与响应缓存相同，开启时阈值在 (0, 1] 之间，
+// 向量化供应商必须存在且支持向量化接口。EmbeddingProviderID 为 0 视为未选择。
+func normalizeSemanticCache(ttl int, threshold float64, providerID **uint, model *string) error {
+	if ttl < 0 || ttl > maxResponseCacheTTL {
+		return fmt.Errorf("SemanticCacheTTL must be between 0 and %d", maxResponseCacheTTL)
+	}
+	if threshold < 0 || threshold > 1 || (ttl > 0 && threshold == 0) {
+		return fmt.Errorf("SemanticCacheThreshold must be greater than 0 and at most 1")
+	}
+	*model = strings.TrimSpace(*model)
+	if *providerID != nil && **providerID == 0 {
+		*providerID = nil
+	}
+
+	var provider models.AIProvider
+	if *providerID != nil {
+		if err := models.DB.First(&provider, **providerID).Error; err != nil {
+			return fmt.Errorf("embedding provider %d not found", **providerID)
+		}
+	}
+	if ttl == 0 {
+		return nil
+	}
+
+	if *providerID == nil || *model == "" {
+		return fmt.Errorf("EmbeddingProviderID and EmbeddingModel are required when semantic cache is enabled")
+	}
+	adapter, err := providers.New(&provider, defaultHTTPClient)
+	if err != nil {
+		return err
+	}
+	if _, ok := adapter.(providers.Embedder); !ok {
+		return fmt.Errorf("provider type %q does not support embeddings", providers.NormalizeType(provider.Type))
+	}
+	return nil
+}
+
+// replacePoolMembers 用新的池成员列表替换 API 路径原有的负载均衡池
+func replacePoolMembers(tx *gorm.DB, endpointID uint, members []models.EndpointPoolMember) error {
+	if err := tx.Where("api_endpoint_id = ?", endpointID).Delete(&models.EndpointPoolMember{}).Error; err != nil {
+		return err
+	}
+	for i := range members {
+		members[i].APIEndpointID = endpointID
+		if err := tx.Omit("Provider").Create(&members[i]).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replaceEndpointAttempts 用新的备用模型列表替换 API 路径原有的备用模型
+func replaceEndpointAttempts(tx *gorm.DB, endpointID uint, attempts []models.EndpointAttempt) error {
+	if err := tx.Where("api_endpoint_id = ?", endpointID).Delete(&models.EndpointAttempt{}).Error; err != nil {
+		return err
+	}
+	for i := range attempts {
+		attempts[i].APIEndpointID = endpointID
+		if err := tx.Omit("Provider").Create(&attempts[i]).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func CreateEndpoint(c *gin.Context) {
 	var endpoint models.APIEndpoint
 	if err := c.ShouldBindJSON(&endpoint); err != nil {
@@ -129,8 +629,12 @@ func CreateEndpoint(c *gin.Context) {
 		return
 	}
 
-	if strings.TrimSpace(endpoint.Path) == "" || strings.TrimSpace(endpoint.ApiKey) == "" || endpoint.ProviderID == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Path, ApiKey and ProviderID cannot be empty"})
+	if strings.TrimSpace(endpoint.Path) == "" || endpoint.ProviderID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Path and ProviderID cannot be empty"})
+		return
+	}
+	if services.IsReservedPath(endpoint.Path) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Path is reserved by a built-in route"})
 		return
 	}
 
@@ -161,7 +665,75 @@ func CreateEndpoint(c *gin.Context) {
 		return
 	}
 
-	if err := models.DB.Create(&endpoint).Error; err != nil {
+	attempts, poolMembers := endpoint.Attempts, endpoint.PoolMembers
+	endpoint.Attempts, endpoint.PoolMembers = nil, nil
+	if err := normalizeAttempts(attempts); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := normalizeRouting(&endpoint.RoutingMode, poolMembers); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := normalizeStreamFallbackPolicy(&endpoint.StreamFallbackPolicy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateHedgeDelay(endpoint.HedgeDelay); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateRateLimits(endpoint.RateLimitRPM, endpoint.RateLimitTPM); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateQuotas(endpoint.DailyTokenQuota, endpoint.MonthlyTokenQuota, endpoint.DailyCostQuota, endpoint.MonthlyCostQuota); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := normalizeSessionLimits(endpoint.SessionMaxTurns, &endpoint.SessionMaxTokens); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateEndpointTemplates(endpoint.SystemPrompt, endpoint.UserTemplate, endpoint.PromptTemplating); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	endpoint.JSONSchema = strings.TrimSpace(endpoint.JSONSchema)
+	if err := validateStructuredOutput(endpoint.JSONSchema, endpoint.SchemaRepairRetries, endpoint.StreamOutput); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := normalizeToolConfig(&endpoint.Tools, &endpoint.ToolMode, &endpoint.MaxToolRounds, endpoint.StreamOutput); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateToolProviders(endpoint.Tools, endpoint.ProviderID, attempts, poolMembers); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := normalizeVisionLimits(&endpoint.MaxImages, &endpoint.MaxImageBytes); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateResponseCacheTTL(endpoint.ResponseCacheTTL); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := normalizeSemanticCache(endpoint.SemanticCacheTTL, endpoint.SemanticCacheThreshold, &endpoint.EmbeddingProviderID, &endpoint.EmbeddingModel); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Provider", "EmbeddingProvider").Create(&endpoint).Error; err != nil {
+			return err
+		}
+		if err := replaceEndpointAttempts(tx, endpoint.ID, attempts); err != nil {
+			return err
+		}
+		return replacePoolMembers(tx, endpoint.ID, poolMembers)
+	}); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create endpoint"})
 		return
 	}
@@ -185,24 +757,51 @@ func UpdateEndpoint(c *gin.Context) {
 
 	// 接收更新数据
 	var input struct {
-		Path                string  `json:"Path"`
-		ApiKey              string  `json:"ApiKey"`
-		ProviderID          uint    `json:"ProviderID"`
-		SelectedModel       string  `json:"SelectedModel"`
-		SystemPrompt        string  `json:"SystemPrompt"`
-		StreamOutput        bool    `json:"StreamOutput"`
-		EnableThinking      bool    `json:"EnableThinking"`
-		Temperature         float64 `json:"Temperature"`
-		FallbackProviderID1 uint    `json:"FallbackProviderID1"`
-		FallbackModel1      string  `json:"FallbackModel1"`
-		FallbackProviderID2 uint    `json:"FallbackProviderID2"`
-		FallbackModel2      string  `json:"FallbackModel2"`
+		Path                   string                      `json:"Path"`
+		ProviderID             uint                        `json:"ProviderID"`
+		SelectedModel          string                      `json:"SelectedModel"`
+		SystemPrompt           string                      `json:"SystemPrompt"`
+		UserTemplate           string                      `json:"UserTemplate"`
+		PromptTemplating       bool                        `json:"PromptTemplating"`
+		StreamOutput           bool                        `json:"StreamOutput"`
+		EnableThinking         bool                        `json:"EnableThinking"`
+		Temperature            float64                     `json:"Temperature"`
+		Attempts               []models.EndpointAttempt    `json:"Attempts"`
+		RoutingMode            string                      `json:"RoutingMode"`
+		PoolMembers            []models.EndpointPoolMember `json:"PoolMembers"`
+		StreamFallbackPolicy   string                      `json:"StreamFallbackPolicy"`
+		HedgeDelay             int                         `json:"HedgeDelay"`
+		RateLimitRPM           int64                       `json:"RateLimitRPM"`
+		RateLimitTPM           int64                       `json:"RateLimitTPM"`
+		DailyTokenQuota        int64                       `json:"DailyTokenQuota"`
+		MonthlyTokenQuota      int64                       `json:"MonthlyTokenQuota"`
+		DailyCostQuota         float64                     `json:"DailyCostQuota"`
+		MonthlyCostQuota       float64                     `json:"MonthlyCostQuota"`
+		SessionMaxTurns        int                         `json:"SessionMaxTurns"`
+		SessionMaxTokens       int                         `json:"SessionMaxTokens"`
+		JSONSchema             string                      `json:"JSONSchema"`
+		SchemaRepairRetries    int                         `json:"SchemaRepairRetries"`
+		Tools                  string                      `json:"Tools"`
+		ToolMode               string                      `json:"ToolMode"`
+		MaxToolRounds          int                         `json:"MaxToolRounds"`
+		AllowVision            bool                        `json:"AllowVision"`
+		MaxImages              int                         `json:"MaxImages"`
+		MaxImageBytes          int64                       `json:"MaxImageBytes"`
+		ResponseCacheTTL       int                         `json:"ResponseCacheTTL"`
+		SemanticCacheTTL       int                         `json:"SemanticCacheTTL"`
+		SemanticCacheThreshold float64                     `json:"SemanticCacheThreshold"`
+		EmbeddingProviderID    *uint                       `json:"EmbeddingProviderID"`
+		EmbeddingModel         string                      `json:"EmbeddingModel"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if services.IsReservedPath(input.Path) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Path is reserved by a built-in route"})
+		return
+	}
 
 	var provider models.AIProvider
 	if err := models.DB.First(&provider, input.ProviderID).Error; err != nil {
@@ -230,33 +829,121 @@ func UpdateEndpoint(c *gin.Context) {
 		return
 	}
 
-	if err := models.DB.Model(&models.APIEndpoint{}).Where("id = ?", id).Updates(map[string]interface{}{
-		"path":                  input.Path,
-		"api_key":               input.ApiKey,
-		"provider_id":           input.ProviderID,
-		"selected_model":        selectedModel,
-		"system_prompt":         input.SystemPrompt,
-		"stream_output":         input.StreamOutput,
-		"enable_thinking":       input.EnableThinking,
-		"temperature":           input.Temperature,
-		"fallback_provider_id1": input.FallbackProviderID1,
-		"fallback_model1":       input.FallbackModel1,
-		"fallback_provider_id2": input.FallbackProviderID2,
-		"fallback_model2":       input.FallbackModel2,
-	}).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update endpoint: " + err.Error()})
+	if err := normalizeAttempts(input.Attempts); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-
-	// 重新加载完整数据
-	var updatedEndpoint models.APIEndpoint
-	models.DB.Preload("Provider").First(&updatedEndpoint, id)
-
-	// 缓存处理
-	if oldPath != input.Path {
+	if err := normalizeRouting(&input.RoutingMode, input.PoolMembers); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := normalizeStreamFallbackPolicy(&input.StreamFallbackPolicy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateHedgeDelay(input.HedgeDelay); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateRateLimits(input.RateLimitRPM, input.RateLimitTPM); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateQuotas(input.DailyTokenQuota, input.MonthlyTokenQuota, input.DailyCostQuota, input.MonthlyCostQuota); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := normalizeSessionLimits(input.SessionMaxTurns, &input.SessionMaxTokens); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateEndpointTemplates(input.SystemPrompt, input.UserTemplate, input.PromptTemplating); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateStructuredOutput(input.JSONSchema, input.SchemaRepairRetries, input.StreamOutput); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := normalizeToolConfig(&input.Tools, &input.ToolMode, &input.MaxToolRounds, input.StreamOutput); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateToolProviders(input.Tools, input.ProviderID, input.Attempts, input.PoolMembers); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := normalizeVisionLimits(&input.MaxImages, &input.MaxImageBytes); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateResponseCacheTTL(input.ResponseCacheTTL); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := normalizeSemanticCache(input.SemanticCacheTTL, input.SemanticCacheThreshold, &input.EmbeddingProviderID, &input.EmbeddingModel); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.APIEndpoint{}).Where("id = ?", id).Updates(map[string]interface{}{
+			"path":                     input.Path,
+			"provider_id":              input.ProviderID,
+			"selected_model":           selectedModel,
+			"system_prompt":            input.SystemPrompt,
+			"user_template":            input.UserTemplate,
+			"prompt_templating":        input.PromptTemplating,
+			"stream_output":            input.StreamOutput,
+			"enable_thinking":          input.EnableThinking,
+			"temperature":              input.Temperature,
+			"routing_mode":             input.RoutingMode,
+			"stream_fallback_policy":   input.StreamFallbackPolicy,
+			"hedge_delay":              input.HedgeDelay,
+			"rate_limit_rpm":           input.RateLimitRPM,
+			"rate_limit_tpm":           input.RateLimitTPM,
+			"daily_token_quota":        input.DailyTokenQuota,
+			"monthly_token_quota":      input.MonthlyTokenQuota,
+			"daily_cost_quota":         input.DailyCostQuota,
+			"monthly_cost_quota":       input.MonthlyCostQuota,
+			"session_max_turns":        input.SessionMaxTurns,
+			"session_max_tokens":       input.SessionMaxTokens,
+			"json_schema":              strings.TrimSpace(input.JSONSchema),
+			"schema_repair_retries":    input.SchemaRepairRetries,
+			"tools":                    input.Tools,
+			"tool_mode":                input.ToolMode,
+			"max_tool_rounds":          input.MaxToolRounds,
+			"allow_vision":             input.AllowVision,
+			"max_images":               input.MaxImages,
+			"max_image_bytes":          input.MaxImageBytes,
+			"response_cache_ttl":       input.ResponseCacheTTL,
+			"semantic_cache_ttl":       input.SemanticCacheTTL,
+			"semantic_cache_threshold": input.SemanticCacheThreshold,
+			"embedding_provider_id":    input.EmbeddingProviderID,
+			"embedding_model":          input.EmbeddingModel,
+		}).Error; err != nil {
+			return err
+		}
+		if err := replaceEndpointAttempts(tx, oldEndpoint.ID, input.Attempts); err != nil {
+			return err
+		}
+		return replacePoolMembers(tx, oldEndpoint.ID, input.PoolMembers)
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update endpoint: " + err.Error()})
+		return
+	}
+
+	// 重新加载完整数据
+	var updatedEndpoint models.APIEndpoint
+	models.PreloadEndpoint(models.DB).First(&updatedEndpoint, id)
+
+	// 缓存处理
+	if oldPath != input.Path {
 		services.DeleteEndpointCache(oldPath)
 	}
 	services.UpdateEndpointCache(&updatedEndpoint)
+	// 配置变更后旧的响应缓存不会再被命中，直接清除
+	services.PurgeResponseCache(updatedEndpoint.ID)
 
 	c.JSON(http.StatusOK, updatedEndpoint)
 }
@@ -271,15 +958,650 @@ func DeleteEndpoint(c *gin.Context) {
 		return
 	}
 
-	// 删除数据库记录
-	if err := models.DB.Delete(&models.APIEndpoint{}, id).Error; err != nil {
+	// 删除数据库记录及其备用模型、负载均衡池
+	if err := models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("api_endpoint_id = ?", endpoint.ID).Delete(&models.EndpointAttempt{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("api_endpoint_id = ?", endpoint.ID).Delete(&models.EndpointPoolMember{}).Error; err != nil {
+			return err
+		}
+		// 删除该路径上的会话
+		if err := tx.Where("chat_session_id IN (?)", tx.Model(&models.ChatSession{}).Select("id").Where("api_endpoint_id = ?", endpoint.ID)).Delete(&models.SessionMessage{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("api_endpoint_id = ?", endpoint.ID).Delete(&models.ChatSession{}).Error; err != nil {
+			return err
+		}
+		// 解除虚拟 Key 与该路径的关联
+		if err := tx.Table("virtual_key_endpoints").Where("api_endpoint_id = ?", endpoint.ID).Delete(nil).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.VirtualKey{}).Where("default_endpoint_id = ?", endpoint.ID).Update("default_endpoint_id", 0).Error; err != nil {
+			return err
+		}
+		// 解除客户端 Key 与该路径的关联
+		if err := tx.Table("client_key_endpoints").Where("api_endpoint_id = ?", endpoint.ID).Delete(nil).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.APIEndpoint{}, id).Error
+	}); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete endpoint"})
 		return
 	}
 
 	// 删除缓存
 	services.DeleteEndpointCache(endpoint.Path)
+	services.PurgeResponseCache(endpoint.ID)
+	services.RefreshVirtualKeys()
+	services.RefreshClientKeys()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Deleted"})
+}
+
+// PurgeEndpointResponseCache 清除 API 路径的响应缓存
+func PurgeEndpointResponseCache(c *gin.Context) {
+	var endpoint models.APIEndpoint
+	if err := models.DB.First(&endpoint, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Endpoint not found"})
+		return
+	}
+	services.PurgeResponseCache(endpoint.ID)
+	c.JSON(http.StatusOK, gin.H{"message": "Purged"})
+}
+
+// --- Virtual Keys ---
+
+// virtualKeyInput 是创建与修改虚拟 Key 的请求体
+type virtualKeyInput struct {
+	Name              *string `json:"Name"`
+	Key               string  `json:"Key"`
+	Status            string  `json:"Status"`
+	EndpointIDs       []uint  `json:"EndpointIDs"`
+	DefaultEndpointID *uint   `json:"DefaultEndpointID"`
+	keyLimitsInput
+}
+
+// keyLimitsInput 是虚拟 Key 与客户端 Key 请求体中的限流与配额，未提供的字段不修改
+type keyLimitsInput struct {
+	RateLimitRPM      *int64   `json:"RateLimitRPM"`
+	RateLimitTPM      *int64   `json:"RateLimitTPM"`
+	DailyTokenQuota   *int64   `json:"DailyTokenQuota"`
+	MonthlyTokenQuota *int64   `json:"MonthlyTokenQuota"`
+	DailyCostQuota    *float64 `json:"DailyCostQuota"`
+	MonthlyCostQuota  *float64 `json:"MonthlyCostQuota"`
+}
+
+// applyKeyLimits 把请求中提供的限流与配额写入 key 并检查取值
+func applyKeyLimits(key *models.KeyLimits, input keyLimitsInput) error {
+	if input.RateLimitRPM != nil {
+		key.RateLimitRPM = *input.RateLimitRPM
+	}
+	if input.RateLimitTPM != nil {
+		key.RateLimitTPM = *input.RateLimitTPM
+	}
+	if input.DailyTokenQuota != nil {
+		key.DailyTokenQuota = *input.DailyTokenQuota
+	}
+	if input.MonthlyTokenQuota != nil {
+		key.MonthlyTokenQuota = *input.MonthlyTokenQuota
+	}
+	if input.DailyCostQuota != nil {
+		key.DailyCostQuota = *input.DailyCostQuota
+	}
+	if input.MonthlyCostQuota != nil {
+		key.MonthlyCostQuota = *input.MonthlyCostQuota
+	}
+	if err := validateRateLimits(key.RateLimitRPM, key.RateLimitTPM); err != nil {
+		return err
+	}
+	return validateQuotas(key.DailyTokenQuota, key.MonthlyTokenQuota, key.DailyCostQuota, key.MonthlyCostQuota)
+}
+
+// loadEndpointsByIDs 根据 ID 加载 API 路径，任意一个不存在时返回错误
+func loadEndpointsByIDs(ids []uint) ([]models.APIEndpoint, error) {
+	endpoints := make([]models.APIEndpoint, 0, len(ids))
+	if len(ids) == 0 {
+		return endpoints, nil
+	}
+	if err := models.DB.Where("id IN ?", ids).Find(&endpoints).Error; err != nil {
+		return nil, err
+	}
+	found := make(map[uint]bool, len(endpoints))
+	for _, e := range endpoints {
+		found[e.ID] = true
+	}
+	for _, id := range ids {
+		if !found[id] {
+			return nil, fmt.Errorf("endpoint %d not found", id)
+		}
+	}
+	return endpoints, nil
+}
+
+// issuedVirtualKey 是创建虚拟 Key 的响应，完整的 Key 只在此时返回一次
+type issuedVirtualKey struct {
+	models.VirtualKey
+	Key string
+}
+
+// GetVirtualKeys 获取所有虚拟 Key 及其可访问的 API 路径，不含完整的 Key
+func GetVirtualKeys(c *gin.Context) {
+	var keys []models.VirtualKey
+	models.DB.Preload("Endpoints").Find(&keys)
+	c.JSON(http.StatusOK, keys)
+}
+
+// CreateVirtualKey 创建虚拟 Key，Key 为空时自动生成；只保存 Key 的 SHA-256 与前缀，完整的 Key 只在响应中返回一次
+func CreateVirtualKey(c *gin.Context) {
+	var input virtualKeyInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	plaintext := strings.TrimSpace(input.Key)
+	if plaintext == "" {
+		var err error
+		if plaintext, err = services.GenerateVirtualKey(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate virtual key"})
+			return
+		}
+	}
+	key := models.VirtualKey{
+		Prefix:  models.ClientKeyPrefix(plaintext),
+		KeyHash: models.HashClientKey(plaintext),
+		Status:  models.VirtualKeyActive,
+	}
+	if input.Name != nil {
+		key.Name = strings.TrimSpace(*input.Name)
+	}
+	switch input.Status {
+	case "", models.VirtualKeyActive:
+	case models.VirtualKeyDisabled:
+		key.Status = models.VirtualKeyDisabled
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Status must be active or disabled"})
+		return
+	}
+
+	endpoints, err := loadEndpointsByIDs(input.EndpointIDs)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	key.Endpoints = endpoints
+	if input.DefaultEndpointID != nil {
+		key.DefaultEndpointID = *input.DefaultEndpointID
+	}
+	if !containsEndpoint(endpoints, key.DefaultEndpointID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "DefaultEndpointID must be one of EndpointIDs"})
+		return
+	}
+	if err := applyKeyLimits(&key.KeyLimits, input.keyLimitsInput); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var count int64
+	models.DB.Model(&models.VirtualKey{}).Where("key_hash = ?", key.KeyHash).Count(&count)
+	if count > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Key already exists"})
+		return
+	}
+
+	if err := models.DB.Omit("Endpoints.*").Create(&key).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create virtual key"})
+		return
+	}
+	services.RefreshVirtualKeys()
+	c.JSON(http.StatusOK, issuedVirtualKey{VirtualKey: key, Key: plaintext})
+}
+
+// UpdateVirtualKey 修改虚拟 Key 的名称、状态和可访问的 API 路径，EndpointIDs 为空时不修改路径
+func UpdateVirtualKey(c *gin.Context) {
+	var key models.VirtualKey
+	if err := models.DB.Preload("Endpoints").First(&key, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Virtual key not found"})
+		return
+	}
+
+	var input virtualKeyInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updates := map[string]interface{}{}
+	if input.Name != nil {
+		updates["name"] = strings.TrimSpace(*input.Name)
+	}
+	switch input.Status {
+	case "":
+	case models.VirtualKeyActive, models.VirtualKeyDisabled:
+		updates["status"] = input.Status
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Status must be active or disabled"})
+		return
+	}
 
+	endpoints := key.Endpoints
+	if input.EndpointIDs != nil {
+		var err error
+		if endpoints, err = loadEndpointsByIDs(input.EndpointIDs); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	defaultEndpointID := key.DefaultEndpointID
+	if input.DefaultEndpointID != nil {
+		defaultEndpointID = *input.DefaultEndpointID
+	}
+	if !containsEndpoint(endpoints, defaultEndpointID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "DefaultEndpointID must be one of EndpointIDs"})
+		return
+	}
+	updates["default_endpoint_id"] = defaultEndpointID
+	limits := key.KeyLimits
+	if err := applyKeyLimits(&limits, input.keyLimitsInput); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	updates["rate_limit_rpm"] = limits.RateLimitRPM
+	updates["rate_limit_tpm"] = limits.RateLimitTPM
+	updates["daily_token_quota"] = limits.DailyTokenQuota
+	updates["monthly_token_quota"] = limits.MonthlyTokenQuota
+	updates["daily_cost_quota"] = limits.DailyCostQuota
+	updates["monthly_cost_quota"] = limits.MonthlyCostQuota
+
+	if err := models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&key).Updates(updates).Error; err != nil {
+			return err
+		}
+		if input.EndpointIDs != nil {
+			return tx.Model(&key).Omit("Endpoints.*").Association("Endpoints").Replace(endpoints)
+		}
+		return nil
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update virtual key"})
+		return
+	}
+	services.RefreshVirtualKeys()
+
+	models.DB.Preload("Endpoints").First(&key, key.ID)
+	c.JSON(http.StatusOK, key)
+}
+
+// DeleteVirtualKey 删除虚拟 Key，删除后立即失效
+func DeleteVirtualKey(c *gin.Context) {
+	var key models.VirtualKey
+	if err := models.DB.First(&key, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Virtual key not found"})
+		return
+	}
+
+	if err := models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&key).Association("Endpoints").Clear(); err != nil {
+			return err
+		}
+		// 直接删除记录，释放 Key 的唯一索引
+		return tx.Unscoped().Delete(&key).Error
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete virtual key"})
+		return
+	}
+	services.RefreshVirtualKeys()
+	c.JSON(http.StatusOK, gin.H{"message": "Deleted"})
+}
+
+// containsEndpoint 判断 id 是否为 0 或在 endpoints 中
+func containsEndpoint(endpoints []models.APIEndpoint, id uint) bool {
+	if id == 0 {
+		return true
+	}
+	for _, e := range endpoints {
+		if e.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// --- Client Keys ---
+
+// defaultClientKeyGracePeriod 是轮换客户端 Key 时未指定宽限期的默认值（秒）
+const defaultClientKeyGracePeriod = 24 * 60 * 60
+
+// clientKeyInput 是签发与修改客户端 Key 的请求体
+type clientKeyInput struct {
+	Name        *string    `json:"Name"`
+	Scopes      []string   `json:"Scopes"`
+	EndpointIDs []uint     `json:"EndpointIDs"`
+	ExpiresAt   *time.Time `json:"ExpiresAt"`
+	keyLimitsInput
+}
+
+// clientKeyUpdateInput 是修改客户端 Key 的请求体，ExpiresAt 为 null 时取消过期时间
+type clientKeyUpdateInput struct {
+	clientKeyInput
+	ExpiresAt optionalTime `json:"ExpiresAt"`
+}
+
+// optionalTime 区分请求体中未提供的时间字段与显式的 null
+type optionalTime struct {
+	Set   bool
+	Value *time.Time // 为 null 时为空
+}
+
+func (t *optionalTime) UnmarshalJSON(data []byte) error {
+	t.Set = true
+	if string(data) == "null" {
+		t.Value = nil
+		return nil
+	}
+	var value time.Time
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	t.Value = &value
+	return nil
+}
+
+// issuedClientKey 是签发与轮换客户端 Key 的响应，完整的 Key 只在此时返回一次
+type issuedClientKey struct {
+	models.ClientKey
+	Key string
+}
+
+// normalizeClientKeyScopes 检查权限范围并按固定顺序去重，为空时返回全部权限
+func normalizeClientKeyScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return models.ClientKeyScopes, nil
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(models.ClientKeyScopes, scope) {
+			return nil, fmt.Errorf("unsupported scope: %s", scope)
+		}
+	}
+	var result []string
+	for _, scope := range models.ClientKeyScopes {
+		if slices.Contains(scopes, scope) {
+			result = append(result, scope)
+		}
+	}
+	return result, nil
+}
+
+// GetClientKeys 获取客户端 Key 列表（不含完整的 Key），可通过 endpoint_id 筛选能访问某个路径的 Key
+func GetClientKeys(c *gin.Context) {
+	query := models.DB.Preload("Endpoints").Order("id")
+	if endpointID := c.Query("endpoint_id"); endpointID != "" {
+		query = query.Where("id IN (?)", models.DB.Table("client_key_endpoints").Select("client_key_id").Where("api_endpoint_id = ?", endpointID))
+	}
+	var keys []models.ClientKey
+	query.Find(&keys)
+	c.JSON(http.StatusOK, keys)
+}
+
+// IssueClientKey 签发客户端 Key，EndpointIDs 不能为空，Scopes 为空时拥有全部权限
+func IssueClientKey(c *gin.Context) {
+	var input clientKeyInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	endpoints, err := loadEndpointsByIDs(input.EndpointIDs)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(endpoints) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "EndpointIDs cannot be empty"})
+		return
+	}
+	scopes, err := normalizeClientKeyScopes(input.Scopes)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.ExpiresAt != nil && !input.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ExpiresAt must be in the future"})
+		return
+	}
+	var limits models.KeyLimits
+	if err := applyKeyLimits(&limits, input.keyLimitsInput); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	plaintext, err := services.GenerateClientKey()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate client key"})
+		return
+	}
+	key := models.ClientKey{
+		Prefix:    models.ClientKeyPrefix(plaintext),
+		KeyHash:   models.HashClientKey(plaintext),
+		Scopes:    scopes,
+		Endpoints: endpoints,
+		ExpiresAt: input.ExpiresAt,
+		KeyLimits: limits,
+	}
+	if input.Name != nil {
+		key.Name = strings.TrimSpace(*input.Name)
+	}
+	if err := models.DB.Omit("Endpoints.*").Create(&key).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue client key"})
+		return
+	}
+	services.RefreshClientKeys()
+	c.JSON(http.StatusOK, issuedClientKey{ClientKey: key, Key: plaintext})
+}
+
+// UpdateClientKey 修改客户端 Key 的名称、权限范围、可访问的 API 路径、过期时间、限流或配额，未提供的字段不修改。
+// ExpiresAt 为 null 时取消过期时间；修改后的过期时间必须晚于当前时间，与原值相同时不检查。
+func UpdateClientKey(c *gin.Context) {
+	var key models.ClientKey
+	if err := models.DB.Preload("Endpoints").First(&key, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Client key not found"})
+		return
+	}
+
+	var input clientKeyUpdateInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if input.Name != nil {
+		key.Name = strings.TrimSpace(*input.Name)
+	}
+	if input.Scopes != nil {
+		scopes, err := normalizeClientKeyScopes(input.Scopes)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		key.Scopes = scopes
+	}
+	if input.ExpiresAt.Set {
+		expiresAt := input.ExpiresAt.Value
+		unchanged := expiresAt != nil && key.ExpiresAt != nil && expiresAt.Equal(*key.ExpiresAt)
+		if expiresAt != nil && !unchanged && !expiresAt.After(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ExpiresAt must be in the future"})
+			return
+		}
+		key.ExpiresAt = expiresAt
+	}
+	if err := applyKeyLimits(&key.KeyLimits, input.keyLimitsInput); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	endpoints := key.Endpoints
+	if input.EndpointIDs != nil {
+		var err error
+		if endpoints, err = loadEndpointsByIDs(input.EndpointIDs); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if len(endpoints) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "EndpointIDs cannot be empty"})
+			return
+		}
+	}
+
+	if err := models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&key).Select("name", "scopes", "expires_at", "rate_limit_rpm", "rate_limit_tpm",
+			"daily_token_quota", "monthly_token_quota", "daily_cost_quota", "monthly_cost_quota").Updates(&key).Error; err != nil {
+			return err
+		}
+		if input.EndpointIDs != nil {
+			return tx.Model(&key).Omit("Endpoints.*").Association("Endpoints").Replace(endpoints)
+		}
+		return nil
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update client key"})
+		return
+	}
+	services.RefreshClientKeys()
+
+	models.DB.Preload("Endpoints").First(&key, key.ID)
+	c.JSON(http.StatusOK, key)
+}
+
+// RevokeClientKey 吊销客户端 Key，吊销后立即失效且不能恢复
+func RevokeClientKey(c *gin.Context) {
+	var key models.ClientKey
+	if err := models.DB.First(&key, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Client key not found"})
+		return
+	}
+
+	if !key.Revoked {
+		now := time.Now()
+		if err := models.DB.Model(&key).Updates(map[string]interface{}{"revoked": true, "revoked_at": now}).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke client key"})
+			return
+		}
+		services.RefreshClientKeys()
+	}
+
+	models.DB.Preload("Endpoints").First(&key, key.ID)
+	c.JSON(http.StatusOK, key)
+}
+
+// RotateClientKey 签发一个名称、权限范围、API 路径、过期时间、限流与配额都相同的新 Key 替代旧 Key。
+// 旧 Key 在宽限期（GracePeriod 秒，默认一天，0 表示立即失效）内仍然有效，便于客户端切换；
+// 新旧 Key 共用轮换链中最初的 Key 的限流桶与用量。
+func RotateClientKey(c *gin.Context) {
+	var old models.ClientKey
+	if err := models.DB.Preload("Endpoints").First(&old, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Client key not found"})
+		return
+	}
+
+	var input struct {
+		GracePeriod *int `json:"GracePeriod"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	gracePeriod := defaultClientKeyGracePeriod
+	if input.GracePeriod != nil {
+		gracePeriod = *input.GracePeriod
+	}
+	if gracePeriod < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "GracePeriod cannot be negative"})
+		return
+	}
+
+	now := time.Now()
+	if !services.ClientKeyActive(&old, now) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Revoked or expired client keys cannot be rotated"})
+		return
+	}
+
+	plaintext, err := services.GenerateClientKey()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate client key"})
+		return
+	}
+	rootID := old.RootID
+	if rootID == 0 {
+		rootID = old.ID
+	}
+	key := models.ClientKey{
+		Name:      old.Name,
+		Prefix:    models.ClientKeyPrefix(plaintext),
+		KeyHash:   models.HashClientKey(plaintext),
+		Scopes:    old.Scopes,
+		Endpoints: old.Endpoints,
+		ExpiresAt: old.ExpiresAt,
+		RootID:    rootID,
+		KeyLimits: old.KeyLimits,
+	}
+	graceEnd := now.Add(time.Duration(gracePeriod) * time.Second)
+	if old.ExpiresAt != nil && old.ExpiresAt.Before(graceEnd) {
+		graceEnd = *old.ExpiresAt
+	}
+	if err := models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Endpoints.*").Create(&key).Error; err != nil {
+			return err
+		}
+		return tx.Model(&old).Updates(map[string]interface{}{"expires_at": graceEnd, "replaced_by_id": key.ID}).Error
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rotate client key"})
+		return
+	}
+	services.RefreshClientKeys()
+	c.JSON(http.StatusOK, issuedClientKey{ClientKey: key, Key: plaintext})
+}
+
+// --- Sessions ---
+
+// GetSessions 分页列出会话，可按 endpoint_id 过滤
+func GetSessions(c *gin.Context) {
+	endpointID, _ := strconv.ParseUint(c.Query("endpoint_id"), 10, 64)
+	page, _ := strconv.Atoi(c.Query("page"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+
+	sessions, total, err := services.ListSessions(uint(endpointID), nil, page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list sessions"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"total": total, "items": sessions})
+}
+
+// GetSession 获取会话及其全部消息
+func GetSession(c *gin.Context) {
+	var session models.ChatSession
+	if err := models.DB.First(&session, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+	if err := services.LoadSessionMessages(&session); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load session messages"})
+		return
+	}
+	c.JSON(http.StatusOK, session)
+}
+
+// DeleteSession 删除会话及其消息
+func DeleteSession(c *gin.Context) {
+	var session models.ChatSession
+	if err := models.DB.First(&session, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+	if err := services.DeleteSession(&session); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete session"})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"message": "Deleted"})
 }
 
@@ -301,11 +1623,41 @@ func GetStats(c *gin.Context) {
 		"total_count": count,
 		"data":        stats,
 		"date_filter": date,
+		"quotas":      services.QuotaOverviews(),
 	}
 
 	c.JSON(http.StatusOK, result)
 }
 
+// --- Circuit Breakers ---
+
+// GetBreakers 获取各供应商/模型的熔断器状态与最近的状态变化
+func GetBreakers(c *gin.Context) {
+	statuses, events := services.GetBreakers()
+	c.JSON(http.StatusOK, gin.H{
+		"breakers": statuses,
+		"events":   events,
+	})
+}
+
+// ResetBreaker 手动关闭指定供应商/模型的熔断器
+func ResetBreaker(c *gin.Context) {
+	var input struct {
+		ProviderID uint   `binding:"required"`
+		ModelName  string `binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !services.ResetBreaker(services.MemberKey(input.ProviderID, input.ModelName)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Circuit breaker not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Circuit breaker reset"})
+}
+
 // --- User Management ---
 
 // GetUserInfo 获取当前用户信息
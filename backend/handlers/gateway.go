@@ -0,0 +1,560 @@
+package handlers
+
+import (
+	"ai-api-platform/backend/models"
+	"ai-api-platform/backend/providers"
+	"ai-api-platform/backend/services"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// gatewayKnownFields 是网关自行解析的请求字段，其余字段放入 ChatRequest.Extra 原样透传
+var gatewayKnownFields = map[string]bool{
+	"model": true, "messages": true, "stream": true, "stream_options": true,
+	"temperature": true, "top_p": true, "max_tokens": true, "stop": true, "n": true,
+	"tools": true, "tool_choice": true, "response_format": true, "variables": true,
+}
+
+// gatewayRequest 是 /v1/chat/completions 的请求体
+type gatewayRequest struct {
+	Model         string           `json:"model"`
+	Messages      []gatewayMessage `json:"messages"`
+	Stream        bool             `json:"stream"`
+	StreamOptions *struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options"`
+	Temperature         *float64        `json:"temperature"`
+	TopP                *float64        `json:"top_p"`
+	MaxTokens           int64           `json:"max_tokens"`
+	MaxCompletionTokens int64           `json:"max_completion_tokens"`
+	Stop                json.RawMessage `json:"stop"`
+	N                   int             `json:"n"`
+	Tools               []struct {
+		Type     string `json:"type"`
+		Function struct {
+			Name        string          `json:"name"`
+			Description string          `json:"description"`
+			Parameters  json.RawMessage `json:"parameters"`
+		} `json:"function"`
+	} `json:"tools"`
+	ToolChoice     json.RawMessage        `json:"tool_choice"`
+	ResponseFormat json.RawMessage        `json:"response_format"`
+	Variables      map[string]interface{} `json:"variables"` // 渲染 API 路径系统提示词模板的变量，不透传给上游
+
+	images messageImages // user 消息中的图片，解析 API 路径后按其多模态配置检查
+}
+
+type gatewayMessage struct {
+	Role       string           `json:"role"`
+	Content    json.RawMessage  `json:"content"`
+	Name       string           `json:"name,omitempty"`
+	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+type openAIToolCall struct {
+	Index    *int   `json:"index,omitempty"`
+	ID       string `json:"id,omitempty"`
+	Type     string `json:"type,omitempty"`
+	Function struct {
+		Name      string `json:"name,omitempty"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// gatewayUsage 是 OpenAI 格式的用量，上游返回了缓存或推理 Token 时附带明细
+type gatewayUsage struct {
+	PromptTokens            int64                `json:"prompt_tokens"`
+	CompletionTokens        int64                `json:"completion_tokens"`
+	TotalTokens             int64                `json:"total_tokens"`
+	PromptTokensDetails     *promptTokensDetails `json:"prompt_tokens_details,omitempty"`
+	CompletionTokensDetails *completionDetails   `json:"completion_tokens_details,omitempty"`
+}
+
+type promptTokensDetails struct {
+	CachedTokens int64 `json:"cached_tokens"`
+}
+
+type completionDetails struct {
+	ReasoningTokens int64 `json:"reasoning_tokens"`
+}
+
+func newGatewayUsage(usage providers.Usage) *gatewayUsage {
+	result := &gatewayUsage{
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		TotalTokens:      usage.PromptTokens + usage.CompletionTokens,
+	}
+	if usage.CachedTokens > 0 {
+		result.PromptTokensDetails = &promptTokensDetails{CachedTokens: usage.CachedTokens}
+	}
+	if usage.ReasoningTokens > 0 {
+		result.CompletionTokensDetails = &completionDetails{ReasoningTokens: usage.ReasoningTokens}
+	}
+	return result
+}
+
+// openAIError 返回 OpenAI 格式的错误，便于 OpenAI SDK 解析
+func openAIError(c *gin.Context, status int, errType, message string) {
+	c.JSON(status, gin.H{"error": gin.H{"message": message, "type": errType}})
+}
+
+// gatewayVirtualKey 从 Authorization: Bearer 头中取出并校验虚拟 Key
+func gatewayVirtualKey(c *gin.Context) (*models.VirtualKey, bool) {
+	token := strings.TrimSpace(strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "))
+	if token == "" {
+		openAIError(c, http.StatusUnauthorized, "invalid_request_error", "Missing API key in Authorization header")
+		return nil, false
+	}
+	vk, ok := services.GetVirtualKey(token)
+	if !ok {
+		openAIError(c, http.StatusUnauthorized, "invalid_request_error", "Invalid API key")
+		return nil, false
+	}
+	return vk, true
+}
+
+// parseMessageContent 解析消息内容，支持字符串、null 与由文本、图片（image_url）片段组成的数组
+func parseMessageContent(raw json.RawMessage) (string, []ProxyImage, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", nil, nil
+	}
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text, nil, nil
+	}
+
+	var parts []struct {
+		Type     string `json:"type"`
+		Text     string `json:"text"`
+		ImageURL *struct {
+			URL string `json:"url"`
+		} `json:"image_url"`
+	}
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return "", nil, errors.New("content must be a string or an array of content parts")
+	}
+	var builder strings.Builder
+	var images []ProxyImage
+	for _, part := range parts {
+		switch {
+		case part.Type == "text":
+			builder.WriteString(part.Text)
+		case part.Type == "image_url" && part.ImageURL != nil:
+			images = append(images, ProxyImage{URL: part.ImageURL.URL})
+		default:
+			return "", nil, fmt.Errorf("content part type %q is not supported", part.Type)
+		}
+	}
+	return builder.String(), images, nil
+}
+
+// parseStop 解析 stop 字段，支持字符串或字符串数组
+func parseStop(raw json.RawMessage) ([]string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var stop string
+	if err := json.Unmarshal(raw, &stop); err == nil {
+		return []string{stop}, nil
+	}
+	var stops []string
+	if err := json.Unmarshal(raw, &stops); err != nil {
+		return nil, errors.New("stop must be a string or an array of strings")
+	}
+	return stops, nil
+}
+
+// toChatRequest 将网关请求转换为统一的聊天请求模板，Model 与 Temperature 在每次尝试时再填入
+func (r *gatewayRequest) toChatRequest(body map[string]json.RawMessage) (*providers.ChatRequest, error) {
+	if len(r.Messages) == 0 {
+		return nil, errors.New("messages is required")
+	}
+	if r.N < 0 {
+		return nil, errors.New("n must be positive")
+	}
+
+	req := &providers.ChatRequest{
+		TopP:           r.TopP,
+		MaxTokens:      r.MaxTokens,
+		N:              r.N,
+		ToolChoice:     r.ToolChoice,
+		ResponseFormat: r.ResponseFormat,
+	}
+	if req.MaxTokens == 0 {
+		req.MaxTokens = r.MaxCompletionTokens
+	}
+
+	stop, err := parseStop(r.Stop)
+	if err != nil {
+		return nil, err
+	}
+	req.Stop = stop
+
+	for _, tool := range r.Tools {
+		if tool.Type != "function" {
+			return nil, fmt.Errorf("tool type %q is not supported", tool.Type)
+		}
+		req.Tools = append(req.Tools, providers.Tool{
+			Name:        tool.Function.Name,
+			Description: tool.Function.Description,
+			Parameters:  tool.Function.Parameters,
+		})
+	}
+
+	for i, m := range r.Messages {
+		content, images, err := parseMessageContent(m.Content)
+		if err != nil {
+			return nil, fmt.Errorf("messages[%d]: %v", i, err)
+		}
+		if len(images) > 0 && m.Role != "user" {
+			return nil, fmt.Errorf("messages[%d]: images are only supported in user messages", i)
+		}
+		r.images.add(len(req.Messages), images)
+		message := providers.Message{
+			Role:       m.Role,
+			Content:    content,
+			Name:       m.Name,
+			ToolCallID: m.ToolCallID,
+		}
+		for _, call := range m.ToolCalls {
+			message.ToolCalls = append(message.ToolCalls, providers.ToolCall{
+				ID:        call.ID,
+				Name:      call.Function.Name,
+				Arguments: call.Function.Arguments,
+			})
+		}
+		req.Messages = append(req.Messages, message)
+	}
+
+	for k, v := range body {
+		if gatewayKnownFields[k] {
+			continue
+		}
+		if req.Extra == nil {
+			req.Extra = make(map[string]json.RawMessage)
+		}
+		req.Extra[k] = v
+	}
+	return req, nil
+}
+
+// hasSystemMessage 判断客户端是否发送了 system 消息
+func hasSystemMessage(req *providers.ChatRequest) bool {
+	for _, m := range req.Messages {
+		if m.Role == "system" || m.Role == "developer" {
+			return true
+		}
+	}
+	return false
+}
+
+// gatewaySystemPrompt 以请求中的 variables 渲染 API 路径的系统提示词，内置的 content 为最后一条 user 消息。
+// 客户端发送了 system 消息时不使用系统提示词，返回空字符串；API 路径没有开启 PromptTemplating 时原样返回。
+func gatewaySystemPrompt(endpoint *models.APIEndpoint, template *providers.ChatRequest, variables map[string]interface{}) (string, error) {
+	if hasSystemMessage(template) {
+		return "", nil
+	}
+	if !endpoint.PromptTemplating {
+		return endpoint.SystemPrompt, nil
+	}
+	ctx := services.PromptContext{Path: endpoint.Path}
+	for i := len(template.Messages) - 1; i >= 0; i-- {
+		if template.Messages[i].Role == "user" {
+			ctx.Content = template.Messages[i].Content
+			break
+		}
+	}
+	return services.RenderPrompt(endpoint.SystemPrompt, variables, ctx)
+}
+
+// gatewayBuilder 为每次尝试复制请求模板，填入尝试的模型与温度；systemPrompt 非空时插入到消息开头
+func gatewayBuilder(endpoint *models.APIEndpoint, template *providers.ChatRequest, systemPrompt string, temperature *float64) requestBuilder {
+	return func(attempt ModelAttempt) *providers.ChatRequest {
+		req := *template
+		req.Model = attempt.ModelName
+		req.Temperature = attempt.Temperature
+		if temperature != nil {
+			req.Temperature = *temperature
+		}
+		req.EnableThinking = endpoint.EnableThinking
+		if systemPrompt != "" {
+			req.Messages = append([]providers.Message{{Role: "system", Content: systemPrompt}}, template.Messages...)
+		}
+		return &req
+	}
+}
+
+// newCompletionID 生成 chat.completion 的 ID
+func newCompletionID() string {
+	buf := make([]byte, 12)
+	rand.Read(buf)
+	return "chatcmpl-" + hex.EncodeToString(buf)
+}
+
+// OpenAIChatCompletions 是 OpenAI 兼容的 /v1/chat/completions 网关。
+// 使用虚拟 Key 鉴权，model 对应虚拟 Key 可访问的 API 路径，复用该路径的路由、备用模型、熔断与统计。
+func OpenAIChatCompletions(c *gin.Context) {
+	vk, ok := gatewayVirtualKey(c)
+	if !ok {
+		return
+	}
+
+	var body map[string]json.RawMessage
+	if err := c.ShouldBindJSON(&body); err != nil {
+		openAIError(c, http.StatusBadRequest, "invalid_request_error", "Invalid JSON body: "+err.Error())
+		return
+	}
+	raw, _ := json.Marshal(body)
+	var req gatewayRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		openAIError(c, http.StatusBadRequest, "invalid_request_error", "Invalid request: "+err.Error())
+		return
+	}
+	template, err := req.toChatRequest(body)
+	if err != nil {
+		openAIError(c, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+
+	endpoint, ok := services.ResolveVirtualKeyEndpoint(vk, req.Model)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": gin.H{
+			"message": fmt.Sprintf("The model '%s' does not exist or you do not have access to it", req.Model),
+			"type":    "invalid_request_error",
+			"code":    "model_not_found",
+		}})
+		return
+	}
+
+	if err := req.images.attach(endpoint, template); err != nil {
+		openAIError(c, imageErrorStatus(err), "invalid_request_error", err.Error())
+		return
+	}
+
+	limitedKey := services.VirtualKeyLimits(vk)
+	if err := checkQuota(c, endpoint, limitedKey); err != nil {
+		openAIError(c, http.StatusTooManyRequests, "insufficient_quota", err.Error())
+		return
+	}
+	if !allowRateLimit(c, endpoint, limitedKey) {
+		openAIError(c, http.StatusTooManyRequests, "rate_limit_exceeded", "Rate limit exceeded, please retry later")
+		return
+	}
+
+	attempts, err := buildAttemptsList(endpoint)
+	if errors.Is(err, services.ErrCircuitOpen) {
+		openAIError(c, http.StatusServiceUnavailable, "server_error", "All upstream models are temporarily unavailable (circuit open)")
+		return
+	}
+	if err != nil || len(attempts) == 0 {
+		openAIError(c, http.StatusInternalServerError, "server_error", "No model configured for provider")
+		return
+	}
+
+	systemPrompt, err := gatewaySystemPrompt(endpoint, template, req.Variables)
+	if err != nil {
+		openAIError(c, http.StatusBadRequest, "invalid_request_error", "Failed to render system prompt: "+err.Error())
+		return
+	}
+
+	build := gatewayBuilder(endpoint, template, systemPrompt, req.Temperature)
+	if attempts, err = filterUnsupported(attempts, build); err != nil {
+		openAIError(c, http.StatusBadRequest, "invalid_request_error", "No configured model supports this request: "+err.Error())
+		return
+	}
+	cache := newResponseCache(endpoint, attempts, build)
+	if req.Stream {
+		out := &gatewayStreamOutput{
+			id:           newCompletionID(),
+			model:        req.Model,
+			created:      time.Now().Unix(),
+			includeUsage: req.StreamOptions != nil && req.StreamOptions.IncludeUsage,
+			roleSent:     make(map[int]bool),
+		}
+		// 多个候选回复的流式输出交错在一起，无法记录为一条缓存的回答
+		if template.N > 1 {
+			cache = nil
+		}
+		streamWithCache(c, endpoint, attempts, build, out, cache)
+		return
+	}
+
+	completion, err := completeWithCache(c, endpoint, attempts, build, cache)
+	if c.Request.Context().Err() != nil {
+		return
+	}
+	if err != nil {
+		if providers.Classify(err) == providers.ErrorFatal {
+			openAIError(c, providers.StatusCode(err), "invalid_request_error", "Upstream rejected the request: "+err.Error())
+			return
+		}
+		openAIError(c, http.StatusBadGateway, "server_error", "All model attempts failed: "+err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gatewayCompletion(completion, req.Model))
+}
+
+// completeWithCache 在 cache 非空时先查询响应缓存，未命中时调用模型并在成功后写入缓存
+func completeWithCache(c *gin.Context, endpoint *models.APIEndpoint, attempts []ModelAttempt, build requestBuilder, cache *responseCacheRecorder) (*providers.ChatResponse, error) {
+	if cache != nil {
+		if completion, ok := cache.lookup(c); ok {
+			return completion, nil
+		}
+	}
+	completion, err := completeWithFallback(c, endpoint, attempts, build, nil)
+	if err == nil && cache != nil {
+		cache.store(completion)
+	}
+	return completion, err
+}
+
+// gatewayCompletion 将统一的非流式结果转换为 chat.completion 响应
+func gatewayCompletion(completion *providers.ChatResponse, model string) gin.H {
+	choices := completion.Choices
+	if len(choices) == 0 {
+		choices = []providers.Choice{{Message: providers.Message{Role: completion.Role, Content: completion.Content}, FinishReason: "stop"}}
+	}
+
+	items := make([]gin.H, 0, len(choices))
+	for _, choice := range choices {
+		message := gin.H{"role": "assistant", "content": choice.Message.Content}
+		if len(choice.Message.ToolCalls) > 0 {
+			if choice.Message.Content == "" {
+				message["content"] = nil
+			}
+			message["tool_calls"] = openAIToolCalls(choice.Message.ToolCalls, false)
+		}
+		finishReason := choice.FinishReason
+		if finishReason == "" {
+			finishReason = "stop"
+		}
+		items = append(items, gin.H{
+			"index":         choice.Index,
+			"message":       message,
+			"finish_reason": finishReason,
+		})
+	}
+
+	id := completion.ID
+	if id == "" {
+		id = newCompletionID()
+	}
+	return gin.H{
+		"id":      id,
+		"object":  "chat.completion",
+		"created": time.Now().Unix(),
+		"model":   model,
+		"choices": items,
+		"usage":   newGatewayUsage(completion.Usage),
+	}
+}
+
+// openAIToolCalls 转换工具调用，delta 为 true 时输出流式增量格式（带 index，空字段省略）
+func openAIToolCalls(calls []providers.ToolCall, delta bool) []openAIToolCall {
+	result := make([]openAIToolCall, 0, len(calls))
+	for _, call := range calls {
+		item := openAIToolCall{ID: call.ID}
+		item.Function.Name = call.Name
+		item.Function.Arguments = call.Arguments
+		if call.ID != "" || !delta {
+			item.Type = "function"
+		}
+		if delta {
+			index := call.Index
+			item.Index = &index
+		}
+		result = append(result, item)
+	}
+	return result
+}
+
+// gatewayStreamOutput 输出 chat.completion.chunk 格式的 SSE 帧
+type gatewayStreamOutput struct {
+	id           string
+	model        string
+	created      int64
+	includeUsage bool
+	roleSent     map[int]bool // 已经输出过 role 的候选回复
+}
+
+func (o *gatewayStreamOutput) frame(choices []gin.H) gin.H {
+	return gin.H{
+		"id":      o.id,
+		"object":  "chat.completion.chunk",
+		"created": o.created,
+		"model":   o.model,
+		"choices": choices,
+	}
+}
+
+func (o *gatewayStreamOutput) writeChunk(c *gin.Context, chunk providers.StreamChunk) {
+	delta := gin.H{}
+	if !o.roleSent[chunk.Index] {
+		delta["role"] = "assistant"
+		o.roleSent[chunk.Index] = true
+	}
+	if chunk.Content != "" {
+		delta["content"] = chunk.Content
+	}
+	if len(chunk.ToolCalls) > 0 {
+		delta["tool_calls"] = openAIToolCalls(chunk.ToolCalls, true)
+	}
+
+	var finishReason interface{}
+	if chunk.FinishReason != "" {
+		finishReason = chunk.FinishReason
+	}
+	writeSSE(c, o.frame([]gin.H{{"index": chunk.Index, "delta": delta, "finish_reason": finishReason}}))
+}
+
+// writeFallback 返回 false：OpenAI SDK 会把重置帧当作普通的 chunk 解析，reset 策略按 buffer 处理，以错误帧结束
+func (o *gatewayStreamOutput) writeFallback(c *gin.Context, nextAttempt int) bool {
+	return false
+}
+
+func (o *gatewayStreamOutput) writeDone(c *gin.Context, usage providers.Usage) {
+	if o.includeUsage {
+		frame := o.frame([]gin.H{})
+		frame["usage"] = newGatewayUsage(usage)
+		writeSSE(c, frame)
+	}
+	c.Writer.Write([]byte("data: [DONE]\n\n"))
+	c.Writer.Flush()
+}
+
+func (o *gatewayStreamOutput) writeError(c *gin.Context, message string, err error) {
+	if err != nil {
+		message += ": " + err.Error()
+	}
+	writeSSE(c, gin.H{"error": gin.H{"message": message, "type": "server_error"}})
+	c.Writer.Write([]byte("data: [DONE]\n\n"))
+	c.Writer.Flush()
+}
+
+// OpenAIListModels 以 OpenAI 的 /v1/models 格式列出虚拟 Key 可访问的 API 路径
+func OpenAIListModels(c *gin.Context) {
+	vk, ok := gatewayVirtualKey(c)
+	if !ok {
+		return
+	}
+
+	data := make([]gin.H, 0, len(vk.Endpoints))
+	for _, endpoint := range services.VirtualKeyEndpoints(vk) {
+		data = append(data, gin.H{
+			"id":       services.EndpointModelName(endpoint),
+			"object":   "model",
+			"created":  endpoint.CreatedAt.Unix(),
+			"owned_by": "ai-api-platform",
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"object": "list", "data": data})
+}
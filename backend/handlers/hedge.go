@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"ai-api-platform/backend/models"
+	"ai-api-platform/backend/providers"
+	"ai-api-platform/backend/services"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// attemptResult 是对冲调用中一个尝试的结果
+type attemptResult struct {
+	index      int
+	completion *providers.ChatResponse
+	err        error
+	lost       bool // 其他尝试已经胜出，结果不再使用
+}
+
+// hedgeRace 记录对冲调用中最先成功的尝试，其余尝试结束后把消耗的 Token 计为对冲的额外开销
+type hedgeRace struct {
+	mu         sync.Mutex
+	endpointID uint
+	winner     int // 最先成功的尝试序号，-1 表示还没有尝试成功
+
+	// 请求的限流与配额对象，落选尝试可能在 completeHedged 返回后才结束，此时不能再使用 gin.Context
+	rateLimits   []services.RateLimit
+	quotaTargets []services.QuotaTarget
+}
+
+// newHedgeRace 在发起尝试前取出请求的限流与配额对象
+func newHedgeRace(c *gin.Context, endpoint *models.APIEndpoint) *hedgeRace {
+	race := &hedgeRace{endpointID: endpoint.ID, winner: -1}
+	if value, ok := c.Get(rateLimitsKey); ok {
+		race.rateLimits = value.([]services.RateLimit)
+	}
+	if value, ok := c.Get(quotaTargetsKey); ok {
+		race.quotaTargets = value.([]services.QuotaTarget)
+	}
+	return race
+}
+
+// finish 在尝试结束时调用，返回该尝试是否为最先成功的尝试，以及是否已有其他尝试胜出。
+// 已有其他尝试胜出时，成功或校验失败的尝试计入其实际用量，被取消的尝试按估算的输入 Token 计入（上游通常已处理了输入），
+// 这些 Token 同时计入请求的 TPM 限流与配额。
+func (r *hedgeRace) finish(ctx context.Context, index int, chatReq *providers.ChatRequest, completion *providers.ChatResponse, err error) (won, lost bool) {
+	r.mu.Lock()
+	if err == nil && completion != nil && r.winner < 0 {
+		r.winner = index
+		r.mu.Unlock()
+		return true, false
+	}
+	lost = r.winner >= 0
+	r.mu.Unlock()
+	if !lost {
+		return false, false
+	}
+
+	var usage providers.Usage
+	var consumed *consumedAttemptError
+	switch {
+	case err == nil && completion != nil:
+		usage = completion.Usage
+	case errors.As(err, &consumed):
+		usage = consumed.usage
+	case errors.Is(err, context.Canceled) && ctx.Err() != nil:
+		usage = providers.EstimateUsage(chatReq, "")
+	}
+	tokens := usage.PromptTokens + usage.CompletionTokens
+	if tokens > 0 {
+		services.AddHedgeExtraTokens(r.endpointID, tokens)
+		services.ChargeRateLimitTokens(r.rateLimits, tokens)
+		services.ChargeQuotas(r.quotaTargets, tokens, services.UsageCost(chatReq.Model, usage))
+	}
+	return false, true
+}
+
+// completeHedged 以对冲方式进行非流式调用：当前尝试在 HedgeDelay 内没有返回时并行发起下一个尝试，
+// 采用最先成功的结果并通过 context 取消其余尝试。尝试失败时立即发起下一个尝试，与顺序备用一致。
+// 发起过对冲的调用会记录胜出的供应商/模型，落选尝试消耗的 Token 计入 HedgeExtraTokens，并照常计入限流与配额。
+func completeHedged(c *gin.Context, endpoint *models.APIEndpoint, attempts []ModelAttempt, build requestBuilder, validate outputValidator) (*providers.ChatResponse, error) {
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+
+	race := newHedgeRace(c, endpoint)
+	// 容量足够所有尝试写入，返回后仍在运行的尝试不会阻塞
+	results := make(chan attemptResult, len(attempts))
+	next, running := 0, 0
+	hedged := false
+	launch := func() {
+		index, attempt := next, attempts[next]
+		next++
+		running++
+		chatReq := build(attempt)
+		go func() {
+			completion, err := runAttempt(ctx, endpoint, attempt, chatReq, validate)
+			won, lost := race.finish(ctx, index, chatReq, completion, err)
+			if !won {
+				completion = nil
+			}
+			results <- attemptResult{index: index, completion: completion, err: err, lost: lost}
+		}()
+	}
+
+	delay := time.Duration(endpoint.HedgeDelay) * time.Millisecond
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	launch()
+
+	var lastError error
+	for running > 0 {
+		select {
+		case <-c.Request.Context().Done():
+			return nil, c.Request.Context().Err()
+		case <-timer.C:
+			if next < len(attempts) {
+				hedged = true
+				launch()
+				timer.Reset(delay)
+			}
+		case result := <-results:
+			running--
+			attempt := attempts[result.index]
+			if result.completion != nil {
+				recordSuccess(c, endpoint, attempt, result.completion.Usage)
+				if hedged {
+					services.AddHedgeStats(endpoint.ID, attempt.Provider.Name, attempt.ModelName)
+				}
+				return result.completion, nil
+			}
+			if result.lost {
+				// 胜出尝试的结果随后到达
+				continue
+			}
+
+			if err := c.Request.Context().Err(); err != nil {
+				return nil, err
+			}
+			lastError = result.err
+			recordAttemptFailure(c, endpoint, attempt, result.err)
+			// 请求本身有误时换供应商也不会成功，直接返回
+			if providers.Classify(result.err) == providers.ErrorFatal {
+				return nil, result.err
+			}
+			if next < len(attempts) {
+				launch()
+				timer.Reset(delay)
+			}
+		}
+	}
+
+	if lastError == nil {
+		lastError = errors.New("no model attempt succeeded")
+	}
+	return nil, lastError
+}
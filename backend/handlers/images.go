@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"ai-api-platform/backend/models"
+	"ai-api-platform/backend/providers"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errImageTooLarge 表示图片超过了 API 路径的 MaxImageBytes，返回 413
+var errImageTooLarge = errors.New("image exceeds the size limit")
+
+// errBodyTooLarge 表示 JSON 请求体超过了按 API 路径图片上限计算的大小，返回 413
+var errBodyTooLarge = errors.New("request body exceeds the size limit")
+
+// supportedImageTypes 是各供应商都支持的图片格式
+var supportedImageTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// multipartOverhead 是 multipart 请求中除图片外的字段可占用的字节数
+const multipartOverhead = 1 << 20
+
+// ProxyImage 是请求中的一张图片：url 为 http(s) 地址或 data URL，data 为 base64 数据（也可以是 data URL）
+type ProxyImage struct {
+	URL       string `json:"url"`
+	Data      string `json:"data"`
+	MediaType string `json:"media_type"` // data 为纯 base64 时可选，为空时根据内容识别
+}
+
+// parseDataURL 拆分 data:image/png;base64,... 形式的 data URL
+func parseDataURL(dataURL string) (mediaType, data string, err error) {
+	header, data, ok := strings.Cut(strings.TrimPrefix(dataURL, "data:"), ",")
+	if !ok || !strings.HasSuffix(header, ";base64") {
+		return "", "", errors.New("data URL must be base64 encoded")
+	}
+	return strings.TrimSuffix(header, ";base64"), data, nil
+}
+
+// normalizeImage 检查图片并转换为适配器使用的格式。
+// base64 图片会解码以检查大小与格式，URL 图片由上游下载，这里只检查地址格式。
+func normalizeImage(image ProxyImage, maxBytes int64) (providers.Image, error) {
+	source := strings.TrimSpace(image.Data)
+	mediaType := strings.TrimSpace(image.MediaType)
+	if source == "" {
+		source = strings.TrimSpace(image.URL)
+		if !strings.HasPrefix(source, "data:") {
+			u, err := url.Parse(source)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return providers.Image{}, errors.New("url must be an http(s) URL or a data URL")
+			}
+			return providers.Image{URL: source}, nil
+		}
+	}
+	if strings.HasPrefix(source, "data:") {
+		var err error
+		if mediaType, source, err = parseDataURL(source); err != nil {
+			return providers.Image{}, err
+		}
+	}
+
+	// 先按编码长度粗略判断，避免解码过大的数据
+	if int64(base64.StdEncoding.DecodedLen(len(source))) > maxBytes+2 {
+		return providers.Image{}, errImageTooLarge
+	}
+	decoded, err := base64.StdEncoding.DecodeString(source)
+	if err != nil {
+		return providers.Image{}, fmt.Errorf("data is not valid base64: %v", err)
+	}
+	if int64(len(decoded)) > maxBytes {
+		return providers.Image{}, errImageTooLarge
+	}
+	if mediaType == "" {
+		mediaType = http.DetectContentType(decoded)
+	}
+	if !supportedImageTypes[mediaType] {
+		return providers.Image{}, fmt.Errorf("unsupported image type %q", mediaType)
+	}
+	return providers.Image{MediaType: mediaType, Data: source}, nil
+}
+
+// requestImages 按 API 路径的多模态配置检查请求中的图片
+func requestImages(endpoint *models.APIEndpoint, images []ProxyImage) ([]providers.Image, error) {
+	if len(images) == 0 {
+		return nil, nil
+	}
+	if !endpoint.AllowVision {
+		return nil, errors.New("image input is not enabled for this endpoint")
+	}
+	if len(images) > endpoint.MaxImages {
+		return nil, fmt.Errorf("too many images, at most %d are allowed", endpoint.MaxImages)
+	}
+
+	result := make([]providers.Image, 0, len(images))
+	for i, image := range images {
+		normalized, err := normalizeImage(image, endpoint.MaxImageBytes)
+		if err != nil {
+			return nil, fmt.Errorf("images[%d]: %w", i, err)
+		}
+		result = append(result, normalized)
+	}
+	return result, nil
+}
+
+// messageImages 收集网关请求中各条消息的图片，解析 API 路径后统一检查并附加到对应的消息上
+type messageImages struct {
+	images []ProxyImage
+	owners []int // 图片所属消息在 ChatRequest.Messages 中的下标
+}
+
+func (m *messageImages) add(owner int, images []ProxyImage) {
+	for _, image := range images {
+		m.images = append(m.images, image)
+		m.owners = append(m.owners, owner)
+	}
+}
+
+// attach 按 API 路径的多模态配置检查图片，图片数量上限按整个请求计算
+func (m *messageImages) attach(endpoint *models.APIEndpoint, req *providers.ChatRequest) error {
+	images, err := requestImages(endpoint, m.images)
+	if err != nil {
+		return err
+	}
+	for i, image := range images {
+		message := &req.Messages[m.owners[i]]
+		message.Images = append(message.Images, image)
+	}
+	return nil
+}
+
+// imageErrorStatus 返回图片检查失败时的状态码，超过大小上限时为 413
+func imageErrorStatus(err error) int {
+	if errors.Is(err, errImageTooLarge) || errors.Is(err, errBodyTooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// imageErrorResponse 返回图片检查失败的错误，超过大小上限时为 413
+func imageErrorResponse(c *gin.Context, err error) {
+	c.JSON(imageErrorStatus(err), gin.H{"error": err.Error()})
+}
+
+// isMultipart 判断请求是否以 multipart/form-data 上传
+func isMultipart(c *gin.Context) bool {
+	mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	return mediaType == "multipart/form-data"
+}
+
+// bindJSONRequest 解析 JSON 请求。请求体大小按 API 路径的图片数量与 base64 编码后的大小上限限制，
+// 未开启图片输入的路径只允许 multipartOverhead 字节。
+func bindJSONRequest(c *gin.Context, endpoint *models.APIEndpoint, req *ProxyRequest) error {
+	limit := int64(multipartOverhead)
+	if endpoint.AllowVision {
+		limit += int64(endpoint.MaxImages) * int64(base64.StdEncoding.EncodedLen(int(endpoint.MaxImageBytes)))
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+	if err := c.ShouldBindJSON(req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return errBodyTooLarge
+		}
+		return err
+	}
+	return nil
+}
+
+// bindMultipartRequest 解析 multipart 请求：content、session_id 为文本字段，variables 为 JSON 字段，
+// images 为上传的图片文件（可上传多个）。请求体大小按 API 路径的图片数量与大小上限限制。
+func bindMultipartRequest(c *gin.Context, endpoint *models.APIEndpoint, req *ProxyRequest) error {
+	if !endpoint.AllowVision {
+		return errors.New("image input is not enabled for this endpoint")
+	}
+	limit := int64(endpoint.MaxImages)*endpoint.MaxImageBytes + multipartOverhead
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+	if err := c.Request.ParseMultipartForm(multipartOverhead); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return errImageTooLarge
+		}
+		return fmt.Errorf("invalid multipart body: %v", err)
+	}
+
+	form := c.Request.MultipartForm
+	req.Content = c.Request.FormValue("content")
+	req.SessionID = c.Request.FormValue("session_id")
+	if variables := c.Request.FormValue("variables"); variables != "" {
+		if err := json.Unmarshal([]byte(variables), &req.Variables); err != nil {
+			return fmt.Errorf("variables must be a JSON object: %v", err)
+		}
+	}
+
+	for i, header := range form.File["images"] {
+		if header.Size > endpoint.MaxImageBytes {
+			return fmt.Errorf("images[%d]: %w", i, errImageTooLarge)
+		}
+		file, err := header.Open()
+		if err != nil {
+			return fmt.Errorf("images[%d]: %v", i, err)
+		}
+		data, err := io.ReadAll(file)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("images[%d]: %v", i, err)
+		}
+
+		mediaType, _, _ := mime.ParseMediaType(header.Header.Get("Content-Type"))
+		if !supportedImageTypes[mediaType] {
+			// 客户端常以 application/octet-stream 上传，改为根据内容识别
+			mediaType = ""
+		}
+		req.Images = append(req.Images, ProxyImage{Data: base64.StdEncoding.EncodeToString(data), MediaType: mediaType})
+	}
+	return nil
+}
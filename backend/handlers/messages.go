@@ -0,0 +1,485 @@
+package handlers
+
+import (
+	"ai-api-platform/backend/models"
+	"ai-api-platform/backend/providers"
+	"ai-api-platform/backend/services"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// messagesRequest 是 Anthropic Messages API（/v1/messages）的请求体
+type messagesRequest struct {
+	Model         string            `json:"model"`
+	MaxTokens     int64             `json:"max_tokens"`
+	System        json.RawMessage   `json:"system"`
+	Messages      []messagesMessage `json:"messages"`
+	Stream        bool              `json:"stream"`
+	Temperature   *float64          `json:"temperature"`
+	TopP          *float64          `json:"top_p"`
+	StopSequences []string          `json:"stop_sequences"`
+	Tools         []struct {
+		Name        string          `json:"name"`
+		Description string          `json:"description"`
+		InputSchema json.RawMessage `json:"input_schema"`
+	} `json:"tools"`
+	ToolChoice *struct {
+		Type string `json:"type"`
+		Name string `json:"name"`
+	} `json:"tool_choice"`
+	Thinking *struct {
+		Type string `json:"type"`
+	} `json:"thinking"`
+	Variables map[string]interface{} `json:"variables"` // 渲染 API 路径系统提示词模板的变量
+
+	images messageImages // user 消息中的图片，解析 API 路径后按其多模态配置检查
+}
+
+type messagesMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+// messagesBlock 是消息中的一个内容块
+type messagesBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	ID        string          `json:"id,omitempty"`          // tool_use
+	Name      string          `json:"name,omitempty"`        // tool_use
+	Input     json.RawMessage `json:"input,omitempty"`       // tool_use
+	ToolUseID string          `json:"tool_use_id,omitempty"` // tool_result
+	Content   json.RawMessage `json:"content,omitempty"`     // tool_result，字符串或文本块数组
+	IsError   bool            `json:"is_error,omitempty"`    // tool_result
+	Source    *struct {
+		Type      string `json:"type"`
+		MediaType string `json:"media_type"`
+		Data      string `json:"data"`
+		URL       string `json:"url"`
+	} `json:"source,omitempty"` // image
+}
+
+// anthropicError 返回 Anthropic 格式的错误，便于 Anthropic SDK 解析
+func anthropicError(c *gin.Context, status int, errType, message string) {
+	c.JSON(status, gin.H{"type": "error", "error": gin.H{"type": errType, "message": message}})
+}
+
+// parseBlocks 解析内容，字符串视为一个文本块
+func parseBlocks(raw json.RawMessage) ([]messagesBlock, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return []messagesBlock{{Type: "text", Text: text}}, nil
+	}
+	var blocks []messagesBlock
+	if err := json.Unmarshal(raw, &blocks); err != nil {
+		return nil, errors.New("content must be a string or an array of content blocks")
+	}
+	return blocks, nil
+}
+
+// blocksText 拼接文本块，遇到其他类型的块时返回错误
+func blocksText(raw json.RawMessage) (string, error) {
+	blocks, err := parseBlocks(raw)
+	if err != nil {
+		return "", err
+	}
+	var text strings.Builder
+	for _, block := range blocks {
+		if block.Type != "text" {
+			return "", fmt.Errorf("content block type %q is not supported here", block.Type)
+		}
+		text.WriteString(block.Text)
+	}
+	return text.String(), nil
+}
+
+// blockImage 转换 image 块，source 支持 base64 与 url 两种类型
+func blockImage(block messagesBlock) (ProxyImage, error) {
+	if block.Source == nil {
+		return ProxyImage{}, errors.New("source: field required")
+	}
+	switch block.Source.Type {
+	case "base64":
+		return ProxyImage{Data: block.Source.Data, MediaType: block.Source.MediaType}, nil
+	case "url":
+		return ProxyImage{URL: block.Source.URL}, nil
+	}
+	return ProxyImage{}, fmt.Errorf("source type %q is not supported", block.Source.Type)
+}
+
+// toChatRequest 将 Messages API 请求转换为统一的聊天请求模板。
+// tool_use 块转换为 assistant 的工具调用，tool_result 块转换为 tool 消息。
+func (r *messagesRequest) toChatRequest() (*providers.ChatRequest, error) {
+	if len(r.Messages) == 0 {
+		return nil, errors.New("messages: field required")
+	}
+
+	req := &providers.ChatRequest{
+		TopP:      r.TopP,
+		MaxTokens: r.MaxTokens,
+		Stop:      r.StopSequences,
+	}
+
+	system, err := blocksText(r.System)
+	if err != nil {
+		return nil, fmt.Errorf("system: %v", err)
+	}
+	if system != "" {
+		req.Messages = append(req.Messages, providers.Message{Role: "system", Content: system})
+	}
+
+	for i, m := range r.Messages {
+		if m.Role != "user" && m.Role != "assistant" {
+			return nil, fmt.Errorf("messages.%d.role: must be user or assistant", i)
+		}
+		blocks, err := parseBlocks(m.Content)
+		if err != nil {
+			return nil, fmt.Errorf("messages.%d.content: %v", i, err)
+		}
+
+		message := providers.Message{Role: m.Role}
+		var text strings.Builder
+		var images []ProxyImage
+		for _, block := range blocks {
+			switch {
+			case block.Type == "text":
+				text.WriteString(block.Text)
+			case block.Type == "image" && m.Role == "user":
+				image, err := blockImage(block)
+				if err != nil {
+					return nil, fmt.Errorf("messages.%d.content: image: %v", i, err)
+				}
+				images = append(images, image)
+			case block.Type == "tool_use" && m.Role == "assistant":
+				input := string(block.Input)
+				if input == "" {
+					input = "{}"
+				}
+				message.ToolCalls = append(message.ToolCalls, providers.ToolCall{ID: block.ID, Name: block.Name, Arguments: input})
+			case block.Type == "tool_result" && m.Role == "user":
+				content, err := blocksText(block.Content)
+				if err != nil {
+					return nil, fmt.Errorf("messages.%d.content: tool_result: %v", i, err)
+				}
+				if block.IsError {
+					content = "Error: " + content
+				}
+				// 工具结果需紧跟在发起调用的 assistant 消息之后
+				req.Messages = append(req.Messages, providers.Message{Role: "tool", Content: content, ToolCallID: block.ToolUseID})
+			case block.Type == "thinking" || block.Type == "redacted_thinking":
+				// 思考内容不回传给上游
+			default:
+				return nil, fmt.Errorf("messages.%d.content: block type %q is not supported", i, block.Type)
+			}
+		}
+		message.Content = text.String()
+		if message.Content != "" || len(message.ToolCalls) > 0 || len(images) > 0 {
+			r.images.add(len(req.Messages), images)
+			req.Messages = append(req.Messages, message)
+		}
+	}
+
+	for _, tool := range r.Tools {
+		req.Tools = append(req.Tools, providers.Tool{
+			Name:        tool.Name,
+			Description: tool.Description,
+			Parameters:  tool.InputSchema,
+		})
+	}
+	if r.ToolChoice != nil && len(r.Tools) > 0 {
+		var choice interface{}
+		switch r.ToolChoice.Type {
+		case "auto", "":
+			choice = "auto"
+		case "any":
+			choice = "required"
+		case "none":
+			choice = "none"
+		case "tool":
+			choice = gin.H{"type": "function", "function": gin.H{"name": r.ToolChoice.Name}}
+		default:
+			return nil, fmt.Errorf("tool_choice.type: %q is not supported", r.ToolChoice.Type)
+		}
+		req.ToolChoice, _ = json.Marshal(choice)
+	}
+	return req, nil
+}
+
+// messagesBuilder 为每次尝试填入模型与温度；客户端没有发送 system 时使用渲染后的系统提示词
+func messagesBuilder(endpoint *models.APIEndpoint, template *providers.ChatRequest, systemPrompt string, temperature *float64, thinking bool) requestBuilder {
+	build := gatewayBuilder(endpoint, template, systemPrompt, temperature)
+	return func(attempt ModelAttempt) *providers.ChatRequest {
+		req := build(attempt)
+		req.EnableThinking = req.EnableThinking || thinking
+		return req
+	}
+}
+
+// messagesUsage 转换为 Anthropic 格式的用量。Anthropic 的 input_tokens 不含命中缓存的部分，
+// 命中缓存的 Token 通过 cache_read_input_tokens 单独返回
+func messagesUsage(usage providers.Usage) gin.H {
+	return gin.H{
+		"input_tokens":            usage.PromptTokens - usage.CachedTokens,
+		"output_tokens":           usage.CompletionTokens,
+		"cache_read_input_tokens": usage.CachedTokens,
+	}
+}
+
+// anthropicStopReason 将 finish_reason 转换为 Anthropic 的 stop_reason
+func anthropicStopReason(finishReason string) string {
+	switch finishReason {
+	case "length":
+		return "max_tokens"
+	case "tool_calls", "function_call":
+		return "tool_use"
+	}
+	return "end_turn"
+}
+
+// toolInput 返回工具调用参数对应的 input 对象，参数不是合法 JSON 对象时返回空对象
+func toolInput(arguments string) json.RawMessage {
+	var input map[string]interface{}
+	if json.Unmarshal([]byte(arguments), &input) != nil || input == nil {
+		return json.RawMessage("{}")
+	}
+	return json.RawMessage(arguments)
+}
+
+// newMessageID 生成 Anthropic 格式的消息 ID
+func newMessageID() string {
+	buf := make([]byte, 12)
+	rand.Read(buf)
+	return "msg_" + hex.EncodeToString(buf)
+}
+
+// AnthropicMessages 是 Anthropic 兼容的 /v1/messages 入口。
+// 使用虚拟 Key 鉴权（x-api-key 或 Authorization: Bearer），model 对应虚拟 Key 可访问的 API 路径，
+// 请求转换为统一格式后可路由到任意类型的供应商，响应与流式事件再转换回 Anthropic 格式。
+func AnthropicMessages(c *gin.Context) {
+	token := strings.TrimSpace(c.GetHeader("x-api-key"))
+	if token == "" {
+		token = strings.TrimSpace(strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "))
+	}
+	vk, ok := services.GetVirtualKey(token)
+	if token == "" || !ok {
+		anthropicError(c, http.StatusUnauthorized, "authentication_error", "invalid x-api-key")
+		return
+	}
+
+	var req messagesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		anthropicError(c, http.StatusBadRequest, "invalid_request_error", "Invalid request body: "+err.Error())
+		return
+	}
+	template, err := req.toChatRequest()
+	if err != nil {
+		anthropicError(c, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+
+	endpoint, ok := services.ResolveVirtualKeyEndpoint(vk, req.Model)
+	if !ok {
+		anthropicError(c, http.StatusNotFound, "not_found_error", "model: "+req.Model)
+		return
+	}
+
+	if err := req.images.attach(endpoint, template); err != nil {
+		anthropicError(c, imageErrorStatus(err), "invalid_request_error", err.Error())
+		return
+	}
+
+	limitedKey := services.VirtualKeyLimits(vk)
+	if err := checkQuota(c, endpoint, limitedKey); err != nil {
+		anthropicError(c, http.StatusTooManyRequests, "rate_limit_error", err.Error())
+		return
+	}
+	if !allowRateLimit(c, endpoint, limitedKey) {
+		anthropicError(c, http.StatusTooManyRequests, "rate_limit_error", "Rate limit exceeded, please retry later")
+		return
+	}
+
+	attempts, err := buildAttemptsList(endpoint)
+	if errors.Is(err, services.ErrCircuitOpen) {
+		anthropicError(c, 529, "overloaded_error", "All upstream models are temporarily unavailable (circuit open)")
+		return
+	}
+	if err != nil || len(attempts) == 0 {
+		anthropicError(c, http.StatusInternalServerError, "api_error", "No model configured for provider")
+		return
+	}
+
+	systemPrompt, err := gatewaySystemPrompt(endpoint, template, req.Variables)
+	if err != nil {
+		anthropicError(c, http.StatusBadRequest, "invalid_request_error", "Failed to render system prompt: "+err.Error())
+		return
+	}
+
+	thinking := req.Thinking != nil && req.Thinking.Type == "enabled"
+	build := messagesBuilder(endpoint, template, systemPrompt, req.Temperature, thinking)
+	if attempts, err = filterUnsupported(attempts, build); err != nil {
+		anthropicError(c, http.StatusBadRequest, "invalid_request_error", "No configured model supports this request: "+err.Error())
+		return
+	}
+	cache := newResponseCache(endpoint, attempts, build)
+	if req.Stream {
+		streamWithCache(c, endpoint, attempts, build, &messagesStreamOutput{id: newMessageID(), model: req.Model, toolBlocks: make(map[int]int)}, cache)
+		return
+	}
+
+	completion, err := completeWithCache(c, endpoint, attempts, build, cache)
+	if c.Request.Context().Err() != nil {
+		return
+	}
+	if err != nil {
+		if providers.Classify(err) == providers.ErrorFatal {
+			anthropicError(c, providers.StatusCode(err), "invalid_request_error", "Upstream rejected the request: "+err.Error())
+			return
+		}
+		anthropicError(c, http.StatusBadGateway, "api_error", "All model attempts failed: "+err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, anthropicMessage(completion, req.Model))
+}
+
+// anthropicMessage 将统一的非流式结果转换为 Messages API 响应，只使用第一个候选回复
+func anthropicMessage(completion *providers.ChatResponse, model string) gin.H {
+	message := providers.Message{Content: completion.Content}
+	finishReason := ""
+	if len(completion.Choices) > 0 {
+		message = completion.Choices[0].Message
+		finishReason = completion.Choices[0].FinishReason
+	}
+
+	content := make([]gin.H, 0, 1+len(message.ToolCalls))
+	if message.Content != "" {
+		content = append(content, gin.H{"type": "text", "text": message.Content})
+	}
+	for _, call := range message.ToolCalls {
+		content = append(content, gin.H{"type": "tool_use", "id": call.ID, "name": call.Name, "input": toolInput(call.Arguments)})
+	}
+
+	return gin.H{
+		"id":            newMessageID(),
+		"type":          "message",
+		"role":          "assistant",
+		"model":         model,
+		"content":       content,
+		"stop_reason":   anthropicStopReason(finishReason),
+		"stop_sequence": nil,
+		"usage":         messagesUsage(completion.Usage),
+	}
+}
+
+// messagesStreamOutput 将统一的流式片段转换为 Messages API 的流式事件：
+// message_start、content_block_start/delta/stop、message_delta 与 message_stop
+type messagesStreamOutput struct {
+	id           string
+	model        string
+	started      bool   // 是否已发送 message_start
+	blockIndex   int    // 当前内容块序号
+	blockType    string // 当前打开的内容块类型，空表示没有打开的块
+	toolBlocks   map[int]int
+	finishReason string
+}
+
+// writeEvent 发送一个带事件类型的 SSE 帧
+func (o *messagesStreamOutput) writeEvent(c *gin.Context, event string, data gin.H) {
+	data["type"] = event
+	payload, _ := json.Marshal(data)
+	c.Writer.Write([]byte("event: " + event + "\ndata: " + string(payload) + "\n\n"))
+	c.Writer.Flush()
+}
+
+func (o *messagesStreamOutput) start(c *gin.Context) {
+	if o.started {
+		return
+	}
+	o.started = true
+	o.writeEvent(c, "message_start", gin.H{"message": gin.H{
+		"id":            o.id,
+		"type":          "message",
+		"role":          "assistant",
+		"model":         o.model,
+		"content":       []gin.H{},
+		"stop_reason":   nil,
+		"stop_sequence": nil,
+		"usage":         gin.H{"input_tokens": 0, "output_tokens": 0},
+	}})
+}
+
+// closeBlock 结束当前打开的内容块
+func (o *messagesStreamOutput) closeBlock(c *gin.Context) {
+	if o.blockType == "" {
+		return
+	}
+	o.writeEvent(c, "content_block_stop", gin.H{"index": o.blockIndex})
+	o.blockType = ""
+	o.blockIndex++
+}
+
+func (o *messagesStreamOutput) writeChunk(c *gin.Context, chunk providers.StreamChunk) {
+	// Messages API 只有一个候选回复
+	if chunk.Index != 0 {
+		return
+	}
+	o.start(c)
+
+	if chunk.Content != "" {
+		if o.blockType != "text" {
+			o.closeBlock(c)
+			o.blockType = "text"
+			o.writeEvent(c, "content_block_start", gin.H{"index": o.blockIndex, "content_block": gin.H{"type": "text", "text": ""}})
+		}
+		o.writeEvent(c, "content_block_delta", gin.H{"index": o.blockIndex, "delta": gin.H{"type": "text_delta", "text": chunk.Content}})
+	}
+
+	for _, call := range chunk.ToolCalls {
+		// 每个工具调用对应一个 tool_use 块，调用 ID 只在第一个增量中出现
+		if _, ok := o.toolBlocks[call.Index]; !ok || call.ID != "" {
+			o.closeBlock(c)
+			o.blockType = "tool_use"
+			o.toolBlocks[call.Index] = o.blockIndex
+			o.writeEvent(c, "content_block_start", gin.H{"index": o.blockIndex, "content_block": gin.H{
+				"type": "tool_use", "id": call.ID, "name": call.Name, "input": gin.H{},
+			}})
+		}
+		if call.Arguments != "" && o.toolBlocks[call.Index] == o.blockIndex {
+			o.writeEvent(c, "content_block_delta", gin.H{"index": o.blockIndex, "delta": gin.H{"type": "input_json_delta", "partial_json": call.Arguments}})
+		}
+	}
+
+	if chunk.FinishReason != "" {
+		o.finishReason = chunk.FinishReason
+	}
+}
+
+// writeFallback 返回 false：Messages API 的事件流无法让 SDK 丢弃已收到的内容块，reset 策略按 buffer 处理，以 error 事件结束
+func (o *messagesStreamOutput) writeFallback(c *gin.Context, nextAttempt int) bool {
+	return false
+}
+
+func (o *messagesStreamOutput) writeDone(c *gin.Context, usage providers.Usage) {
+	o.start(c)
+	o.closeBlock(c)
+	o.writeEvent(c, "message_delta", gin.H{
+		"delta": gin.H{"stop_reason": anthropicStopReason(o.finishReason), "stop_sequence": nil},
+		"usage": messagesUsage(usage),
+	})
+	o.writeEvent(c, "message_stop", gin.H{})
+}
+
+func (o *messagesStreamOutput) writeError(c *gin.Context, message string, err error) {
+	if err != nil {
+		message += ": " + err.Error()
+	}
+	o.writeEvent(c, "error", gin.H{"error": gin.H{"type": "api_error", "message": message}})
+}
@@ -2,31 +2,40 @@ package handlers
 
 import (
 	"ai-api-platform/backend/models"
+	"ai-api-platform/backend/providers"
 	"ai-api-platform/backend/services"
 	"ai-api-platform/backend/utils"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"math/rand"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/openai/openai-go"
-	"github.com/openai/openai-go/option"
 )
 
-// 默认 HTTP 客户端，超时时间由配置决定（非流式）
+// 默认 HTTP 客户端（非流式），超时由每次尝试的 context 控制，见 attemptContext
 var defaultHTTPClient = &http.Client{
-	Timeout: time.Duration(utils.GlobalConfig.Proxy.Timeout) * time.Second,
+	Timeout: 0,
 }
 
-// 流式输出使用的 HTTP 客户端，不设置超时（由 context 控制客户端断开）
+// 流式输出使用的 HTTP 客户端，不设置超时（由 context 控制客户端断开与空闲超时，见 streamAttemptContext）
 var streamingHTTPClient = &http.Client{
 	Timeout: 0,
 }
 
 type ProxyRequest struct {
-	Content string `json:"content" binding:"required"`
+	Content   string                 `json:"content"`    // API 路径未配置用户消息模板时必填
+	SessionID string                 `json:"session_id"` // 可选，指定后回放该会话的历史消息，并在成功后保存本轮对话
+	Variables map[string]interface{} `json:"variables"`  // 可选，渲染系统提示词与用户消息模板的变量
+	Images    []ProxyImage           `json:"images"`     // 可选，随用户消息发送的图片，需 API 路径开启 AllowVision
+	// 可选，passthrough 模式下客户端执行工具后提交上一次回复中的 tool_calls 与对应的结果
+	ToolCalls   []openAIToolCall  `json:"tool_calls"`
+	ToolResults []ProxyToolResult `json:"tool_results"`
 }
 
 type OpenAIRequest struct {
@@ -37,279 +46,1020 @@ type OpenAIRequest struct {
 }
 
 type OpenAIMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	ToolCalls []openAIToolCall `json:"tool_calls,omitempty"`
+}
+
+// OpenAIChoice 是非流式响应中的一个候选回复
+type OpenAIChoice struct {
+	Message      OpenAIMessage `json:"message"`
+	FinishReason string        `json:"finish_reason,omitempty"`
 }
 
 type OpenAIResponse struct {
-	ID      string `json:"id"`
-	Choices []struct {
-		Message OpenAIMessage `json:"message"`
-	} `json:"choices"`
-	Usage struct {
-		PromptTokens     int64 `json:"prompt_tokens"`
-		CompletionTokens int64 `json:"completion_tokens"`
-		TotalTokens      int64 `json:"total_tokens"`
-	} `json:"usage"`
+	ID      string          `json:"id"`
+	Choices []OpenAIChoice  `json:"choices"`
+	Usage   gatewayUsage    `json:"usage"`
+	Parsed  json.RawMessage `json:"parsed,omitempty"` // 结构化输出模式下解析后的 JSON
 }
 
 // ModelAttempt 表示一次模型调用尝试
 type ModelAttempt struct {
-	Provider   *models.AIProvider
-	ModelName  string
-	AttemptNum int
+	Provider    *models.AIProvider
+	ModelName   string
+	AttemptNum  int
+	Temperature float64
+	Timeout     time.Duration // 0 表示使用全局超时配置
+	PoolMember  bool          // 是否来自负载均衡池
 }
 
-// buildChatCompletionParams 构建聊天补全参数
-func buildChatCompletionParams(endpoint *models.APIEndpoint, req ProxyRequest, modelName string) openai.ChatCompletionNewParams {
-	params := openai.ChatCompletionNewParams{
-		Messages: []openai.ChatCompletionMessageParamUnion{
-			openai.SystemMessage(endpoint.SystemPrompt),
-			openai.UserMessage(req.Content),
-		},
-		Model:       modelName,
-		Temperature: openai.Float(endpoint.Temperature),
+// memberKey 返回本次尝试对应的供应商/模型标识
+func (a ModelAttempt) memberKey() string {
+	return services.MemberKey(a.Provider.ID, a.ModelName)
+}
+
+// renderedPrompt 是渲染模板后的系统提示词与用户消息，Images 为用户消息附带的图片
+type renderedPrompt struct {
+	System string
+	User   string
+	Images []providers.Image
+}
+
+// renderPrompts 以请求中的 variables 渲染 API 路径的系统提示词与用户消息模板，未配置用户消息模板时用户消息即 content。
+// API 路径没有开启 PromptTemplating 时系统提示词原样使用。
+func renderPrompts(endpoint *models.APIEndpoint, req ProxyRequest) (renderedPrompt, error) {
+	if !endpoint.PromptTemplating {
+		return renderedPrompt{System: endpoint.SystemPrompt, User: req.Content}, nil
+	}
+	ctx := services.PromptContext{Path: endpoint.Path, Content: req.Content}
+	system, err := services.RenderPrompt(endpoint.SystemPrompt, req.Variables, ctx)
+	if err != nil {
+		return renderedPrompt{}, err
+	}
+	user := req.Content
+	if endpoint.UserTemplate != "" {
+		if user, err = services.RenderPrompt(endpoint.UserTemplate, req.Variables, ctx); err != nil {
+			return renderedPrompt{}, err
+		}
 	}
+	return renderedPrompt{System: system, User: user}, nil
+}
 
-	extraFields := map[string]interface{}{
-		"enable_thinking": endpoint.EnableThinking,
-		"reasoning_split": false,
+// buildChatRequest 构建统一的聊天请求，history 为会话中需要回放的历史消息，
+// toolMessages 为 passthrough 模式下客户端提交的工具调用与结果，tools 为 API 路径配置的工具
+func buildChatRequest(endpoint *models.APIEndpoint, prompt renderedPrompt, history, toolMessages []providers.Message, tools []providers.Tool, attempt ModelAttempt) *providers.ChatRequest {
+	messages := make([]providers.Message, 0, len(history)+len(toolMessages)+2)
+	messages = append(messages, providers.Message{Role: "system", Content: prompt.System})
+	messages = append(messages, history...)
+	messages = append(messages, providers.Message{Role: "user", Content: prompt.User, Images: prompt.Images})
+	messages = append(messages, toolMessages...)
+	return &providers.ChatRequest{
+		Model:          attempt.ModelName,
+		Messages:       messages,
+		Temperature:    attempt.Temperature,
+		EnableThinking: endpoint.EnableThinking,
+		Tools:          tools,
 	}
-	params.SetExtraFields(extraFields)
+}
+
+// sessionRecorder 在调用成功后把本轮对话保存到会话
+type sessionRecorder struct {
+	endpointID  uint
+	ownerKeyID  uint
+	sessionID   string
+	userContent string
+}
 
-	return params
+func (r *sessionRecorder) save(content string) {
+	if err := services.AppendSessionTurn(r.endpointID, r.ownerKeyID, r.sessionID, r.userContent, content); err != nil {
+		log.Printf("save session %q: %v", r.sessionID, err)
+	}
 }
 
-// buildAttemptsList 构建模型尝试列表
+// loadSession 读取客户端 Key 在请求中 session_id 对应的历史消息，未指定 session_id 时返回 nil。
+// 会话按客户端 Key 的轮换链隔离，其他 Key 使用相同的 session_id 会得到各自的会话。
+// 会话中保存的是渲染后的用户消息 userContent。
+func loadSession(endpoint *models.APIEndpoint, key *models.ClientKey, req ProxyRequest, userContent string) (*sessionRecorder, []providers.Message, error) {
+	if req.SessionID == "" {
+		return nil, nil, nil
+	}
+	sessionID, err := services.NormalizeSessionID(req.SessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	owner := services.ClientKeyRootID(key)
+	stored, err := services.SessionHistory(endpoint, owner, sessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	history := make([]providers.Message, 0, len(stored))
+	for _, m := range stored {
+		history = append(history, providers.Message{Role: m.Role, Content: m.Content})
+	}
+	return &sessionRecorder{endpointID: endpoint.ID, ownerKeyID: owner, sessionID: sessionID, userContent: userContent}, history, nil
+}
+
+// defaultModelName 返回逗号分隔的模型列表中的第一个模型
+func defaultModelName(modelName string) string {
+	for _, m := range strings.Split(modelName, ",") {
+		if m = strings.TrimSpace(m); m != "" {
+			return m
+		}
+	}
+	return ""
+}
+
+// buildAttemptsList 构建模型尝试列表：主模型（或按路由模式排序的池成员）在前，备用模型按 Position 顺序排列。
+// 熔断器处于打开状态的供应商/模型会被直接剔除，全部被剔除时返回 services.ErrCircuitOpen。
 func buildAttemptsList(endpoint *models.APIEndpoint) ([]ModelAttempt, error) {
-	var attempts []ModelAttempt
+	attempts := buildPoolAttempts(endpoint)
 
-	// 主模型
+	// 主模型，负载均衡模式下由池成员代替
 	mainModelName := strings.TrimSpace(endpoint.SelectedModel)
 	if mainModelName == "" {
 		mainModelName = endpoint.Provider.ModelName
 	}
-	if strings.Contains(mainModelName, ",") {
-		for _, m := range strings.Split(mainModelName, ",") {
-			m = strings.TrimSpace(m)
-			if m != "" {
-				mainModelName = m
-				break
+	mainModelName = defaultModelName(mainModelName)
+	if len(attempts) == 0 && mainModelName != "" {
+		attempts = append(attempts, ModelAttempt{
+			Provider:    &endpoint.Provider,
+			ModelName:   mainModelName,
+			AttemptNum:  1,
+			Temperature: endpoint.Temperature,
+		})
+	}
+
+	// 备用模型，关联数据已随端点缓存预加载
+	for i := range endpoint.Attempts {
+		fallback := &endpoint.Attempts[i]
+		modelName := strings.TrimSpace(fallback.ModelName)
+		// 供应商已被删除时跳过
+		if fallback.Provider.ID == 0 || modelName == "" {
+			continue
+		}
+
+		attempt := ModelAttempt{
+			Provider:    &fallback.Provider,
+			ModelName:   modelName,
+			AttemptNum:  len(attempts) + 1,
+			Temperature: endpoint.Temperature,
+			Timeout:     time.Duration(fallback.Timeout) * time.Second,
+		}
+		if fallback.Temperature != nil {
+			attempt.Temperature = *fallback.Temperature
+		}
+		attempts = append(attempts, attempt)
+	}
+
+	return filterOpenCircuits(attempts)
+}
+
+// filterOpenCircuits 剔除熔断器处于打开状态的尝试并重新编号
+func filterOpenCircuits(attempts []ModelAttempt) ([]ModelAttempt, error) {
+	available := make([]ModelAttempt, 0, len(attempts))
+	for _, attempt := range attempts {
+		if !services.BreakerAvailable(attempt.memberKey()) {
+			continue
+		}
+		attempt.AttemptNum = len(available) + 1
+		available = append(available, attempt)
+	}
+	if len(available) == 0 && len(attempts) > 0 {
+		return nil, services.ErrCircuitOpen
+	}
+	return available, nil
+}
+
+// filterUnsupported 剔除供应商适配器不支持本次请求所用功能（如工具调用、图片地址）的尝试并重新编号，
+// 全部被剔除时返回第一个尝试的 providers.ErrUnsupportedFeature 错误，调用方应返回 400
+func filterUnsupported(attempts []ModelAttempt, build requestBuilder) ([]ModelAttempt, error) {
+	var firstErr error
+	supported := make([]ModelAttempt, 0, len(attempts))
+	for _, attempt := range attempts {
+		if err := providers.CheckRequest(attempt.Provider.Type, build(attempt)); err != nil {
+			if firstErr == nil {
+				firstErr = err
 			}
+			continue
 		}
+		attempt.AttemptNum = len(supported) + 1
+		supported = append(supported, attempt)
+	}
+	if len(supported) == 0 && firstErr != nil {
+		return nil, firstErr
+	}
+	return supported, nil
+}
+
+// buildPoolAttempts 按路由模式对负载均衡池成员排序，failover 模式或池为空时返回 nil
+func buildPoolAttempts(endpoint *models.APIEndpoint) []ModelAttempt {
+	if endpoint.RoutingMode == "" || endpoint.RoutingMode == models.RoutingModeFailover {
+		return nil
 	}
-	if mainModelName != "" {
+
+	var members []*models.EndpointPoolMember
+	var candidates []services.PoolCandidate
+	for i := range endpoint.PoolMembers {
+		member := &endpoint.PoolMembers[i]
+		modelName := strings.TrimSpace(member.ModelName)
+		if member.Provider.ID == 0 || modelName == "" {
+			continue
+		}
+		members = append(members, member)
+		candidates = append(candidates, services.PoolCandidate{
+			Key:    services.MemberKey(member.ProviderID, modelName),
+			Weight: member.Weight,
+		})
+	}
+
+	var attempts []ModelAttempt
+	for _, i := range services.OrderPool(endpoint.ID, endpoint.RoutingMode, candidates) {
 		attempts = append(attempts, ModelAttempt{
-			Provider:   &endpoint.Provider,
-			ModelName:  mainModelName,
-			AttemptNum: 1,
+			Provider:    &members[i].Provider,
+			ModelName:   strings.TrimSpace(members[i].ModelName),
+			AttemptNum:  len(attempts) + 1,
+			Temperature: endpoint.Temperature,
+			PoolMember:  true,
 		})
 	}
+	return attempts
+}
 
-	// 备用模型1
-	if endpoint.FallbackProviderID1 > 0 && endpoint.FallbackModel1 != "" {
-		var fallbackProvider1 models.AIProvider
-		if err := models.DB.First(&fallbackProvider1, endpoint.FallbackProviderID1).Error; err == nil {
-			attempts = append(attempts, ModelAttempt{
-				Provider:   &fallbackProvider1,
-				ModelName:  strings.TrimSpace(endpoint.FallbackModel1),
-				AttemptNum: 2,
-			})
+// recordSuccess 记录一次成功调用的统计、扣除 Token 数限流额度并计入配额用量，流式与非流式调用都经由这里计数
+func recordSuccess(c *gin.Context, endpoint *models.APIEndpoint, attempt ModelAttempt, usage providers.Usage) {
+	chargeUsage(c, attempt, usage)
+	services.AddStats(endpoint.ID, usage.PromptTokens, usage.CompletionTokens, usage.CachedTokens, usage.ReasoningTokens)
+	if usage.Estimated {
+		services.AddEstimatedStats(endpoint.ID)
+	}
+	if attempt.PoolMember {
+		services.AddPoolMemberStats(endpoint.ID, attempt.Provider.Name, attempt.ModelName)
+	}
+}
+
+// attemptTimeout 返回本次尝试的超时时间，未单独配置超时时使用全局配置，0 表示不限制
+func attemptTimeout(attempt ModelAttempt) time.Duration {
+	if attempt.Timeout > 0 {
+		return attempt.Timeout
+	}
+	return time.Duration(utils.GlobalConfig.Proxy.Timeout) * time.Second
+}
+
+// attemptContext 返回带本次尝试超时的 context
+func attemptContext(parent context.Context, attempt ModelAttempt) (context.Context, context.CancelFunc) {
+	timeout := attemptTimeout(attempt)
+	if timeout <= 0 {
+		return context.WithCancel(parent)
+	}
+	return context.WithTimeout(parent, timeout)
+}
+
+// streamAttemptContext 返回流式尝试的 context：等待第一个数据块或相邻两个数据块的间隔超过本次尝试的超时时间时取消调用，
+// 输出的总时长不受限制。每收到一个数据块调用 touch 重新计时，结束时调用 stop。
+func streamAttemptContext(parent context.Context, attempt ModelAttempt) (ctx context.Context, touch func(), stop func()) {
+	ctx, cancel := context.WithCancelCause(parent)
+	timeout := attemptTimeout(attempt)
+	if timeout <= 0 {
+		return ctx, func() {}, func() { cancel(nil) }
+	}
+	timer := time.AfterFunc(timeout, func() {
+		cancel(fmt.Errorf("no data from upstream within %s: %w", timeout, context.DeadlineExceeded))
+	})
+	return ctx, func() { timer.Reset(timeout) }, func() {
+		timer.Stop()
+		cancel(nil)
+	}
+}
+
+// streamTimeoutError 在流式尝试因空闲超时被取消时返回超时原因，否则原样返回 err
+func streamTimeoutError(ctx context.Context, err error) error {
+	if cause := context.Cause(ctx); errors.Is(cause, context.DeadlineExceeded) {
+		return cause
+	}
+	return err
+}
+
+// retryState 控制同一个尝试上的退避重试
+type retryState struct {
+	endpointID uint
+	attempt    ModelAttempt
+	retries    int
+}
+
+// wait 判断错误是否值得在同一个尝试上重试，值得时等待退避时间后返回 true。
+// 只有 429、5xx 和连接错误会重试；上游返回 Retry-After 时按其等待，超过上限则直接切换下一个尝试。
+func (r *retryState) wait(ctx context.Context, err error) bool {
+	config := utils.GlobalConfig.Retry
+	if r.retries >= config.MaxRetries || providers.Classify(err) != providers.ErrorRetryable {
+		return false
+	}
+
+	delay := backoffDelay(r.retries)
+	if retryAfter := providers.RetryAfter(err); retryAfter > 0 {
+		maxRetryAfter := time.Duration(config.MaxRetryAfter) * time.Second
+		if maxRetryAfter <= 0 {
+			maxRetryAfter = 10 * time.Second
+		}
+		if retryAfter > maxRetryAfter {
+			return false
 		}
+		delay = retryAfter
 	}
 
-	// 备用模型2
-	if endpoint.FallbackProviderID2 > 0 && endpoint.FallbackModel2 != "" {
-		var fallbackProvider2 models.AIProvider
-		if err := models.DB.First(&fallbackProvider2, endpoint.FallbackProviderID2).Error; err == nil {
-			attempts = append(attempts, ModelAttempt{
-				Provider:   &fallbackProvider2,
-				ModelName:  strings.TrimSpace(endpoint.FallbackModel2),
-				AttemptNum: 3,
-			})
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+	}
+
+	r.retries++
+	services.AddRetryStats(r.endpointID, r.attempt.Provider.Name, r.attempt.ModelName)
+	return true
+}
+
+// backoffDelay 返回第 n 次重试的指数退避时间，加入随机抖动避免请求同时重试
+func backoffDelay(n int) time.Duration {
+	config := utils.GlobalConfig.Retry
+	base := time.Duration(config.BaseDelay) * time.Millisecond
+	if base <= 0 {
+		base = 200 * time.Millisecond
+	}
+	maxDelay := time.Duration(config.MaxDelay) * time.Millisecond
+	if maxDelay <= 0 {
+		maxDelay = 5 * time.Second
+	}
+
+	delay := base << uint(n)
+	if delay <= 0 || delay > maxDelay {
+		delay = maxDelay
+	}
+	// 在 [delay/2, delay] 之间随机
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// requestBuilder 为每次尝试构建发给上游的请求
+type requestBuilder func(attempt ModelAttempt) *providers.ChatRequest
+
+// streamOutput 把统一的流式片段写成客户端协议的 SSE 帧
+type streamOutput interface {
+	// writeChunk 写出一个包含内容的片段
+	writeChunk(c *gin.Context, chunk providers.StreamChunk)
+	// writeFallback 通知客户端丢弃已收到的内容，由下一个尝试重新输出。
+	// 输出格式无法表达丢弃时返回 false，调用方按 buffer 策略以错误结束流
+	writeFallback(c *gin.Context, nextAttempt int) bool
+	// writeDone 在调用成功后结束流
+	writeDone(c *gin.Context, usage providers.Usage)
+	// writeError 写出错误帧并结束流
+	writeError(c *gin.Context, message string, err error)
+}
+
+// legacyStreamOutput 是自定义 API 路径使用的流式格式，输出内容增量；
+// 模型调用工具时额外输出 tool_calls 增量与 finish_reason
+type legacyStreamOutput struct{}
+
+func (legacyStreamOutput) writeChunk(c *gin.Context, chunk providers.StreamChunk) {
+	if len(chunk.ToolCalls) > 0 || chunk.FinishReason == "tool_calls" {
+		delta := gin.H{}
+		if chunk.Content != "" {
+			delta["content"] = chunk.Content
+		}
+		if len(chunk.ToolCalls) > 0 {
+			delta["tool_calls"] = openAIToolCalls(chunk.ToolCalls, true)
+		}
+		choice := gin.H{"delta": delta, "index": 0}
+		if chunk.FinishReason != "" {
+			choice["finish_reason"] = chunk.FinishReason
 		}
+		writeSSE(c, gin.H{"choices": []gin.H{choice}})
+		return
+	}
+	if chunk.Content == "" {
+		return
 	}
+	contentJSON, _ := json.Marshal(chunk.Content)
+	sseData := fmt.Sprintf(`{"choices":[{"delta":{"content":%s},"index":0}]}`, string(contentJSON))
+	c.Writer.Write([]byte("data: " + sseData + "\n\n"))
+	c.Writer.Flush()
+}
 
-	return attempts, nil
+func (legacyStreamOutput) writeFallback(c *gin.Context, nextAttempt int) bool {
+	writeSSE(c, gin.H{"event": "fallback", "attempt": nextAttempt})
+	return true
 }
 
-// handleStreamingOutput 处理流式输出
-func handleStreamingOutput(c *gin.Context, attempts []ModelAttempt, endpoint *models.APIEndpoint, req ProxyRequest) {
-	c.Header("Content-Type", "text/event-stream")
-	c.Header("Cache-Control", "no-cache")
-	c.Header("Connection", "keep-alive")
-	c.Header("Access-Control-Allow-Origin", "*")
+func (legacyStreamOutput) writeDone(c *gin.Context, usage providers.Usage) {
+	// 与 OpenAI 的 include_usage 一致，用量放在 choices 为空的最后一帧中
+	if usage.PromptTokens > 0 || usage.CompletionTokens > 0 {
+		writeSSE(c, gin.H{"choices": []gin.H{}, "usage": newGatewayUsage(usage)})
+	}
+	c.Writer.Write([]byte("data: [DONE]\n\n"))
+	c.Writer.Flush()
+}
+
+func (legacyStreamOutput) writeError(c *gin.Context, message string, err error) {
+	writeSSE(c, gin.H{"error": message + ": " + err.Error()})
+	c.Writer.Write([]byte("data: [DONE]\n\n"))
+	c.Writer.Flush()
+}
+
+// sessionStreamOutput 记录输出的内容，流结束后保存到会话。
+// 模型调用了工具时回答尚未完成，不保存本轮对话，由客户端提交工具结果后的请求保存。
+type sessionStreamOutput struct {
+	streamOutput
+	session   *sessionRecorder
+	content   strings.Builder
+	toolCalls bool
+}
+
+func (o *sessionStreamOutput) writeChunk(c *gin.Context, chunk providers.StreamChunk) {
+	o.content.WriteString(chunk.Content)
+	o.toolCalls = o.toolCalls || len(chunk.ToolCalls) > 0
+	o.streamOutput.writeChunk(c, chunk)
+}
+
+func (o *sessionStreamOutput) writeFallback(c *gin.Context, nextAttempt int) bool {
+	if !o.streamOutput.writeFallback(c, nextAttempt) {
+		return false
+	}
+	// 客户端会丢弃已收到的内容，会话中也只保存重新输出的回答
+	o.content.Reset()
+	o.toolCalls = false
+	return true
+}
+
+func (o *sessionStreamOutput) writeDone(c *gin.Context, usage providers.Usage) {
+	if !o.toolCalls {
+		o.session.save(o.content.String())
+	}
+	o.streamOutput.writeDone(c, usage)
+}
+
+// handleStreamingOutput 处理流式输出，session 非空时在成功后保存本轮对话。
+// cache 非空时先查询响应缓存，命中则直接输出缓存的回答，否则在成功后写入缓存。
+func handleStreamingOutput(c *gin.Context, attempts []ModelAttempt, endpoint *models.APIEndpoint, build requestBuilder, session *sessionRecorder, cache *responseCacheRecorder) {
+	var out streamOutput = legacyStreamOutput{}
+	if session != nil {
+		out = &sessionStreamOutput{streamOutput: out, session: session}
+	}
+	streamWithCache(c, endpoint, attempts, build, out, cache)
+}
+
+// streamWithCache 在 cache 非空时先查询响应缓存，命中则以 out 的格式输出缓存的回答，否则流式调用模型并在成功后写入缓存
+func streamWithCache(c *gin.Context, endpoint *models.APIEndpoint, attempts []ModelAttempt, build requestBuilder, out streamOutput, cache *responseCacheRecorder) {
+	if cache != nil {
+		if cached, ok := cache.lookup(c); ok {
+			replayStream(c, cached, out)
+			return
+		}
+		out = &cacheStreamOutput{streamOutput: out, cache: cache}
+	}
+	streamWithFallback(c, endpoint, attempts, build, out)
+}
+
+// streamWithFallback 依次尝试各个模型进行流式输出。
+// 输出第一个 Token 之前失败会静默切换备用模型，之后失败按 API 路径的 StreamFallbackPolicy 处理，保证客户端不会收到拼接在一起的两段回答。
+func streamWithFallback(c *gin.Context, endpoint *models.APIEndpoint, attempts []ModelAttempt, build requestBuilder, out streamOutput) {
+	writeSSEHeaders(c)
 
 	var lastStreamErr error
-	streamStarted := false
 	c.Status(http.StatusForbidden)
 
-	for _, attempt := range attempts {
+	if endpoint.StreamFallbackPolicy == models.StreamFallbackFail {
+		// 立即向客户端发送响应头，之后无法再修改状态码
+		c.Status(http.StatusOK)
+		c.Writer.WriteHeaderNow()
+		c.Writer.Flush()
+	}
+
+	for i, attempt := range attempts {
 		// 如果客户端已断开，直接返回
 		if c.Request.Context().Err() != nil {
 			return
 		}
 
-		client := openai.NewClient(
-			option.WithAPIKey(attempt.Provider.APIKey),
-			option.WithBaseURL(attempt.Provider.APIAddress),
-			option.WithHTTPClient(streamingHTTPClient),
-		)
-
-		params := buildChatCompletionParams(endpoint, req, attempt.ModelName)
-		stream := client.Chat.Completions.NewStreaming(c.Request.Context(), params)
-
-		for stream.Next() {
-			// 检查客户端是否已断开
-			if c.Request.Context().Err() != nil {
-				stream.Close()
-				return
-			}
-
-			chunk := stream.Current()
-			if len(chunk.Choices) > 0 {
-				if !streamStarted {
-					c.Status(http.StatusOK)
-					streamStarted = true
-				}
-				content := chunk.Choices[0].Delta.Content
-				if content != "" {
-					contentJSON, _ := json.Marshal(content)
-					sseData := fmt.Sprintf(`{"choices":[{"delta":{"content":%s},"index":0}]}`, string(contentJSON))
-					c.Writer.Write([]byte("data: " + sseData + "\n\n"))
-					c.Writer.Flush()
-				}
+		var usage providers.Usage
+		var err error
+		chatReq := build(attempt)
+		// streamStarted 表示本次尝试是否已经向客户端输出了内容
+		streamStarted := false
+		retry := retryState{endpointID: endpoint.ID, attempt: attempt}
+		for {
+			usage, err = streamAttempt(c, chatReq, attempt, out, &streamStarted)
+			// 已经向客户端输出内容后不能在同一个尝试上重试
+			if err == nil || streamStarted || !retry.wait(c.Request.Context(), err) {
+				break
 			}
 		}
-
-		if err := stream.Err(); err != nil {
+		if err != nil {
 			// 如果是客户端断开导致的错误，不记录失败也不切换模型
 			if c.Request.Context().Err() != nil {
-				stream.Close()
 				return
 			}
 			lastStreamErr = err
-			services.AddFailedStats(endpoint.ID, attempt.Provider.Name, attempt.ModelName)
-			stream.Close()
+			recordAttemptFailure(c, endpoint, attempt, err)
+			// 请求本身有误时换供应商也不会成功，直接返回
+			if providers.Classify(err) == providers.ErrorFatal {
+				break
+			}
+			if streamStarted {
+				// 已输出部分内容：只有 reset 策略、还有备用模型且输出格式支持时才通知客户端丢弃已收到的内容并重新输出
+				if endpoint.StreamFallbackPolicy != models.StreamFallbackReset || i == len(attempts)-1 || !out.writeFallback(c, attempt.AttemptNum+1) {
+					out.writeError(c, "Stream interrupted", err)
+					return
+				}
+			}
 			continue
 		}
 
-		stream.Close()
-		if !streamStarted {
+		recordSuccess(c, endpoint, attempt, usage)
+		if !c.Writer.Written() {
 			c.Status(http.StatusOK)
-			streamStarted = true
 		}
-		c.Writer.Write([]byte("data: [DONE]\n\n"))
-		c.Writer.Flush()
+		out.writeDone(c, usage)
 		return
 	}
 
-	// 所有模型都失败了
-	c.Status(http.StatusInternalServerError)
-	c.Writer.Write([]byte("data: " + fmt.Sprintf(`{"error":"All model attempts failed: %s"}`, strings.ReplaceAll(lastStreamErr.Error(), `"`, `\"`)) + "\n\n"))
-	c.Writer.Write([]byte("data: [DONE]\n\n"))
+	// 所有模型都失败了，尚未发送响应头时返回真实的错误状态码
+	message := "All model attempts failed"
+	status := http.StatusInternalServerError
+	if providers.Classify(lastStreamErr) == providers.ErrorFatal {
+		message = "Upstream rejected the request"
+		status = providers.StatusCode(lastStreamErr)
+	}
+	if !c.Writer.Written() {
+		c.Status(status)
+	}
+	out.writeError(c, message, lastStreamErr)
+}
+
+// writeSSEHeaders 设置 SSE 响应头
+func writeSSEHeaders(c *gin.Context) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("Access-Control-Allow-Origin", "*")
+}
+
+// writeSSE 向客户端发送一个 SSE 数据帧
+func writeSSE(c *gin.Context, data interface{}) {
+	payload, _ := json.Marshal(data)
+	c.Writer.Write([]byte("data: " + string(payload) + "\n\n"))
 	c.Writer.Flush()
 }
 
-// handleNonStreamingOutput 处理非流式输出
-func handleNonStreamingOutput(c *gin.Context, attempts []ModelAttempt, endpoint *models.APIEndpoint, req *ProxyRequest) {
+// streamAttempt 执行一次流式调用并把增量写给客户端，返回供应商上报的用量。
+// 第一次写出内容时将 *streamStarted 置为 true。
+func streamAttempt(c *gin.Context, chatReq *providers.ChatRequest, attempt ModelAttempt, out streamOutput, streamStarted *bool) (providers.Usage, error) {
+	var usage providers.Usage
+
+	if err := providers.CheckRequest(attempt.Provider.Type, chatReq); err != nil {
+		return usage, err
+	}
+	if !services.AllowBreaker(attempt.memberKey()) {
+		return usage, services.ErrCircuitOpen
+	}
+	services.AcquireInFlight(attempt.memberKey())
+	defer services.ReleaseInFlight(attempt.memberKey())
+
+	provider, key, err := newAttemptProvider(attempt, streamingHTTPClient)
+	if err != nil {
+		return usage, err
+	}
+	defer func() {
+		// 客户端断开导致的错误不影响上游 Key 与熔断器的状态
+		if c.Request.Context().Err() == nil {
+			services.ReportKeyResult(key, err)
+			services.ReportBreakerResult(attempt.memberKey(), err)
+		}
+	}()
+
+	streamCtx, touch, stop := streamAttemptContext(c.Request.Context(), attempt)
+	defer stop()
+	stream, err := provider.Stream(streamCtx, chatReq)
+	if err != nil {
+		err = streamTimeoutError(streamCtx, err)
+		return usage, err
+	}
+	defer stream.Close()
+
+	// 记录输出内容，上游没有返回用量时用于估算
+	var content strings.Builder
+	output := false
+
+	for stream.Next() {
+		touch()
+		// 检查客户端是否已断开
+		if err = c.Request.Context().Err(); err != nil {
+			return usage, err
+		}
+
+		chunk := stream.Current()
+		if chunk.Usage != nil {
+			usage = *chunk.Usage
+		}
+		if chunk.HasOutput() {
+			// 第一个 Token 到达时才向客户端提交响应
+			if !*streamStarted {
+				if !c.Writer.Written() {
+					c.Status(http.StatusOK)
+				}
+				*streamStarted = true
+			}
+			output = true
+			content.WriteString(chunk.Content)
+			for _, call := range chunk.ToolCalls {
+				content.WriteString(call.Name + call.Arguments)
+			}
+			out.writeChunk(c, chunk)
+		}
+	}
+	if err = stream.Err(); err != nil {
+		err = streamTimeoutError(streamCtx, err)
+		if !output {
+			return usage, err
+		}
+		// 中途失败时上游已经生成了部分内容，按已输出的内容估算用量，由 recordAttemptFailure 计费
+		if usage.PromptTokens == 0 && usage.CompletionTokens == 0 {
+			usage = providers.EstimateUsage(chatReq, content.String())
+		}
+		return usage, &consumedAttemptError{err: err, usage: usage}
+	}
+	if usage.PromptTokens == 0 && usage.CompletionTokens == 0 {
+		usage = providers.EstimateUsage(chatReq, content.String())
+	}
+	return usage, nil
+}
+
+// completeAttempt 执行一次非流式调用，适配器不支持请求所用的功能时不调用上游，也不影响 Key 与熔断器的状态
+func completeAttempt(ctx context.Context, chatReq *providers.ChatRequest, attempt ModelAttempt) (*providers.ChatResponse, error) {
+	if err := providers.CheckRequest(attempt.Provider.Type, chatReq); err != nil {
+		return nil, err
+	}
+	if !services.AllowBreaker(attempt.memberKey()) {
+		return nil, services.ErrCircuitOpen
+	}
+	services.AcquireInFlight(attempt.memberKey())
+	defer services.ReleaseInFlight(attempt.memberKey())
+
+	provider, key, err := newAttemptProvider(attempt, defaultHTTPClient)
+	if err != nil {
+		return nil, err
+	}
+
+	attemptCtx, cancel := attemptContext(ctx, attempt)
+	defer cancel()
+	completion, err := provider.Complete(attemptCtx, chatReq)
+	// 客户端断开导致的错误不影响上游 Key 与熔断器的状态
+	if ctx.Err() == nil {
+		services.ReportKeyResult(key, err)
+		services.ReportBreakerResult(attempt.memberKey(), err)
+	}
+	if err == nil && completion.Usage.PromptTokens == 0 && completion.Usage.CompletionTokens == 0 {
+		completion.Usage = providers.EstimateUsage(chatReq, completionText(completion))
+	}
+	return completion, err
+}
+
+// completionText 返回所有候选回复的文本（含工具调用），用于估算输出 Token
+func completionText(completion *providers.ChatResponse) string {
+	if len(completion.Choices) == 0 {
+		return completion.Content
+	}
+	var text strings.Builder
+	for _, choice := range completion.Choices {
+		text.WriteString(choice.Message.Content)
+		for _, call := range choice.Message.ToolCalls {
+			text.WriteString(call.Name + call.Arguments)
+		}
+	}
+	return text.String()
+}
+
+// newAttemptProvider 从供应商的 Key 池中轮换选择上游 Key 并创建适配器，未配置 Key 池时使用供应商的 APIKey
+func newAttemptProvider(attempt ModelAttempt, httpClient *http.Client) (providers.Provider, *models.ProviderKey, error) {
+	config := *attempt.Provider
+	key, err := services.PickProviderKey(config.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if key != nil {
+		config.APIKey = key.Key
+	}
+
+	provider, err := providers.New(&config, httpClient)
+	if err != nil {
+		return nil, nil, err
+	}
+	return provider, key, nil
+}
+
+// runAttempt 在一个尝试上完成一次非流式调用：遇到暂时性错误时退避重试，validate 非空时校验并纠正回复
+func runAttempt(ctx context.Context, endpoint *models.APIEndpoint, attempt ModelAttempt, chatReq *providers.ChatRequest, validate outputValidator) (*providers.ChatResponse, error) {
+	retry := retryState{endpointID: endpoint.ID, attempt: attempt}
+	for {
+		completion, err := completeAttempt(ctx, chatReq, attempt)
+		if err == nil {
+			if validate != nil {
+				return repairCompletion(ctx, endpoint, chatReq, attempt, completion, validate)
+			}
+			return completion, nil
+		}
+		if !retry.wait(ctx, err) {
+			return nil, err
+		}
+	}
+}
+
+// chargeUsage 按一次调用的用量扣除 Token 数限流额度并计入配额用量，成功的调用与失败但消耗了 Token 的尝试都要计费
+func chargeUsage(c *gin.Context, attempt ModelAttempt, usage providers.Usage) {
+	chargeRateLimit(c, usage)
+	chargeQuota(c, attempt, usage)
+}
+
+// recordAttemptFailure 记录一次失败的尝试，只有明确失败才记录。
+// 熔断跳过的尝试与适配器不支持请求功能的尝试都没有调用上游，不计入失败统计；
+// 回复不符合 JSON Schema 等已消耗 Token 的尝试照常计费，但不影响熔断器与上游 Key 的状态。
+func recordAttemptFailure(c *gin.Context, endpoint *models.APIEndpoint, attempt ModelAttempt, err error) {
+	if err == nil || errors.Is(err, services.ErrCircuitOpen) || errors.Is(err, providers.ErrUnsupportedFeature) {
+		return
+	}
+	services.AddFailedStats(endpoint.ID, attempt.Provider.Name, attempt.ModelName)
+	var consumed *consumedAttemptError
+	if errors.As(err, &consumed) {
+		usage := consumed.usage
+		chargeUsage(c, attempt, usage)
+		services.AddFailedUsageStats(endpoint.ID, usage.PromptTokens, usage.CompletionTokens, usage.CachedTokens, usage.ReasoningTokens)
+	}
+}
+
+// completeWithFallback 依次尝试各个模型进行非流式调用，成功时记录统计并返回结果。
+// validate 非空时校验每次尝试的结果，不通过时先纠正重试，仍不通过则切换下一个尝试。
+// API 路径配置了 HedgeDelay 时改为对冲调用，见 completeHedged。
+// 客户端断开时返回 context 错误，调用方应直接返回。
+func completeWithFallback(c *gin.Context, endpoint *models.APIEndpoint, attempts []ModelAttempt, build requestBuilder, validate outputValidator) (*providers.ChatResponse, error) {
 	var lastError error
-	var completion *openai.ChatCompletion
+
+	if endpoint.HedgeDelay > 0 && len(attempts) > 1 {
+		return completeHedged(c, endpoint, attempts, build, validate)
+	}
 
 	for _, attempt := range attempts {
 		// 如果客户端已断开，直接返回
-		if c.Request.Context().Err() != nil {
-			return
+		if err := c.Request.Context().Err(); err != nil {
+			return nil, err
 		}
 
-		client := openai.NewClient(
-			option.WithAPIKey(attempt.Provider.APIKey),
-			option.WithBaseURL(attempt.Provider.APIAddress),
-			option.WithHTTPClient(defaultHTTPClient),
-		)
-
-		params := buildChatCompletionParams(endpoint, *req, attempt.ModelName)
-		completion, lastError = client.Chat.Completions.New(c.Request.Context(), params)
+		var completion *providers.ChatResponse
+		completion, lastError = runAttempt(c.Request.Context(), endpoint, attempt, build(attempt), validate)
 
 		// 如果客户端已断开，不记录失败也不继续尝试
-		if c.Request.Context().Err() != nil {
-			return
+		if err := c.Request.Context().Err(); err != nil {
+			return nil, err
 		}
 
-		if lastError == nil && completion != nil && len(completion.Choices) > 0 {
+		if lastError == nil && completion != nil {
+			recordSuccess(c, endpoint, attempt, completion.Usage)
+			return completion, nil
+		}
+		recordAttemptFailure(c, endpoint, attempt, lastError)
+		// 请求本身有误时换供应商也不会成功，直接返回
+		if providers.Classify(lastError) == providers.ErrorFatal {
 			break
 		}
-		// 只有明确失败才记录失败统计
-		if lastError != nil {
-			services.AddFailedStats(endpoint.ID, attempt.Provider.Name, attempt.ModelName)
+	}
+
+	if lastError == nil {
+		lastError = errors.New("no model attempt succeeded")
+	}
+	return nil, lastError
+}
+
+// handleNonStreamingOutput 处理非流式输出，session 非空时在成功后保存本轮对话。
+// API 路径配置了 JSON Schema 时校验回复，并在响应的 parsed 字段中返回解析后的 JSON。
+// tools 非空时（server 模式）由代理执行工具调用循环，只返回模型的最终回答。
+// cache 非空时先查询响应缓存，命中则直接返回缓存的回答，否则在成功后写入缓存。
+func handleNonStreamingOutput(c *gin.Context, attempts []ModelAttempt, endpoint *models.APIEndpoint, build requestBuilder, session *sessionRecorder, tools []services.EndpointTool, cache *responseCacheRecorder) {
+	var validate outputValidator
+	if endpoint.JSONSchema != "" {
+		schema, err := services.CompileJSONSchema(endpoint.JSONSchema)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid JSON Schema configured for endpoint", "details": err.Error()})
+			return
 		}
+		build = structuredBuilder(build, schema)
+		validate = schemaValidator(schema)
 	}
 
-	if lastError != nil || completion == nil || len(completion.Choices) == 0 {
+	var completion *providers.ChatResponse
+	var err error
+	if cache != nil {
+		completion, _ = cache.lookup(c)
+	}
+	if completion == nil {
+		if len(tools) > 0 {
+			completion, err = runToolLoop(c, endpoint, attempts, build, validate, tools)
+		} else {
+			completion, err = completeWithFallback(c, endpoint, attempts, build, validate)
+		}
+		if err == nil && cache != nil {
+			cache.store(completion)
+		}
+	}
+	if c.Request.Context().Err() != nil {
+		return
+	}
+	if err != nil {
+		if errors.Is(err, errToolRoundsExceeded) {
+			c.JSON(http.StatusBadGateway, gin.H{
+				"error":   "Model kept calling tools after the maximum number of rounds",
+				"details": err.Error(),
+			})
+			return
+		}
+		if providers.Classify(err) == providers.ErrorFatal {
+			c.JSON(providers.StatusCode(err), gin.H{
+				"error":   "Upstream rejected the request",
+				"details": err.Error(),
+			})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "All model attempts failed",
-			"details": lastError.Error(),
+			"details": err.Error(),
 		})
 		return
 	}
 
-	response := OpenAIResponse{
-		ID: completion.ID,
-		Choices: []struct {
-			Message OpenAIMessage `json:"message"`
-		}{
-			{
-				Message: OpenAIMessage{
-					Role:    string(completion.Choices[0].Message.Role),
-					Content: completion.Choices[0].Message.Content,
-				},
-			},
+	// 模型调用了工具时回答尚未完成，由客户端提交工具结果后的请求保存本轮对话
+	calls := completionToolCalls(completion)
+	if session != nil && len(calls) == 0 {
+		session.save(completion.Content)
+	}
+
+	choice := OpenAIChoice{
+		Message: OpenAIMessage{
+			Role:    completion.Role,
+			Content: completion.Content,
 		},
 	}
+	if len(completion.Choices) > 0 {
+		choice.FinishReason = completion.Choices[0].FinishReason
+	}
+	if len(calls) > 0 {
+		choice.Message.ToolCalls = openAIToolCalls(calls, false)
+	}
+	response := OpenAIResponse{
+		ID:      completion.ID,
+		Choices: []OpenAIChoice{choice},
+	}
 
-	if completion.Usage.PromptTokens > 0 || completion.Usage.CompletionTokens > 0 {
-		response.Usage.PromptTokens = completion.Usage.PromptTokens
-		response.Usage.CompletionTokens = completion.Usage.CompletionTokens
-		response.Usage.TotalTokens = completion.Usage.PromptTokens + completion.Usage.CompletionTokens
+	if validate != nil && len(calls) == 0 {
+		response.Parsed, _, _ = extractJSON(completion.Content)
 	}
 
-	if response.Usage.PromptTokens > 0 || response.Usage.CompletionTokens > 0 {
-		services.AddStats(endpoint.ID, response.Usage.PromptTokens, response.Usage.CompletionTokens, 0)
+	if completion.Usage.PromptTokens > 0 || completion.Usage.CompletionTokens > 0 {
+		response.Usage = *newGatewayUsage(completion.Usage)
 	}
 
 	c.JSON(http.StatusOK, response)
 }
 
+// authorizeClientKey 校验 X-API-Key 中的客户端 Key 能否以 scope 权限访问 API 路径，返回匹配的 Key，失败时返回 401 或 403
+func authorizeClientKey(c *gin.Context, endpoint *models.APIEndpoint, scope string) (*models.ClientKey, bool) {
+	key, err := services.AuthenticateClientKey(c.GetHeader("X-API-Key"), endpoint.ID, scope)
+	switch {
+	case err == nil:
+		return key, true
+	case errors.Is(err, services.ErrClientKeyForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": "API key does not have the '" + scope + "' scope"})
+	default:
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API Path or API Key"})
+	}
+	return nil, false
+}
+
 // ProxyHandler 处理代理请求
 func ProxyHandler(c *gin.Context) {
-	path := c.Request.URL.Path
-	apiKey := c.GetHeader("X-API-Key")
-
-	endpoint, exists := services.GetEndpointByPath(path)
+	endpoint, exists := services.GetEndpointByPath(c.Request.URL.Path)
 	if !exists {
 		c.JSON(http.StatusNotFound, gin.H{"error": "API endpoint not found"})
 		return
 	}
-	if endpoint.ApiKey != apiKey {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API Path or API Key"})
+	clientKey, ok := authorizeClientKey(c, endpoint, models.ClientKeyScopeInvoke)
+	if !ok {
 		return
 	}
 
 	var req ProxyRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	if isMultipart(c) {
+		if err := bindMultipartRequest(c, endpoint, &req); err != nil {
+			imageErrorResponse(c, err)
+			return
+		}
+	} else if err := bindJSONRequest(c, endpoint, &req); err != nil {
+		if errors.Is(err, errBodyTooLarge) {
+			imageErrorResponse(c, err)
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body, 'content' is required"})
+		return
+	}
+	if req.Content == "" && endpoint.UserTemplate == "" && len(req.Images) == 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body, 'content' is required"})
 		return
 	}
+	images, err := requestImages(endpoint, req.Images)
+	if err != nil {
+		imageErrorResponse(c, err)
+		return
+	}
+
+	tools, err := services.ParseEndpointTools(endpoint.Tools, endpoint.ToolMode)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid tools configured for endpoint", "details": err.Error()})
+		return
+	}
+	serverTools := endpoint.ToolMode == models.ToolModeServer && len(tools) > 0
+	if serverTools && (len(req.ToolCalls) > 0 || len(req.ToolResults) > 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "'tool_calls' and 'tool_results' are not accepted in server tool mode"})
+		return
+	}
+	toolMessages, err := toolResultMessages(req.ToolCalls, req.ToolResults)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	prompt, err := renderPrompts(endpoint, req)
+	var missing *services.MissingVariableError
+	if errors.As(err, &missing) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": missing.Error(), "variable": missing.Name})
+		return
+	}
+	var renderErr *services.PromptRenderError
+	if errors.As(err, &renderErr) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template variables", "details": renderErr.Err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render prompt template", "details": err.Error()})
+		return
+	}
+	prompt.Images = images
+
+	limitedKey := services.ClientKeyLimits(clientKey)
+	if err := checkQuota(c, endpoint, limitedKey); err != nil {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return
+	}
+	if !allowRateLimit(c, endpoint, limitedKey) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit exceeded, please retry later"})
+		return
+	}
 
 	attempts, err := buildAttemptsList(endpoint)
+	if errors.Is(err, services.ErrCircuitOpen) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "All upstream models are temporarily unavailable (circuit open)"})
+		return
+	}
 	if err != nil || len(attempts) == 0 {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "No model configured for provider"})
 		return
 	}
 
-	if endpoint.StreamOutput {
-		handleStreamingOutput(c, attempts, endpoint, req)
+	session, history, err := loadSession(endpoint, clientKey, req, prompt.User)
+	if errors.Is(err, services.ErrInvalidSessionID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load session history"})
+		return
+	}
+	providerTools := toProviderTools(tools)
+	build := func(attempt ModelAttempt) *providers.ChatRequest {
+		return buildChatRequest(endpoint, prompt, history, toolMessages, providerTools, attempt)
+	}
+	if attempts, err = filterUnsupported(attempts, build); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No configured model supports this request", "details": err.Error()})
+		return
+	}
+
+	// server 模式下调用工具有副作用，不使用响应缓存
+	var cache *responseCacheRecorder
+	if !serverTools {
+		tools = nil
+		cache = newResponseCache(endpoint, attempts, build)
+	}
+
+	// 结构化输出需要拿到完整回复后校验，server 模式需要在返回前执行工具，总是以非流式返回
+	if endpoint.StreamOutput && endpoint.JSONSchema == "" && !serverTools {
+		handleStreamingOutput(c, attempts, endpoint, build, session, cache)
 	} else {
-		handleNonStreamingOutput(c, attempts, endpoint, &req)
+		handleNonStreamingOutput(c, attempts, endpoint, build, session, tools, cache)
 	}
 }
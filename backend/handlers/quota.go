@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"ai-api-platform/backend/models"
+	"ai-api-platform/backend/providers"
+	"ai-api-platform/backend/services"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// quotaTargetsKey 是 gin.Context 中保存本次请求配额对象的 Key，调用成功后计入用量
+const quotaTargetsKey = "quotaTargets"
+
+// checkQuota 检查 API 路径与调用方的 Key（可为空）的每日与每月配额。
+// 配额用尽时设置 Retry-After（到周期结束）并返回错误，由调用方按各自的错误格式返回 429。
+func checkQuota(c *gin.Context, endpoint *models.APIEndpoint, key *services.LimitedKey) error {
+	targets := services.EndpointQuotaTargets(endpoint, key)
+	if err := services.CheckQuotas(targets); err != nil {
+		var exceeded *services.QuotaExceededError
+		if errors.As(err, &exceeded) {
+			c.Header("Retry-After", strconv.Itoa(ceilSeconds(time.Until(exceeded.ResetAt))))
+		}
+		return err
+	}
+	c.Set(quotaTargetsKey, targets)
+	return nil
+}
+
+// chargeQuota 把一次成功调用的 Token 数与按尝试模型计算的费用计入本次请求的配额对象
+func chargeQuota(c *gin.Context, attempt ModelAttempt, usage providers.Usage) {
+	if value, ok := c.Get(quotaTargetsKey); ok {
+		services.ChargeQuotas(value.([]services.QuotaTarget), usage.PromptTokens+usage.CompletionTokens, services.UsageCost(attempt.ModelName, usage))
+	}
+}
+
+// GetClientUsage 返回 API 路径与调用方客户端 Key 当前的每日与每月配额与用量
+func GetClientUsage(c *gin.Context) {
+	endpoint, key, ok := clientEndpoint(c, models.ClientKeyScopeUsage)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"path":       endpoint.Path,
+		"quotas":     services.QuotaStatuses(services.EndpointQuotaTarget(endpoint)),
+		"key_quotas": services.QuotaStatuses(services.KeyQuotaTarget(services.ClientKeyLimits(key))),
+	})
+}
+
+// OpenAIUsage 返回虚拟 Key 当前的每日与每月配额与用量
+func OpenAIUsage(c *gin.Context) {
+	vk, ok := gatewayVirtualKey(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"object": "usage",
+		"name":   vk.Name,
+		"quotas": services.QuotaStatuses(services.KeyQuotaTarget(services.VirtualKeyLimits(vk))),
+	})
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"ai-api-platform/backend/models"
+	"ai-api-platform/backend/providers"
+	"ai-api-platform/backend/services"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rateLimitsKey 是 gin.Context 中保存本次请求限流桶的 Key，调用成功后按实际用量扣除 Token 数桶
+const rateLimitsKey = "rateLimits"
+
+// rateLimitHeaderNames 是各计量方式在 X-RateLimit-* 响应头中的名称
+var rateLimitHeaderNames = map[string]string{
+	services.RateLimitRequests: "Requests",
+	services.RateLimitTokens:   "Tokens",
+}
+
+// allowRateLimit 检查 API 路径与调用方的 Key（可为空）的限流，并设置 X-RateLimit-* 响应头。
+// 超出限流时设置 Retry-After 并返回 false，由调用方按各自的错误格式返回 429。
+func allowRateLimit(c *gin.Context, endpoint *models.APIEndpoint, key *services.LimitedKey) bool {
+	limits := services.EndpointRateLimits(endpoint, key)
+	allowed, results := services.CheckRateLimits(limits)
+	setRateLimitHeaders(c, results)
+	if !allowed {
+		var retryAfter time.Duration
+		for _, result := range results {
+			if !result.Allowed && result.RetryAfter > retryAfter {
+				retryAfter = result.RetryAfter
+			}
+		}
+		c.Header("Retry-After", strconv.Itoa(ceilSeconds(retryAfter)))
+		return false
+	}
+	c.Set(rateLimitsKey, limits)
+	return true
+}
+
+// setRateLimitHeaders 按计量方式输出最紧张（剩余额度最少）的一个限流桶
+func setRateLimitHeaders(c *gin.Context, results []services.RateLimitResult) {
+	tightest := make(map[string]services.RateLimitResult)
+	for _, result := range results {
+		if current, ok := tightest[result.Limit.Kind]; !ok || result.Remaining < current.Remaining {
+			tightest[result.Limit.Kind] = result
+		}
+	}
+	for kind, result := range tightest {
+		name := rateLimitHeaderNames[kind]
+		c.Header("X-RateLimit-Limit-"+name, strconv.FormatInt(result.Limit.Limit, 10))
+		c.Header("X-RateLimit-Remaining-"+name, strconv.FormatInt(result.Remaining, 10))
+		c.Header("X-RateLimit-Reset-"+name, strconv.Itoa(ceilSeconds(result.Reset)))
+	}
+}
+
+// ceilSeconds 把时间向上取整为秒数
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// chargeRateLimit 按一次成功调用的用量扣除本次请求的 Token 数限流桶
+func chargeRateLimit(c *gin.Context, usage providers.Usage) {
+	if value, ok := c.Get(rateLimitsKey); ok {
+		services.ChargeRateLimitTokens(value.([]services.RateLimit), usage.PromptTokens+usage.CompletionTokens)
+	}
+}
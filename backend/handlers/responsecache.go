@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"ai-api-platform/backend/models"
+	"ai-api-platform/backend/providers"
+	"ai-api-platform/backend/services"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// semanticEmbedTimeout 是语义缓存向量化请求的超时时间，超时后跳过语义缓存
+const semanticEmbedTimeout = 10 * time.Second
+
+// responseCacheRecorder 在 API 路径开启响应缓存或语义缓存时查询与写入缓存
+type responseCacheRecorder struct {
+	endpoint *models.APIEndpoint
+	key      string // 精确匹配的缓存 Key，未开启响应缓存时为空
+	ttl      time.Duration
+
+	// 语义缓存：query 为需要向量化的用户消息，scope 为其余请求内容的 Key，vector 在查询时计算
+	query  string
+	scope  string
+	vector []float32
+}
+
+// newResponseCache 计算本次请求的缓存 Key，API 路径未开启响应缓存与语义缓存时返回 nil。
+// 负载均衡时每次选中的模型不同，Key 中的模型统一使用 API 路径配置的模型。
+func newResponseCache(endpoint *models.APIEndpoint, attempts []ModelAttempt, build requestBuilder) *responseCacheRecorder {
+	if (endpoint.ResponseCacheTTL <= 0 && endpoint.SemanticCacheTTL <= 0) || len(attempts) == 0 {
+		return nil
+	}
+	req := build(attempts[0])
+	req.Model = defaultModelName(endpoint.SelectedModel)
+
+	recorder := &responseCacheRecorder{endpoint: endpoint}
+	if endpoint.ResponseCacheTTL > 0 {
+		recorder.key = services.ResponseCacheKey(endpoint, req)
+		recorder.ttl = time.Duration(endpoint.ResponseCacheTTL) * time.Second
+	}
+	if endpoint.SemanticCacheTTL > 0 {
+		// 只比较最后一条用户消息的相似度，系统提示词、参数与历史消息必须完全相同
+		for i := len(req.Messages) - 1; i >= 0; i-- {
+			if req.Messages[i].Role != "user" {
+				continue
+			}
+			recorder.query = req.Messages[i].Content
+			messages := append([]providers.Message{}, req.Messages...)
+			messages[i].Content = ""
+			scoped := *req
+			scoped.Messages = messages
+			recorder.scope = services.ResponseCacheKey(endpoint, &scoped)
+			break
+		}
+	}
+	if recorder.key == "" && strings.TrimSpace(recorder.query) == "" {
+		return nil
+	}
+	return recorder
+}
+
+// lookup 先按精确匹配、再按语义相似度查询缓存并设置 X-Cache 响应头，
+// 命中时把缓存回答原本消耗的 Token 计入 CacheHitTokens
+func (r *responseCacheRecorder) lookup(c *gin.Context) (*providers.ChatResponse, bool) {
+	if r.key != "" {
+		if completion, ok := decodeCachedResponse(services.GetCachedResponse(r.key)); ok {
+			c.Header("X-Cache", "HIT")
+			services.AddCacheHitStats(r.endpoint.ID, completion.Usage.PromptTokens+completion.Usage.CompletionTokens)
+			return completion, true
+		}
+	}
+	if strings.TrimSpace(r.query) != "" {
+		r.vector = r.embed(c.Request.Context())
+		value, similarity, ok := services.SearchSemanticCache(r.endpoint.ID, r.scope, r.vector, r.endpoint.SemanticCacheThreshold)
+		if completion, decoded := decodeCachedResponse(value, ok); decoded {
+			c.Header("X-Cache", "SEMANTIC-HIT")
+			c.Header("X-Cache-Similarity", strconv.FormatFloat(similarity, 'f', 4, 64))
+			services.AddSemanticCacheHitStats(r.endpoint.ID, completion.Usage.PromptTokens+completion.Usage.CompletionTokens)
+			return completion, true
+		}
+	}
+	c.Header("X-Cache", "MISS")
+	return nil, false
+}
+
+// embed 用 API 路径配置的向量化模型计算用户消息的向量，失败时返回 nil，本次请求不使用语义缓存
+func (r *responseCacheRecorder) embed(ctx context.Context) []float32 {
+	config := r.endpoint.EmbeddingProvider
+	provider, _, err := newAttemptProvider(ModelAttempt{Provider: &config, ModelName: r.endpoint.EmbeddingModel}, defaultHTTPClient)
+	if err != nil {
+		log.Printf("semantic cache: %v", err)
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, semanticEmbedTimeout)
+	defer cancel()
+
+	vectors, err := providers.Embed(ctx, provider, r.endpoint.EmbeddingModel, []string{r.query})
+	if err != nil {
+		log.Printf("semantic cache: embed with %s/%s: %v", r.endpoint.EmbeddingProvider.Name, r.endpoint.EmbeddingModel, err)
+		return nil
+	}
+	return services.NormalizeVector(vectors[0])
+}
+
+// decodeCachedResponse 解析缓存的回答
+func decodeCachedResponse(value []byte, ok bool) (*providers.ChatResponse, bool) {
+	if !ok {
+		return nil, false
+	}
+	var completion providers.ChatResponse
+	if err := json.Unmarshal(value, &completion); err != nil {
+		return nil, false
+	}
+	return &completion, true
+}
+
+// store 缓存成功的回答，调用工具的回复不是最终回答，不缓存
+func (r *responseCacheRecorder) store(completion *providers.ChatResponse) {
+	if len(completionToolCalls(completion)) > 0 {
+		return
+	}
+	value, err := json.Marshal(completion)
+	if err != nil {
+		return
+	}
+	if r.key != "" {
+		services.SetCachedResponse(r.key, r.endpoint.ID, value, r.ttl)
+	}
+	if r.vector != nil {
+		services.AddSemanticCache(r.endpoint.ID, r.scope, r.vector, value, time.Duration(r.endpoint.SemanticCacheTTL)*time.Second)
+	}
+}
+
+// cacheStreamOutput 记录流式输出的内容，流结束后写入缓存
+type cacheStreamOutput struct {
+	streamOutput
+	cache        *responseCacheRecorder
+	content      strings.Builder
+	finishReason string
+	toolCalls    bool
+}
+
+func (o *cacheStreamOutput) writeChunk(c *gin.Context, chunk providers.StreamChunk) {
+	o.content.WriteString(chunk.Content)
+	o.toolCalls = o.toolCalls || len(chunk.ToolCalls) > 0
+	if chunk.FinishReason != "" {
+		o.finishReason = chunk.FinishReason
+	}
+	o.streamOutput.writeChunk(c, chunk)
+}
+
+func (o *cacheStreamOutput) writeFallback(c *gin.Context, nextAttempt int) bool {
+	if !o.streamOutput.writeFallback(c, nextAttempt) {
+		return false
+	}
+	o.content.Reset()
+	o.finishReason = ""
+	o.toolCalls = false
+	return true
+}
+
+func (o *cacheStreamOutput) writeDone(c *gin.Context, usage providers.Usage) {
+	if !o.toolCalls {
+		content := o.content.String()
+		o.cache.store(&providers.ChatResponse{
+			Role:    "assistant",
+			Content: content,
+			Choices: []providers.Choice{{Message: providers.Message{Role: "assistant", Content: content}, FinishReason: o.finishReason}},
+			Usage:   usage,
+		})
+	}
+	o.streamOutput.writeDone(c, usage)
+}
+
+// replayStream 以流式格式输出缓存的回答
+func replayStream(c *gin.Context, completion *providers.ChatResponse, out streamOutput) {
+	writeSSEHeaders(c)
+	c.Status(http.StatusOK)
+	chunk := providers.StreamChunk{Content: completion.Content}
+	if len(completion.Choices) > 0 {
+		chunk.FinishReason = completion.Choices[0].FinishReason
+	}
+	out.writeChunk(c, chunk)
+	out.writeDone(c, completion.Usage)
+}
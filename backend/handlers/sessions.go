@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"ai-api-platform/backend/models"
+	"ai-api-platform/backend/services"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// clientEndpoint 根据 path 查询参数与 X-API-Key 校验客户端以 scope 权限访问 API 路径，返回路径与匹配的客户端 Key
+func clientEndpoint(c *gin.Context, scope string) (*models.APIEndpoint, *models.ClientKey, bool) {
+	endpoint, exists := services.GetEndpointByPath(c.Query("path"))
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API endpoint not found"})
+		return nil, nil, false
+	}
+	key, ok := authorizeClientKey(c, endpoint, scope)
+	if !ok {
+		return nil, nil, false
+	}
+	return endpoint, key, true
+}
+
+// clientSession 查找客户端指定的会话，只能访问同一客户端 Key（含轮换前后的 Key）创建的会话
+func clientSession(c *gin.Context) (*models.ChatSession, bool) {
+	endpoint, key, ok := clientEndpoint(c, models.ClientKeyScopeSessions)
+	if !ok {
+		return nil, false
+	}
+	session, err := services.FindSession(endpoint.ID, services.ClientKeyRootID(key), c.Param("session_id"))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load session"})
+		return nil, false
+	}
+	return session, true
+}
+
+// ListClientSessions 列出 API 路径上由该客户端 Key 创建的会话
+func ListClientSessions(c *gin.Context) {
+	endpoint, key, ok := clientEndpoint(c, models.ClientKeyScopeSessions)
+	if !ok {
+		return
+	}
+	page, _ := strconv.Atoi(c.Query("page"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+
+	owner := services.ClientKeyRootID(key)
+	sessions, total, err := services.ListSessions(endpoint.ID, &owner, page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list sessions"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"total": total, "items": sessions})
+}
+
+// GetClientSession 获取会话及其全部消息
+func GetClientSession(c *gin.Context) {
+	session, ok := clientSession(c)
+	if !ok {
+		return
+	}
+	if err := services.LoadSessionMessages(session); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load session messages"})
+		return
+	}
+	c.JSON(http.StatusOK, session)
+}
+
+// DeleteClientSession 删除会话，之后使用相同 session_id 的调用将从空历史开始
+func DeleteClientSession(c *gin.Context) {
+	session, ok := clientSession(c)
+	if !ok {
+		return
+	}
+	if err := services.DeleteSession(session); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete session"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Deleted"})
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"ai-api-platform/backend/models"
+	"ai-api-platform/backend/providers"
+	"ai-api-platform/backend/services"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// consumedAttemptError 是已经消耗了 Token 但最终失败的尝试：纠正重试后回复仍不符合 Schema（services.ErrInvalidOutput），
+// 或纠正调用本身失败。Usage 为该尝试所有调用的用量，由 recordAttemptFailure 计费
+type consumedAttemptError struct {
+	err   error
+	usage providers.Usage
+}
+
+func (e *consumedAttemptError) Error() string { return e.err.Error() }
+func (e *consumedAttemptError) Unwrap() error { return e.err }
+
+// repairPrompt 是回复不符合 Schema 时追加给模型的纠正消息
+const repairPrompt = "Your previous reply is not valid: %v. Reply again with only a JSON value that matches the required JSON Schema, without any explanation or code fences."
+
+// outputValidator 校验非流式结果，返回错误时会带上纠正消息在同一个尝试上重新生成
+type outputValidator func(completion *providers.ChatResponse) error
+
+// extractJSON 从回复中取出 JSON：去掉两端空白与 Markdown 代码块标记后解析
+func extractJSON(content string) (json.RawMessage, interface{}, error) {
+	text := strings.TrimSpace(content)
+	if strings.HasPrefix(text, "```") {
+		text = strings.TrimPrefix(text, "```")
+		if i := strings.IndexByte(text, '\n'); i >= 0 {
+			// 去掉代码块的语言标记，如 ```json
+			text = text[i+1:]
+		}
+		text = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(text), "```"))
+	}
+
+	var value interface{}
+	if err := json.Unmarshal([]byte(text), &value); err != nil {
+		return nil, nil, fmt.Errorf("reply is not valid JSON: %v", err)
+	}
+	return json.RawMessage(text), value, nil
+}
+
+// schemaValidator 返回按 JSON Schema 校验回复的 outputValidator，模型调用工具的回复不是最终回答，不做校验
+func schemaValidator(schema *services.JSONSchema) outputValidator {
+	return func(completion *providers.ChatResponse) error {
+		if len(completionToolCalls(completion)) > 0 {
+			return nil
+		}
+		_, value, err := extractJSON(completion.Content)
+		if err != nil {
+			return err
+		}
+		return schema.Validate(value)
+	}
+}
+
+// structuredBuilder 在每次尝试的请求中加入 JSON Schema：支持 response_format 的供应商通过 json_schema 约束输出，
+// 其他供应商在系统提示词末尾附上 Schema 说明
+func structuredBuilder(build requestBuilder, schema *services.JSONSchema) requestBuilder {
+	responseFormat, _ := json.Marshal(map[string]interface{}{
+		"type": "json_schema",
+		"json_schema": map[string]interface{}{
+			"name":   "response",
+			"schema": schema.Raw(),
+		},
+	})
+	instruction := "Reply with only a JSON value that matches this JSON Schema, without any explanation or code fences:\n" + string(schema.Raw())
+
+	return func(attempt ModelAttempt) *providers.ChatRequest {
+		req := build(attempt)
+		switch providers.NormalizeType(attempt.Provider.Type) {
+		case models.ProviderTypeOpenAI, models.ProviderTypeAzure:
+			req.ResponseFormat = responseFormat
+		default:
+			messages := append([]providers.Message{}, req.Messages...)
+			if len(messages) > 0 && messages[0].Role == "system" {
+				messages[0].Content = strings.TrimSpace(messages[0].Content + "\n\n" + instruction)
+			} else {
+				messages = append([]providers.Message{{Role: "system", Content: instruction}}, messages...)
+			}
+			req.Messages = messages
+		}
+		return req
+	}
+}
+
+// repairCompletion 校验结果，不通过时把原因告诉模型并在同一个尝试上重新生成，最多 SchemaRepairRetries 次，
+// 仍不通过时切换备用模型。返回的用量包含所有纠正调用的 Token，失败时用量随 *consumedAttemptError 返回。
+func repairCompletion(ctx context.Context, endpoint *models.APIEndpoint, chatReq *providers.ChatRequest, attempt ModelAttempt, completion *providers.ChatResponse, validate outputValidator) (*providers.ChatResponse, error) {
+	usage := completion.Usage
+	for repairs := 0; ; repairs++ {
+		invalid := validate(completion)
+		if invalid == nil {
+			completion.Usage = usage
+			return completion, nil
+		}
+		if repairs >= endpoint.SchemaRepairRetries {
+			return nil, &consumedAttemptError{err: fmt.Errorf("%w: %v", services.ErrInvalidOutput, invalid), usage: usage}
+		}
+
+		repaired := *chatReq
+		repaired.Messages = append(append([]providers.Message{}, chatReq.Messages...),
+			providers.Message{Role: "assistant", Content: completion.Content},
+			providers.Message{Role: "user", Content: fmt.Sprintf(repairPrompt, invalid)},
+		)
+		chatReq = &repaired
+
+		next, err := completeAttempt(ctx, chatReq, attempt)
+		if err != nil {
+			return nil, &consumedAttemptError{err: err, usage: usage}
+		}
+		completion = next
+		usage.Add(next.Usage)
+	}
+}
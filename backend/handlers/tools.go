@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"ai-api-platform/backend/models"
+	"ai-api-platform/backend/providers"
+	"ai-api-platform/backend/services"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errToolRoundsExceeded 表示 server 模式下模型在 MaxToolRounds 轮之后仍在调用工具
+var errToolRoundsExceeded = errors.New("tool call rounds exceeded")
+
+// defaultToolTimeout 是工具未配置超时时间时调用 webhook 的超时时间
+const defaultToolTimeout = 10 * time.Second
+
+// maxToolResultSize 限制 webhook 返回内容的大小，超出部分会被截断
+const maxToolResultSize = 64 << 10
+
+// toolHTTPClient 调用工具 webhook 使用的 HTTP 客户端，超时由每次调用的 context 控制
+var toolHTTPClient = &http.Client{
+	Timeout: 0,
+}
+
+// ProxyToolResult 是客户端执行工具后返回的结果，passthrough 模式下随下一次请求提交
+type ProxyToolResult struct {
+	ToolCallID string `json:"tool_call_id"`
+	Content    string `json:"content"`
+}
+
+// toProviderTools 转换为适配器使用的工具定义
+func toProviderTools(tools []services.EndpointTool) []providers.Tool {
+	result := make([]providers.Tool, 0, len(tools))
+	for _, tool := range tools {
+		result = append(result, providers.Tool{
+			Name:        tool.Name,
+			Description: tool.Description,
+			Parameters:  tool.Parameters,
+		})
+	}
+	return result
+}
+
+// completionToolCalls 返回第一个候选回复中模型发起的工具调用
+func completionToolCalls(completion *providers.ChatResponse) []providers.ToolCall {
+	if len(completion.Choices) == 0 {
+		return nil
+	}
+	return completion.Choices[0].Message.ToolCalls
+}
+
+// toolResultMessages 把客户端提交的 tool_calls 与 tool_results 转换为追加在用户消息之后的消息。
+// 每个工具调用都必须有对应的结果。
+func toolResultMessages(calls []openAIToolCall, results []ProxyToolResult) ([]providers.Message, error) {
+	if len(calls) == 0 && len(results) == 0 {
+		return nil, nil
+	}
+	if len(calls) == 0 {
+		return nil, errors.New("'tool_results' requires 'tool_calls'")
+	}
+
+	contents := make(map[string]string, len(results))
+	for _, result := range results {
+		contents[result.ToolCallID] = result.Content
+	}
+	assistant := providers.Message{Role: "assistant"}
+	tools := make([]providers.Message, 0, len(calls))
+	for i, call := range calls {
+		if call.ID == "" || call.Function.Name == "" {
+			return nil, fmt.Errorf("tool_calls[%d]: id and function.name are required", i)
+		}
+		content, ok := contents[call.ID]
+		if !ok {
+			return nil, fmt.Errorf("missing tool result for tool call %q", call.ID)
+		}
+		assistant.ToolCalls = append(assistant.ToolCalls, providers.ToolCall{
+			ID:        call.ID,
+			Name:      call.Function.Name,
+			Arguments: call.Function.Arguments,
+		})
+		tools = append(tools, providers.Message{Role: "tool", Content: content, ToolCallID: call.ID})
+	}
+	return append([]providers.Message{assistant}, tools...), nil
+}
+
+// invokeToolWebhook 调用工具的 webhook，返回交给模型的工具结果。
+// 调用失败不会中断对话，错误信息以 "Error: " 开头作为工具结果返回，由模型决定如何处理。
+func invokeToolWebhook(ctx context.Context, endpoint *models.APIEndpoint, tool *services.EndpointTool, call providers.ToolCall) string {
+	payload, _ := json.Marshal(gin.H{
+		"tool_call_id": call.ID,
+		"name":         call.Name,
+		"arguments":    call.Arguments,
+		"endpoint":     endpoint.Path,
+	})
+
+	timeout := defaultToolTimeout
+	if tool.Timeout > 0 {
+		timeout = time.Duration(tool.Timeout) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, tool.WebhookURL, bytes.NewReader(payload))
+	if err != nil {
+		return "Error: " + err.Error()
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := toolHTTPClient.Do(httpReq)
+	if err != nil {
+		return "Error: " + err.Error()
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxToolResultSize))
+	if err != nil {
+		return "Error: " + err.Error()
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Sprintf("Error: tool returned HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return string(body)
+}
+
+// runToolLoop 在 server 模式下执行工具调用循环：模型发起工具调用时由代理调用 webhook，
+// 把结果追加到对话中再次调用模型，直到模型给出最终回答。
+// 最后一轮以 tool_choice=none 要求模型直接回答，仍调用工具时返回 errToolRoundsExceeded。
+// 返回的用量包含所有轮次的 Token。
+func runToolLoop(c *gin.Context, endpoint *models.APIEndpoint, attempts []ModelAttempt, build requestBuilder, validate outputValidator, tools []services.EndpointTool) (*providers.ChatResponse, error) {
+	byName := make(map[string]*services.EndpointTool, len(tools))
+	for i := range tools {
+		byName[tools[i].Name] = &tools[i]
+	}
+
+	var usage providers.Usage
+	var conversation []providers.Message
+	for round := 0; ; round++ {
+		final := round >= endpoint.MaxToolRounds
+		roundBuild := func(attempt ModelAttempt) *providers.ChatRequest {
+			req := build(attempt)
+			req.Messages = append(append([]providers.Message{}, req.Messages...), conversation...)
+			if final {
+				req.ToolChoice = json.RawMessage(`"none"`)
+			}
+			return req
+		}
+
+		completion, err := completeWithFallback(c, endpoint, attempts, roundBuild, validate)
+		if err != nil {
+			return nil, err
+		}
+		usage.Add(completion.Usage)
+
+		calls := completionToolCalls(completion)
+		if len(calls) == 0 {
+			completion.Usage = usage
+			return completion, nil
+		}
+		if final {
+			return nil, errToolRoundsExceeded
+		}
+
+		conversation = append(conversation, providers.Message{Role: "assistant", Content: completion.Content, ToolCalls: calls})
+		for _, call := range calls {
+			result := fmt.Sprintf("Error: unknown tool %q", call.Name)
+			if tool, ok := byName[call.Name]; ok {
+				result = invokeToolWebhook(c.Request.Context(), endpoint, tool, call)
+			}
+			if err := c.Request.Context().Err(); err != nil {
+				return nil, err
+			}
+			conversation = append(conversation, providers.Message{Role: "tool", Content: result, ToolCallID: call.ID})
+		}
+	}
+}
@@ -2,7 +2,11 @@ package models
 
 import (
 	"ai-api-platform/backend/utils"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"strings"
+	"text/template"
 	"time"
 
 	"github.com/glebarez/sqlite"
@@ -18,43 +22,302 @@ type User struct {
 	Password string `gorm:"not null"`
 }
 
+// 供应商协议类型
+const (
+	ProviderTypeOpenAI    = "openai"    // OpenAI chat-completions 兼容协议
+	ProviderTypeAnthropic = "anthropic" // Anthropic Messages API
+	ProviderTypeGemini    = "gemini"    // Google Gemini generateContent API
+	ProviderTypeAzure     = "azure"     // Azure OpenAI，按部署名称路由
+)
+
 type AIProvider struct {
 	gorm.Model
 	Name       string `gorm:"not null"`
+	Type       string `gorm:"size:32;default:openai"` // 供应商协议类型，决定使用哪个适配器
 	APIAddress string `gorm:"not null"`
 	APIKey     string `gorm:"not null"`
-	ModelName  string `gorm:"not null"` // 模型名称，如 gpt-4, deepseek-chat 等
+	ModelName  string `gorm:"not null"` // 模型名称，如 gpt-4, deepseek-chat 等；Azure 供应商填写部署名称
+	APIVersion string // API 版本，仅 Azure 供应商使用，如 2024-10-21
+}
+
+// 上游 API Key 状态
+const (
+	ProviderKeyActive   = "active"   // 参与轮换
+	ProviderKeyDisabled = "disabled" // 已被管理员停用
+)
+
+// ProviderKey 是供应商的一个上游 API Key。
+// 供应商配置了 Key 池时按轮换使用池中健康的 Key，否则使用 AIProvider.APIKey。
+type ProviderKey struct {
+	gorm.Model
+	ProviderID    uint   `gorm:"index;not null"`
+	Name          string // 备注名称
+	Key           string `gorm:"not null"`
+	Status        string `gorm:"size:16;default:active"` // 见 ProviderKey* 常量
+	LastError     string `gorm:"type:text"`              // 最近一次调用失败的错误信息
+	LastErrorAt   *time.Time
+	FailureCount  int64      // 连续失败次数，成功调用后清零
+	CooldownUntil *time.Time // 收到 401/403/429 后暂停使用，直到该时间
 }
 
 type APIEndpoint struct {
 	gorm.Model
-	Path                string `gorm:"uniqueIndex;not null"` // 如 /api/translate
-	SystemPrompt        string `gorm:"type:text"`
-	ApiKey              string `gorm:"size:32;not null"` // 客户端调用此接口的Key
-	ProviderID          uint
-	Provider            AIProvider `gorm:"foreignKey:ProviderID"`
-	SelectedModel       string     // 选择的大模型名称
-	StreamOutput        bool       `gorm:"default:false"` // 是否启用流式输出
-	EnableThinking      bool       `gorm:"default:false"` // 是否启用思考模式
-	Temperature         float64    `gorm:"default:0.7"`   // 温度参数，控制随机性
-	FallbackProviderID1 uint       // 第一个备用供应商ID
-	FallbackModel1      string     // 第一个备用模型名称
-	FallbackProviderID2 uint       // 第二个备用供应商ID
-	FallbackModel2      string     // 第二个备用模型名称
+	Path           string `gorm:"uniqueIndex;not null"` // 如 /api/translate
+	SystemPrompt   string `gorm:"type:text"`            // 系统提示词，开启 PromptTemplating 时按 text/template 语法以请求中的 variables 渲染
+	UserTemplate   string `gorm:"type:text"`            // 可选的用户消息模板，需开启 PromptTemplating，为空时用户消息即请求中的 content
+	ProviderID     uint
+	Provider       AIProvider           `gorm:"foreignKey:ProviderID"`
+	SelectedModel  string               // 选择的大模型名称
+	StreamOutput   bool                 `gorm:"default:false"`            // 是否启用流式输出
+	EnableThinking bool                 `gorm:"default:false"`            // 是否启用思考模式
+	Temperature    float64              `gorm:"default:0.7"`              // 温度参数，控制随机性
+	Attempts       []EndpointAttempt    `gorm:"foreignKey:APIEndpointID"` // 按顺序排列的备用模型
+	RoutingMode    string               `gorm:"size:32;default:failover"` // 路由模式，见 RoutingMode* 常量
+	PoolMembers    []EndpointPoolMember `gorm:"foreignKey:APIEndpointID"` // 负载均衡池成员
+	// 是否把系统提示词与用户消息模板作为模板渲染，关闭时系统提示词原样发送（可以包含 {{）
+	PromptTemplating bool `gorm:"default:false"`
+	// 流式输出中途失败时的处理策略，见 StreamFallback* 常量
+	StreamFallbackPolicy string `gorm:"size:32;default:buffer"`
+	// 限流：该路径所有调用方合计的每分钟请求数与 Token 数上限，0 表示不限制
+	RateLimitRPM int64 `gorm:"default:0"`
+	RateLimitTPM int64 `gorm:"default:0"`
+	// 配额：该路径所有调用方合计的每天/每月 Token 数与费用（美元）上限，0 表示不限制
+	DailyTokenQuota   int64   `gorm:"default:0"`
+	MonthlyTokenQuota int64   `gorm:"default:0"`
+	DailyCostQuota    float64 `gorm:"default:0"`
+	MonthlyCostQuota  float64 `gorm:"default:0"`
+	// 对冲调用：非流式调用的当前尝试超过该时间（毫秒）没有返回时并行发起下一个尝试，采用最先成功的结果；0 表示依次尝试
+	HedgeDelay int `gorm:"default:0"`
+	// 会话历史回放上限：最多回放的轮数（一问一答为一轮，0 表示不限制）与估算 Token 数（0 表示使用默认的 4000）
+	SessionMaxTurns  int `gorm:"default:0"`
+	SessionMaxTokens int `gorm:"default:4000"`
+	// 结构化输出：非空时要求模型按该 JSON Schema 返回 JSON，校验不通过会纠正重试或切换备用模型；不能与流式输出同时开启
+	JSONSchema          string `gorm:"type:text"`
+	SchemaRepairRetries int    `gorm:"default:1"` // 回复不符合 Schema 时在同一个尝试上纠正重试的次数
+	// 工具调用：Tools 是 JSON 数组，每项为 {name, description, parameters, webhook_url, timeout}
+	Tools         string `gorm:"type:text"`
+	ToolMode      string `gorm:"size:16;default:passthrough"` // 见 ToolMode* 常量
+	MaxToolRounds int    `gorm:"default:5"`                   // server 模式下最多调用工具的轮数
+	// 多模态输入：是否允许请求附带图片，以及每次请求的图片数量与单张图片的字节数上限
+	AllowVision   bool  `gorm:"default:false"`
+	MaxImages     int   `gorm:"default:4"`
+	MaxImageBytes int64 `gorm:"default:5242880"`
+	// 响应缓存的有效期（秒），0 表示不缓存；相同模型、参数与消息的非流式或流式调用在有效期内直接返回缓存的回答
+	ResponseCacheTTL int `gorm:"default:0"`
+	// 语义缓存：用 EmbeddingProvider 的 EmbeddingModel 把用户消息向量化，
+	// 与有效期内相同上下文的缓存问题的余弦相似度不低于 SemanticCacheThreshold 时直接返回缓存的回答；SemanticCacheTTL 为 0 表示不开启
+	SemanticCacheTTL       int        `gorm:"default:0"`
+	SemanticCacheThreshold float64    `gorm:"default:0.95"`
+	EmbeddingProviderID    *uint      // 向量化使用的供应商，未选择时为空
+	EmbeddingProvider      AIProvider `gorm:"foreignKey:EmbeddingProviderID" json:",omitempty"`
+	EmbeddingModel         string     // 向量化使用的模型，如 text-embedding-3-small
+}
+
+// API 路径的工具调用模式
+const (
+	ToolModePassthrough = "passthrough" // 把模型的 tool_calls 原样返回给客户端，由客户端执行后在下一次请求中带上 tool_results
+	ToolModeServer      = "server"      // 由代理调用工具的 webhook_url 并把结果交给模型，循环直到模型给出最终回答
+)
+
+// 流式输出的备用模型策略。三种策略在输出第一个 Token 之前都会静默切换备用模型，区别在于：
+const (
+	StreamFallbackBuffer = "buffer" // 输出第一个 Token 前不向客户端发送响应头，全部失败时返回真实的错误状态码；开始输出后失败则以错误帧结束
+	StreamFallbackReset  = "reset"  // 同 buffer，但开始输出后失败会发送 {"event":"fallback"} 重置帧并切换备用模型重新输出
+	StreamFallbackFail   = "fail"   // 立即返回 200 与响应头；开始输出后失败则以错误帧结束
+)
+
+// API 路径的路由模式
+const (
+	RoutingModeFailover      = "failover"       // 始终先调用主模型，失败后使用备用模型
+	RoutingModeWeighted      = "weighted"       // 按权重随机选择池成员
+	RoutingModeRoundRobin    = "round_robin"    // 按权重轮询池成员
+	RoutingModeLeastInFlight = "least_inflight" // 选择进行中请求数（按权重折算）最少的池成员
+)
+
+// EndpointPoolMember 是负载均衡池中的一个供应商/模型组合。
+// 非 failover 模式下由池成员代替主模型，选中的成员失败后依次尝试其余成员和备用模型。
+type EndpointPoolMember struct {
+	ID            uint `gorm:"primaryKey"`
+	APIEndpointID uint `gorm:"index"`
+	ProviderID    uint
+	Provider      AIProvider `gorm:"foreignKey:ProviderID"`
+	ModelName     string
+	Weight        int `gorm:"default:1"` // 权重，越大分到的流量越多
+}
+
+// EndpointAttempt 是 API 路径的一个备用模型，主模型失败后按 Position 依次尝试
+type EndpointAttempt struct {
+	ID            uint `gorm:"primaryKey"`
+	APIEndpointID uint `gorm:"index"`
+	Position      int  // 尝试顺序，从 0 开始
+	ProviderID    uint
+	Provider      AIProvider `gorm:"foreignKey:ProviderID"`
+	ModelName     string     // 备用模型名称
+	Temperature   *float64   // 覆盖端点的温度参数，为空时沿用端点配置
+	Timeout       int        // 本次尝试的超时时间（秒），0 表示使用全局配置
+}
+
+// 虚拟 Key 状态
+const (
+	VirtualKeyActive   = "active"
+	VirtualKeyDisabled = "disabled"
+)
+
+// VirtualKey 是 OpenAI 兼容网关（/v1/chat/completions）使用的客户端 Key。
+// 请求中的 model 对应 Key 可访问的某个 API 路径（路径去掉开头的 /），调用复用该路径的路由、备用模型与统计。
+type VirtualKey struct {
+	gorm.Model
+	Name              string
+	Prefix            string        `gorm:"index;size:16;not null"`                // Key 开头的字符，用于识别与查找
+	KeyHash           string        `gorm:"uniqueIndex;size:64;not null" json:"-"` // Key 的 SHA-256（hex），与客户端 Key 相同
+	Status            string        `gorm:"size:16;default:active"`                // 见 VirtualKey* 常量
+	Endpoints         []APIEndpoint `gorm:"many2many:virtual_key_endpoints"`
+	DefaultEndpointID uint          // model 没有匹配到任何 API 路径时使用的路径，0 表示返回模型不存在
+	KeyLimits
+}
+
+// KeyLimits 是虚拟 Key 与客户端 Key 的限流与配额，按该 Key 在所有 API 路径上合计，0 表示不限制
+type KeyLimits struct {
+	// 限流：每分钟请求数与 Token 数上限
+	RateLimitRPM int64 `gorm:"default:0"`
+	RateLimitTPM int64 `gorm:"default:0"`
+	// 配额：每天/每月 Token 数与费用（美元）上限
+	DailyTokenQuota   int64   `gorm:"default:0"`
+	MonthlyTokenQuota int64   `gorm:"default:0"`
+	DailyCostQuota    float64 `gorm:"default:0"`
+	MonthlyCostQuota  float64 `gorm:"default:0"`
+}
+
+// 客户端 Key 的权限范围
+const (
+	ClientKeyScopeInvoke   = "invoke"   // 调用 API 路径
+	ClientKeyScopeSessions = "sessions" // 查询与删除会话
+	ClientKeyScopeUsage    = "usage"    // 查询配额用量
+)
+
+// ClientKeyScopes 是全部权限范围，创建时未指定 Scopes 的 Key 拥有全部权限
+var ClientKeyScopes = []string{ClientKeyScopeInvoke, ClientKeyScopeSessions, ClientKeyScopeUsage}
+
+// ClientKey 是客户端通过 X-API-Key 调用自定义 API 路径使用的 Key，一个 Key 可以访问多个路径。
+// 只保存 Key 的 SHA-256 与开头的几个字符，完整的 Key 只在签发与轮换时返回一次。
+type ClientKey struct {
+	gorm.Model
+	Name      string
+	Prefix    string        `gorm:"index;size:16;not null"`                // Key 开头的字符，用于识别与查找
+	KeyHash   string        `gorm:"uniqueIndex;size:64;not null" json:"-"` // Key 的 SHA-256（hex）
+	Scopes    []string      `gorm:"type:text;serializer:json"`             // 见 ClientKeyScope* 常量
+	Endpoints []APIEndpoint `gorm:"many2many:client_key_endpoints"`
+	ExpiresAt *time.Time    // 过期时间，为空表示不过期
+	Revoked   bool          `gorm:"default:false"`
+	RevokedAt *time.Time
+	// 轮换后替代该 Key 的新 Key，旧 Key 在宽限期结束（ExpiresAt）前仍然有效
+	ReplacedByID uint
+	// 轮换链中最初签发的 Key，限流与配额按它统计，轮换不会重置已用的额度；0 表示该 Key 就是最初的 Key
+	RootID uint
+	KeyLimits
+}
+
+// HashClientKey 返回客户端 Key 的 SHA-256（hex），虚拟 Key 也使用它
+func HashClientKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// ClientKeyPrefix 返回客户端 Key 与虚拟 Key 用于识别的前缀：开头的 11 个字符（如 ck-1a2b3c4d），
+// 较短的 Key（迁移自旧版本的路径 Key 或自定义的虚拟 Key）最多取一半
+func ClientKeyPrefix(key string) string {
+	return key[:min(11, len(key)/2)]
+}
+
+// ResponseCacheEntry 是 database 存储方式下的一条响应缓存
+type ResponseCacheEntry struct {
+	ID            uint      `gorm:"primaryKey"`
+	Key           string    `gorm:"uniqueIndex;size:64;not null"` // 请求内容的 SHA-256
+	APIEndpointID uint      `gorm:"index"`
+	Value         string    `gorm:"type:text"` // 缓存的回答（JSON）
+	ExpiresAt     time.Time `gorm:"index"`
+	CreatedAt     time.Time
+}
+
+// SemanticCacheEntry 是 database 存储方式下的一条语义缓存
+type SemanticCacheEntry struct {
+	ID            uint      `gorm:"primaryKey"`
+	APIEndpointID uint      `gorm:"index:idx_semantic_scope"`
+	Scope         string    `gorm:"index:idx_semantic_scope;size:64"` // 除用户消息外的请求内容（模型、参数、历史消息）的 SHA-256，只在相同上下文中比较相似度
+	Vector        []byte    // 用户消息的向量，float32 小端序
+	Value         string    `gorm:"type:text"` // 缓存的回答（JSON）
+	ExpiresAt     time.Time `gorm:"index"`
+	CreatedAt     time.Time
+}
+
+// RateLimitBucket 是 database 存储方式下的一个限流令牌桶
+type RateLimitBucket struct {
+	ID         uint    `gorm:"primaryKey"`
+	Key        string  `gorm:"uniqueIndex;size:128;not null"` // 计量方式与限流对象，如 requests:endpoint:1
+	Tokens     float64 // 上次补充令牌时的剩余令牌数，可以为负
+	RefilledAt int64   // 上次补充令牌的时间（Unix 毫秒），补充与扣除都在数据库中以一条 UPDATE 完成
+}
+
+// QuotaUsage 是配额对象在一个周期内的用量
+type QuotaUsage struct {
+	ID     uint   `gorm:"primaryKey"`
+	Scope  string `gorm:"uniqueIndex:idx_quota_scope_period;size:64;not null"` // 配额对象，如 endpoint:1、key:1
+	Period string `gorm:"uniqueIndex:idx_quota_scope_period;size:16;not null"` // 周期，按天为 YYYY-MM-DD，按月为 YYYY-MM
+	Tokens int64
+	Cost   float64 // 按 pricing 配置计算的费用（美元）
+	// 已发送过警告的最高阈值（百分比），同一周期内每个阈值只通知一次
+	TokensWarned int
+	CostWarned   int
+	UpdatedAt    time.Time
+}
+
+// ChatSession 是客户端通过 session_id 在某个 API 路径上进行的多轮对话，session_id 在同一路径内唯一
+type ChatSession struct {
+	ID            uint             `gorm:"primaryKey"`
+	APIEndpointID uint             `gorm:"uniqueIndex:idx_session_owner"`
+	OwnerKeyID    uint             `gorm:"uniqueIndex:idx_session_owner"` // 创建会话的客户端 Key 所在轮换链中最初的 Key，只有这条链上的 Key 能访问
+	SessionID     string           `gorm:"uniqueIndex:idx_session_owner;size:128;not null"`
+	MessageCount  int64            // 已保存的消息条数
+	Messages      []SessionMessage `gorm:"foreignKey:ChatSessionID" json:",omitempty"`
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// SessionMessage 是会话中的一条消息，按 ID 顺序回放
+type SessionMessage struct {
+	ID            uint   `gorm:"primaryKey"`
+	ChatSessionID uint   `gorm:"index"`
+	Role          string `gorm:"size:16"` // user 或 assistant
+	Content       string `gorm:"type:text"`
+	Tokens        int64  // 估算的 Token 数，用于按 Token 限制回放的历史
+	CreatedAt     time.Time
 }
 
 type APIStats struct {
-	ID              uint   `gorm:"primaryKey"`
-	APIEndpointID   uint   `gorm:"index:idx_endpoint_date"`
-	Date            string `gorm:"index:idx_endpoint_date"` // YYYY-MM-DD
-	CallCount       int64
-	InputTokens     int64
-	OutputTokens    int64
-	CacheHitTokens  int64
-	FailedCallCount int64  // 失败调用次数
-	FailedModels    string `gorm:"type:text"` // JSON格式的失败模型统计 {"model_name": count, ...}
-	LastFailedModel string // 最后失败的模型名称
-	LastUpdated     time.Time
+	ID             uint   `gorm:"primaryKey"`
+	APIEndpointID  uint   `gorm:"index:idx_endpoint_date"`
+	Date           string `gorm:"index:idx_endpoint_date"` // YYYY-MM-DD
+	CallCount      int64
+	InputTokens    int64
+	OutputTokens   int64
+	CacheHitTokens int64 // 命中响应缓存的调用原本需要消耗的 Token
+	// 命中语义缓存（相似问题）的调用次数，这些调用同样计入 CallCount 与 CacheHitTokens
+	SemanticCacheHits int64
+	// 上游返回的用量明细：输入 Token 中命中供应商提示词缓存的部分，输出 Token 中推理的部分
+	CachedPromptTokens int64
+	ReasoningTokens    int64
+	FailedCallCount    int64  // 失败调用次数
+	FailedModels       string `gorm:"type:text"` // JSON格式的失败模型统计 {"model_name": count, ...}
+	LastFailedModel    string // 最后失败的模型名称
+	PoolMemberCalls    string `gorm:"type:text"` // JSON格式的负载均衡池成员成功调用统计 {"供应商/模型": count, ...}
+	RetryCount         int64  // 同一个尝试上的重试次数
+	RetriedModels      string `gorm:"type:text"` // JSON格式的重试模型统计 {"供应商/模型": count, ...}
+	EstimatedCalls     int64  // 上游未返回用量、Token 数为本地估算的调用次数
+	// 对冲调用：发起过对冲的调用次数、各供应商/模型胜出的次数，以及落选尝试额外消耗的 Token（不计入输入与输出 Token）
+	HedgedCalls      int64
+	HedgeWinners     string `gorm:"type:text"` // JSON格式 {"供应商/模型": count, ...}
+	HedgeExtraTokens int64
+	LastUpdated      time.Time
 }
 
 func InitDB() error {
@@ -73,11 +336,247 @@ func InitDB() error {
 		return fmt.Errorf("failed to connect database: %v", err)
 	}
 
+	if err := migrateEmptyEmbeddingProviders(); err != nil {
+		return fmt.Errorf("failed to migrate embedding providers: %v", err)
+	}
+	if err := migratePlaintextVirtualKeys(); err != nil {
+		return fmt.Errorf("failed to migrate virtual keys: %v", err)
+	}
+	addPromptTemplating := DB.Migrator().HasTable(&APIEndpoint{}) && !DB.Migrator().HasColumn(&APIEndpoint{}, "prompt_templating")
+
 	// 自动迁移
-	err = DB.AutoMigrate(&User{}, &AIProvider{}, &APIEndpoint{}, &APIStats{})
+	err = DB.AutoMigrate(&User{}, &AIProvider{}, &ProviderKey{}, &APIEndpoint{}, &EndpointAttempt{}, &EndpointPoolMember{}, &VirtualKey{}, &ClientKey{}, &ChatSession{}, &SessionMessage{}, &ResponseCacheEntry{}, &SemanticCacheEntry{}, &RateLimitBucket{}, &QuotaUsage{}, &APIStats{})
 	if err != nil {
 		return fmt.Errorf("failed to migrate database: %v", err)
 	}
 
+	if err := migrateLegacyFallbacks(); err != nil {
+		return fmt.Errorf("failed to migrate fallback columns: %v", err)
+	}
+
+	if err := migrateLegacyEndpointKeys(); err != nil {
+		return fmt.Errorf("failed to migrate endpoint api keys: %v", err)
+	}
+
+	// 会话改为按 (API 路径, 客户端 Key, session_id) 唯一，删除旧的 (API 路径, session_id) 唯一索引
+	if DB.Migrator().HasIndex(&ChatSession{}, "idx_session_endpoint") {
+		if err := DB.Migrator().DropIndex(&ChatSession{}, "idx_session_endpoint"); err != nil {
+			return fmt.Errorf("failed to migrate session index: %v", err)
+		}
+	}
+
+	if addPromptTemplating {
+		if err := migratePromptTemplating(); err != nil {
+			return fmt.Errorf("failed to migrate prompt templating: %v", err)
+		}
+	}
+
 	return nil
 }
+
+// PreloadEndpoint 预加载 API 路径的供应商、按顺序排列的备用模型和负载均衡池
+func PreloadEndpoint(db *gorm.DB) *gorm.DB {
+	return db.Preload("Provider").
+		Preload("Attempts", func(db *gorm.DB) *gorm.DB {
+			return db.Order("position ASC")
+		}).
+		Preload("Attempts.Provider").
+		Preload("PoolMembers").
+		Preload("PoolMembers.Provider").
+		Preload("EmbeddingProvider")
+}
+
+// migrateEmptyEmbeddingProviders 把旧版本以 0 表示未选择的 embedding_provider_id 改为 NULL，
+// 否则 MySQL 上 AutoMigrate 无法创建指向 ai_providers 的外键
+func migrateEmptyEmbeddingProviders() error {
+	if !DB.Migrator().HasColumn(&APIEndpoint{}, "embedding_provider_id") {
+		return nil
+	}
+	return DB.Table("api_endpoints").Where("embedding_provider_id = 0").Update("embedding_provider_id", nil).Error
+}
+
+// legacyPromptFuncs 是新增 PromptTemplating 开关之前提示词模板可用的内置函数，迁移时只需要函数名与签名
+var legacyPromptFuncs = template.FuncMap{
+	"now":     time.Now,
+	"date":    func() string { return "" },
+	"path":    func() string { return "" },
+	"content": func() string { return "" },
+}
+
+// legacyVirtualKey 是旧版本 virtual_keys 表中明文保存 Key 的列，仅用于迁移
+type legacyVirtualKey struct {
+	ID      uint
+	Key     string
+	Prefix  string `gorm:"size:16"`
+	KeyHash string `gorm:"size:64"`
+}
+
+func (legacyVirtualKey) TableName() string { return "virtual_keys" }
+
+// migratePlaintextVirtualKeys 将旧版本明文保存的虚拟 Key 转换为 SHA-256 与前缀，并删除明文列。
+// 需在 AutoMigrate 之前执行：新增的 key_hash 列为 NOT NULL 且唯一，无法直接加到已有数据的表上。
+func migratePlaintextVirtualKeys() error {
+	if !DB.Migrator().HasTable(&legacyVirtualKey{}) {
+		return nil
+	}
+	// SQLite 的 HasColumn 按建表语句模糊匹配，key_hash 等列也会被当作 key，这里按列名精确判断
+	columnTypes, err := DB.Migrator().ColumnTypes(&legacyVirtualKey{})
+	if err != nil {
+		return err
+	}
+	columns := make(map[string]bool, len(columnTypes))
+	for _, column := range columnTypes {
+		columns[column.Name()] = true
+	}
+	if !columns["key"] {
+		return nil
+	}
+
+	return DB.Transaction(func(tx *gorm.DB) error {
+		for _, column := range []string{"prefix", "key_hash"} {
+			if !columns[column] {
+				if err := tx.Migrator().AddColumn(&legacyVirtualKey{}, column); err != nil {
+					return err
+				}
+			}
+		}
+
+		var rows []legacyVirtualKey
+		if err := tx.Select("id, `key`").Find(&rows).Error; err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if err := tx.Model(&legacyVirtualKey{ID: row.ID}).Updates(map[string]interface{}{
+				"prefix":   ClientKeyPrefix(row.Key),
+				"key_hash": HashClientKey(row.Key),
+			}).Error; err != nil {
+				return err
+			}
+		}
+
+		if tx.Migrator().HasIndex(&legacyVirtualKey{}, "idx_virtual_keys_key") {
+			if err := tx.Migrator().DropIndex(&legacyVirtualKey{}, "idx_virtual_keys_key"); err != nil {
+				return err
+			}
+		}
+		return tx.Migrator().DropColumn(&legacyVirtualKey{}, "key")
+	})
+}
+
+// migratePromptTemplating 在新增 prompt_templating 列后为已经在使用模板的 API 路径开启模板：
+// 配置了用户消息模板，或系统提示词中的 {{ 能按模板解析。更早版本中原样包含 {{ 的系统提示词保持原样发送。
+func migratePromptTemplating() error {
+	var endpoints []APIEndpoint
+	if err := DB.Select("id", "system_prompt", "user_template").Find(&endpoints).Error; err != nil {
+		return err
+	}
+
+	var ids []uint
+	for _, endpoint := range endpoints {
+		if endpoint.UserTemplate != "" {
+			ids = append(ids, endpoint.ID)
+			continue
+		}
+		if !strings.Contains(endpoint.SystemPrompt, "{{") {
+			continue
+		}
+		if _, err := template.New("prompt").Funcs(legacyPromptFuncs).Parse(endpoint.SystemPrompt); err == nil {
+			ids = append(ids, endpoint.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	return DB.Model(&APIEndpoint{}).Where("id IN ?", ids).Update("prompt_templating", true).Error
+}
+
+// legacyFallbackColumns 是旧版本 api_endpoints 表中固定的两组备用模型列
+var legacyFallbackColumns = []string{"fallback_provider_id1", "fallback_model1", "fallback_provider_id2", "fallback_model2"}
+
+// migrateLegacyFallbacks 将旧版本的备用模型列转换为 EndpointAttempt 记录，并删除旧列
+func migrateLegacyFallbacks() error {
+	if !DB.Migrator().HasColumn(&APIEndpoint{}, legacyFallbackColumns[0]) {
+		return nil
+	}
+
+	var rows []struct {
+		ID                  uint
+		FallbackProviderID1 uint
+		FallbackModel1      string
+		FallbackProviderID2 uint
+		FallbackModel2      string
+	}
+	if err := DB.Table("api_endpoints").Select("id, " + strings.Join(legacyFallbackColumns, ", ")).Scan(&rows).Error; err != nil {
+		return err
+	}
+
+	return DB.Transaction(func(tx *gorm.DB) error {
+		for _, row := range rows {
+			var attempts []EndpointAttempt
+			if row.FallbackProviderID1 > 0 && strings.TrimSpace(row.FallbackModel1) != "" {
+				attempts = append(attempts, EndpointAttempt{ProviderID: row.FallbackProviderID1, ModelName: strings.TrimSpace(row.FallbackModel1)})
+			}
+			if row.FallbackProviderID2 > 0 && strings.TrimSpace(row.FallbackModel2) != "" {
+				attempts = append(attempts, EndpointAttempt{ProviderID: row.FallbackProviderID2, ModelName: strings.TrimSpace(row.FallbackModel2)})
+			}
+			for i := range attempts {
+				attempts[i].APIEndpointID = row.ID
+				attempts[i].Position = i
+				if err := tx.Omit("Provider").Create(&attempts[i]).Error; err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, column := range legacyFallbackColumns {
+			if err := tx.Migrator().DropColumn(&APIEndpoint{}, column); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// migrateLegacyEndpointKeys 将旧版本 api_endpoints 表中明文保存的 api_key 转换为只能访问该路径的客户端 Key，并删除旧列。
+// 多个路径使用同一个 Key 时合并为一个可以访问这些路径的客户端 Key，客户端无需修改即可继续调用。
+func migrateLegacyEndpointKeys() error {
+	if !DB.Migrator().HasColumn(&APIEndpoint{}, "api_key") {
+		return nil
+	}
+
+	var rows []struct {
+		ID     uint
+		Path   string
+		ApiKey string
+	}
+	if err := DB.Table("api_endpoints").Select("id, path, api_key").Where("deleted_at IS NULL").Scan(&rows).Error; err != nil {
+		return err
+	}
+
+	return DB.Transaction(func(tx *gorm.DB) error {
+		keys := make(map[string]*ClientKey)
+		for _, row := range rows {
+			if row.ApiKey == "" {
+				continue
+			}
+			key, ok := keys[row.ApiKey]
+			if !ok {
+				key = &ClientKey{
+					Name:    row.Path,
+					Prefix:  ClientKeyPrefix(row.ApiKey),
+					KeyHash: HashClientKey(row.ApiKey),
+					Scopes:  ClientKeyScopes,
+				}
+				if err := tx.Create(key).Error; err != nil {
+					return err
+				}
+				keys[row.ApiKey] = key
+			}
+			if err := tx.Model(key).Omit("Endpoints.*").Association("Endpoints").Append(&APIEndpoint{Model: gorm.Model{ID: row.ID}}); err != nil {
+				return err
+			}
+		}
+
+		return tx.Migrator().DropColumn(&APIEndpoint{}, "api_key")
+	})
+}
@@ -0,0 +1,349 @@
+package providers
+
+import (
+	"ai-api-platform/backend/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	anthropicVersion        = "2023-06-01"
+	anthropicMaxTokens      = 8192 // Messages API 要求必须指定 max_tokens
+	anthropicThinkingBudget = 4096 // 开启思考模式时的思考 Token 预算，需小于 max_tokens
+)
+
+func init() {
+	Register(models.ProviderTypeAnthropic, newAnthropicProvider)
+	RegisterRequestCheck(models.ProviderTypeAnthropic, requireBasic)
+}
+
+// anthropicProvider 直接调用 Anthropic Messages API 的供应商
+type anthropicProvider struct {
+	baseURL    string
+	apiKey     string
+	httpClient *http.Client
+}
+
+func newAnthropicProvider(provider *models.AIProvider, httpClient *http.Client) Provider {
+	return &anthropicProvider{
+		baseURL:    provider.APIAddress,
+		apiKey:     provider.APIKey,
+		httpClient: httpClient,
+	}
+}
+
+type anthropicMessage struct {
+	Role    string      `json:"role"`
+	Content interface{} `json:"content"` // 纯文本消息为字符串，带图片的消息为 []anthropicContentBlock
+}
+
+type anthropicImageSource struct {
+	Type      string `json:"type"` // base64 或 url
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"`
+}
+
+type anthropicContentBlock struct {
+	Type   string                `json:"type"`
+	Text   string                `json:"text,omitempty"`
+	Source *anthropicImageSource `json:"source,omitempty"`
+}
+
+// anthropicContent 返回消息的 content，带图片时转换为图片块在前、文本块在后的内容块数组
+func anthropicContent(m Message) interface{} {
+	if len(m.Images) == 0 {
+		return m.Content
+	}
+	blocks := make([]anthropicContentBlock, 0, len(m.Images)+1)
+	for _, image := range m.Images {
+		source := &anthropicImageSource{Type: "base64", MediaType: image.MediaType, Data: image.Data}
+		if image.URL != "" {
+			source = &anthropicImageSource{Type: "url", URL: image.URL}
+		}
+		blocks = append(blocks, anthropicContentBlock{Type: "image", Source: source})
+	}
+	if m.Content != "" {
+		blocks = append(blocks, anthropicContentBlock{Type: "text", Text: m.Content})
+	}
+	return blocks
+}
+
+type anthropicThinking struct {
+	Type         string `json:"type"`
+	BudgetTokens int    `json:"budget_tokens"`
+}
+
+type anthropicRequest struct {
+	Model         string             `json:"model"`
+	MaxTokens     int64              `json:"max_tokens"`
+	System        string             `json:"system,omitempty"`
+	Messages      []anthropicMessage `json:"messages"`
+	Temperature   *float64           `json:"temperature,omitempty"`
+	TopP          *float64           `json:"top_p,omitempty"`
+	StopSequences []string           `json:"stop_sequences,omitempty"`
+	Thinking      *anthropicThinking `json:"thinking,omitempty"`
+	Stream        bool               `json:"stream,omitempty"`
+}
+
+type anthropicUsage struct {
+	InputTokens              int64 `json:"input_tokens"`
+	OutputTokens             int64 `json:"output_tokens"`
+	CacheReadInputTokens     int64 `json:"cache_read_input_tokens"`
+	CacheCreationInputTokens int64 `json:"cache_creation_input_tokens"`
+}
+
+// usage 转换为统一用量。Anthropic 的 input_tokens 不含读取与写入缓存的 Token，
+// 这里加回去，使 PromptTokens 与其他供应商一样表示全部输入 Token
+func (u anthropicUsage) usage() Usage {
+	return Usage{
+		PromptTokens:     u.InputTokens + u.CacheReadInputTokens + u.CacheCreationInputTokens,
+		CompletionTokens: u.OutputTokens,
+		CachedTokens:     u.CacheReadInputTokens,
+	}
+}
+
+type anthropicResponse struct {
+	ID      string `json:"id"`
+	Role    string `json:"role"`
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+	StopReason string         `json:"stop_reason"`
+	Usage      anthropicUsage `json:"usage"`
+}
+
+// anthropicFinishReason 将 stop_reason 转换为 OpenAI 的 finish_reason
+func anthropicFinishReason(stopReason string) string {
+	switch stopReason {
+	case "":
+		return ""
+	case "max_tokens":
+		return "length"
+	case "tool_use":
+		return "tool_calls"
+	}
+	return "stop"
+}
+
+// buildRequest 将统一请求转换为 Messages API 请求，system 消息提升为顶层 system 字段
+func (p *anthropicProvider) buildRequest(req *ChatRequest, stream bool) *anthropicRequest {
+	var systemParts []string
+	messages := make([]anthropicMessage, 0, len(req.Messages))
+	for _, m := range req.Messages {
+		if m.Role == "system" {
+			if m.Content != "" {
+				systemParts = append(systemParts, m.Content)
+			}
+			continue
+		}
+		role := m.Role
+		if role != "assistant" {
+			role = "user"
+		}
+		messages = append(messages, anthropicMessage{Role: role, Content: anthropicContent(m)})
+	}
+
+	body := &anthropicRequest{
+		Model:         req.Model,
+		MaxTokens:     anthropicMaxTokens,
+		System:        strings.Join(systemParts, "\n\n"),
+		Messages:      messages,
+		StopSequences: req.Stop,
+		Stream:        stream,
+	}
+	if req.MaxTokens > 0 {
+		body.MaxTokens = req.MaxTokens
+	}
+	if req.EnableThinking {
+		// 开启思考模式时 Anthropic 不允许自定义温度，且 max_tokens 需包含思考预算
+		body.Thinking = &anthropicThinking{Type: "enabled", BudgetTokens: anthropicThinkingBudget}
+		if req.MaxTokens > 0 {
+			body.MaxTokens = req.MaxTokens + anthropicThinkingBudget
+		}
+	} else {
+		temperature := req.Temperature
+		body.Temperature = &temperature
+		body.TopP = req.TopP
+	}
+	return body
+}
+
+func (p *anthropicProvider) headers() map[string]string {
+	return map[string]string{
+		"x-api-key":         p.apiKey,
+		"anthropic-version": anthropicVersion,
+	}
+}
+
+func (p *anthropicProvider) Complete(ctx context.Context, req *ChatRequest) (*ChatResponse, error) {
+	if err := requireBasic(req); err != nil {
+		return nil, err
+	}
+	resp, err := doJSON(ctx, p.httpClient, http.MethodPost, joinURL(p.baseURL, "/v1/messages"), p.headers(), p.buildRequest(req, false))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result anthropicResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decode anthropic response: %v", err)
+	}
+
+	if len(result.Content) == 0 {
+		return nil, ErrEmptyResponse
+	}
+	var text strings.Builder
+	for _, block := range result.Content {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+		}
+	}
+
+	return &ChatResponse{
+		ID:      result.ID,
+		Role:    "assistant",
+		Content: text.String(),
+		Choices: textChoice(text.String(), anthropicFinishReason(result.StopReason)),
+		Usage:   result.Usage.usage(),
+	}, nil
+}
+
+func (p *anthropicProvider) Stream(ctx context.Context, req *ChatRequest) (ChatStream, error) {
+	if err := requireBasic(req); err != nil {
+		return nil, err
+	}
+	resp, err := doJSON(ctx, p.httpClient, http.MethodPost, joinURL(p.baseURL, "/v1/messages"), p.headers(), p.buildRequest(req, true))
+	if err != nil {
+		return nil, err
+	}
+	return &anthropicStream{body: resp.Body, reader: newSSEReader(resp.Body)}, nil
+}
+
+func (p *anthropicProvider) ListModels(ctx context.Context) ([]string, error) {
+	resp, err := doJSON(ctx, p.httpClient, http.MethodGet, joinURL(p.baseURL, "/v1/models?limit=1000"), p.headers(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(result.Data))
+	for _, m := range result.Data {
+		names = append(names, m.ID)
+	}
+	return names, nil
+}
+
+// anthropicStreamEvent 覆盖了流式输出中用到的所有事件字段
+type anthropicStreamEvent struct {
+	Type    string `json:"type"`
+	Message struct {
+		ID    string         `json:"id"`
+		Usage anthropicUsage `json:"usage"`
+	} `json:"message"`
+	Delta struct {
+		Type       string `json:"type"`
+		Text       string `json:"text"`
+		StopReason string `json:"stop_reason"`
+	} `json:"delta"`
+	Usage *anthropicUsage `json:"usage"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// anthropicStream 将 Anthropic 的 SSE 事件转换为 StreamChunk
+type anthropicStream struct {
+	body       io.ReadCloser
+	reader     *sseReader
+	current    StreamChunk
+	inputUsage anthropicUsage // message_start 中的输入用量
+	stopped    bool
+	err        error
+}
+
+func (s *anthropicStream) Next() bool {
+	for s.err == nil {
+		event, err := s.reader.Next()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				s.err = err
+			} else if !s.stopped {
+				s.err = io.ErrUnexpectedEOF
+			}
+			return false
+		}
+
+		var data anthropicStreamEvent
+		if err := json.Unmarshal(event.Data, &data); err != nil {
+			s.err = fmt.Errorf("decode anthropic stream event: %v", err)
+			return false
+		}
+
+		switch data.Type {
+		case "message_start":
+			s.inputUsage = data.Message.Usage
+		case "content_block_delta":
+			if data.Delta.Type == "text_delta" {
+				s.current = StreamChunk{Content: data.Delta.Text}
+				return true
+			}
+		case "message_delta":
+			if data.Usage != nil {
+				// 新版本 API 会在 message_delta 中带上完整的输入 Token
+				usage := *data.Usage
+				if usage.InputTokens == 0 {
+					usage.InputTokens = s.inputUsage.InputTokens
+					usage.CacheReadInputTokens = s.inputUsage.CacheReadInputTokens
+					usage.CacheCreationInputTokens = s.inputUsage.CacheCreationInputTokens
+				}
+				total := usage.usage()
+				s.current = StreamChunk{
+					FinishReason: anthropicFinishReason(data.Delta.StopReason),
+					Usage:        &total,
+				}
+				return true
+			}
+		case "message_stop":
+			s.stopped = true
+			return false
+		case "error":
+			message := string(event.Data)
+			if data.Error != nil {
+				message = data.Error.Type + ": " + data.Error.Message
+			}
+			s.err = errors.New("anthropic stream error: " + message)
+			return false
+		}
+	}
+	return false
+}
+
+func (s *anthropicStream) Current() StreamChunk {
+	return s.current
+}
+
+func (s *anthropicStream) Err() error {
+	return s.err
+}
+
+func (s *anthropicStream) Close() error {
+	return s.body.Close()
+}
@@ -0,0 +1,66 @@
+package providers
+
+import (
+	"ai-api-platform/backend/models"
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+// azureDefaultAPIVersion 供应商未配置 APIVersion 时使用的 Azure OpenAI API 版本
+const azureDefaultAPIVersion = "2024-10-21"
+
+func init() {
+	Register(models.ProviderTypeAzure, newAzureProvider)
+}
+
+// azureProvider 调用 Azure OpenAI 的供应商。
+// 请求地址为 {APIAddress}/openai/deployments/{deployment}/chat/completions?api-version=...，
+// 其中 deployment 即端点选择的模型名称，鉴权使用 api-key 请求头。
+type azureProvider struct {
+	*openAIProvider
+	deployments []string
+}
+
+func newAzureProvider(provider *models.AIProvider, httpClient *http.Client) Provider {
+	apiVersion := strings.TrimSpace(provider.APIVersion)
+	if apiVersion == "" {
+		apiVersion = azureDefaultAPIVersion
+	}
+	baseURL := strings.TrimRight(provider.APIAddress, "/")
+
+	var deployments []string
+	for _, d := range strings.Split(provider.ModelName, ",") {
+		if d = strings.TrimSpace(d); d != "" {
+			deployments = append(deployments, d)
+		}
+	}
+
+	return &azureProvider{
+		openAIProvider: &openAIProvider{
+			client: openai.NewClient(
+				option.WithBaseURL(baseURL+"/openai/"),
+				option.WithHeaderDel("authorization"),
+				option.WithHeader("api-key", provider.APIKey),
+				option.WithQuery("api-version", apiVersion),
+				option.WithHTTPClient(httpClient),
+				option.WithMaxRetries(0),
+			),
+			modelOptions: func(deployment string) []option.RequestOption {
+				return []option.RequestOption{
+					option.WithBaseURL(baseURL + "/openai/deployments/" + url.PathEscape(deployment) + "/"),
+				}
+			},
+		},
+		deployments: deployments,
+	}
+}
+
+// ListModels 返回供应商配置的部署名称，Azure 数据面接口不提供部署列表
+func (p *azureProvider) ListModels(ctx context.Context) ([]string, error) {
+	return p.deployments, nil
+}
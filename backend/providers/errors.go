@@ -0,0 +1,123 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/openai/openai-go"
+)
+
+// maxErrorBodySize 读取上游错误响应体的上限
+const maxErrorBodySize = 4096
+
+// APIError 表示上游供应商返回的 HTTP 错误
+type APIError struct {
+	StatusCode int
+	Message    string
+	RetryAfter time.Duration // 上游 Retry-After 响应头，未返回时为 0
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("provider returned status %d: %s", e.StatusCode, e.Message)
+}
+
+// newAPIError 读取错误响应并构造 APIError
+func newAPIError(resp *http.Response) *APIError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+	return &APIError{
+		StatusCode: resp.StatusCode,
+		Message:    strings.TrimSpace(string(body)),
+		RetryAfter: parseRetryAfter(resp.Header),
+	}
+}
+
+// StatusCode 返回错误对应的上游 HTTP 状态码，不是 HTTP 错误时返回 0
+func StatusCode(err error) int {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+	var openaiErr *openai.Error
+	if errors.As(err, &openaiErr) {
+		return openaiErr.StatusCode
+	}
+	return 0
+}
+
+// RetryAfter 返回上游通过 Retry-After 要求的等待时间，未要求时返回 0
+func RetryAfter(err error) time.Duration {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.RetryAfter
+	}
+	var openaiErr *openai.Error
+	if errors.As(err, &openaiErr) && openaiErr.Response != nil {
+		return parseRetryAfter(openaiErr.Response.Header)
+	}
+	return 0
+}
+
+// ErrorClass 是上游错误的处理方式
+type ErrorClass int
+
+const (
+	// ErrorFallback 不在同一个尝试上重试，直接切换到下一个尝试，如 401/403/404、超时和未知错误
+	ErrorFallback ErrorClass = iota
+	// ErrorRetryable 暂时性错误，可以在同一个尝试上退避重试，如 429、5xx 和连接错误
+	ErrorRetryable
+	// ErrorFatal 请求本身有误（4xx 校验错误），换供应商也不会成功，应直接返回给客户端
+	ErrorFatal
+)
+
+// Classify 判断上游错误应重试、切换尝试还是直接失败
+func Classify(err error) ErrorClass {
+	switch status := StatusCode(err); {
+	case status == http.StatusTooManyRequests || status == http.StatusRequestTimeout || status >= 500:
+		return ErrorRetryable
+	case status == http.StatusUnauthorized || status == http.StatusForbidden || status == http.StatusNotFound:
+		// Key 或模型的问题，换一个 Key 或供应商可能成功
+		return ErrorFallback
+	case status >= 400:
+		return ErrorFatal
+	}
+
+	// 超时的尝试再等一轮通常也会超时，直接切换到下一个尝试
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return ErrorFallback
+	}
+	var netErr net.Error
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &netErr) {
+		if netErr != nil && netErr.Timeout() {
+			return ErrorFallback
+		}
+		return ErrorRetryable
+	}
+	return ErrorFallback
+}
+
+// parseRetryAfter 解析 Retry-After 响应头，支持秒数和 HTTP 日期两种格式
+func parseRetryAfter(header http.Header) time.Duration {
+	value := strings.TrimSpace(header.Get("Retry-After"))
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		if seconds <= 0 {
+			return 0
+		}
+		return time.Duration(seconds * float64(time.Second))
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if d := time.Until(at); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
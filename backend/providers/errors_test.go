@@ -0,0 +1,83 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// timeoutError 是超时的网络错误
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want ErrorClass
+	}{
+		{"429", &APIError{StatusCode: http.StatusTooManyRequests}, ErrorRetryable},
+		{"408", &APIError{StatusCode: http.StatusRequestTimeout}, ErrorRetryable},
+		{"500", &APIError{StatusCode: http.StatusInternalServerError}, ErrorRetryable},
+		{"503 wrapped", fmt.Errorf("attempt 1: %w", &APIError{StatusCode: http.StatusServiceUnavailable}), ErrorRetryable},
+		{"401", &APIError{StatusCode: http.StatusUnauthorized}, ErrorFallback},
+		{"403", &APIError{StatusCode: http.StatusForbidden}, ErrorFallback},
+		{"404", &APIError{StatusCode: http.StatusNotFound}, ErrorFallback},
+		{"400", &APIError{StatusCode: http.StatusBadRequest}, ErrorFatal},
+		{"422", &APIError{StatusCode: http.StatusUnprocessableEntity}, ErrorFatal},
+		{"deadline exceeded", context.DeadlineExceeded, ErrorFallback},
+		{"canceled", fmt.Errorf("request: %w", context.Canceled), ErrorFallback},
+		{"unexpected EOF", io.ErrUnexpectedEOF, ErrorRetryable},
+		{"connection refused", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, ErrorRetryable},
+		{"network timeout", &net.OpError{Op: "read", Net: "tcp", Err: timeoutError{}}, ErrorFallback},
+		{"unknown", errors.New("something went wrong"), ErrorFallback},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Classify(tt.err); got != tt.want {
+				t.Errorf("Classify(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  time.Duration
+	}{
+		{"missing", "", 0},
+		{"seconds", "3", 3 * time.Second},
+		{"fractional seconds", "1.5", 1500 * time.Millisecond},
+		{"zero", "0", 0},
+		{"negative", "-5", 0},
+		{"date in the past", "Wed, 21 Oct 2015 07:28:00 GMT", 0},
+		{"invalid", "soon", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.value != "" {
+				header.Set("Retry-After", tt.value)
+			}
+			if got := parseRetryAfter(header); got != tt.want {
+				t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+
+	header := http.Header{}
+	header.Set("Retry-After", time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	if got := parseRetryAfter(header); got <= 58*time.Second || got > time.Minute {
+		t.Errorf("parseRetryAfter(date in a minute) = %v, want about 1m", got)
+	}
+}
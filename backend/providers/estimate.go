@@ -0,0 +1,45 @@
+package providers
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// 每条消息在 chat 格式中的额外开销（角色、分隔符等），以及回复的起始标记，数值参考 OpenAI 的计算方式
+const (
+	tokensPerMessage = 4
+	tokensPerReply   = 3
+	// 无法得知图片尺寸，每张图片按 OpenAI 高精度模式下 1024x1024 图片的 Token 数估算
+	tokensPerImage = 765
+)
+
+// EstimateTokens 在上游没有返回用量时粗略估算文本的 Token 数：
+// 中日韩文字按每个字符 1 个 Token，其余文本按每 4 个字节 1 个 Token 计算。
+func EstimateTokens(text string) int64 {
+	var tokens, otherBytes int64
+	for _, r := range text {
+		if isCJK(r) {
+			tokens++
+			continue
+		}
+		otherBytes += int64(utf8.RuneLen(r))
+	}
+	return tokens + (otherBytes+3)/4
+}
+
+// EstimateUsage 根据请求消息与回复内容估算用量，返回的 Usage 标记为 Estimated
+func EstimateUsage(req *ChatRequest, completion string) Usage {
+	usage := Usage{Estimated: true}
+	if req != nil {
+		for _, m := range req.Messages {
+			usage.PromptTokens += tokensPerMessage + EstimateTokens(m.Content) + int64(len(m.Images))*tokensPerImage
+		}
+		usage.PromptTokens += tokensPerReply
+	}
+	usage.CompletionTokens = EstimateTokens(completion)
+	return usage
+}
+
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
@@ -0,0 +1,336 @@
+package providers
+
+import (
+	"ai-api-platform/backend/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+func init() {
+	Register(models.ProviderTypeGemini, newGeminiProvider)
+	RegisterRequestCheck(models.ProviderTypeGemini, checkGeminiRequest)
+}
+
+// geminiProvider 调用 Google Gemini generateContent 接口的供应商
+type geminiProvider struct {
+	baseURL    string
+	apiKey     string
+	httpClient *http.Client
+}
+
+func newGeminiProvider(provider *models.AIProvider, httpClient *http.Client) Provider {
+	baseURL := strings.TrimRight(provider.APIAddress, "/")
+	if !strings.HasSuffix(baseURL, "/v1beta") && !strings.HasSuffix(baseURL, "/v1") {
+		baseURL += "/v1beta"
+	}
+	return &geminiProvider{
+		baseURL:    baseURL,
+		apiKey:     provider.APIKey,
+		httpClient: httpClient,
+	}
+}
+
+type geminiPart struct {
+	Text       string            `json:"text,omitempty"`
+	Thought    bool              `json:"thought,omitempty"`
+	InlineData *geminiInlineData `json:"inlineData,omitempty"`
+}
+
+type geminiInlineData struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"`
+}
+
+// geminiUserParts 返回用户消息的 parts，图片以 inlineData 附在文本之后
+func geminiUserParts(m Message) []geminiPart {
+	parts := make([]geminiPart, 0, len(m.Images)+1)
+	if m.Content != "" || len(m.Images) == 0 {
+		parts = append(parts, geminiPart{Text: m.Content})
+	}
+	for _, image := range m.Images {
+		parts = append(parts, geminiPart{InlineData: &geminiInlineData{MimeType: image.MediaType, Data: image.Data}})
+	}
+	return parts
+}
+
+// requireInlineImages 检查图片是否都以 base64 提供，generateContent 无法直接读取任意 http(s) 图片地址
+func requireInlineImages(req *ChatRequest) error {
+	for _, m := range req.Messages {
+		for _, image := range m.Images {
+			if image.URL != "" {
+				return fmt.Errorf("%w: image urls", ErrUnsupportedFeature)
+			}
+		}
+	}
+	return nil
+}
+
+// checkGeminiRequest 检查请求是否只用到了纯文本对话与 base64 图片
+func checkGeminiRequest(req *ChatRequest) error {
+	if err := requireBasic(req); err != nil {
+		return err
+	}
+	return requireInlineImages(req)
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiGenerationConfig struct {
+	Temperature     *float64 `json:"temperature,omitempty"`
+	TopP            *float64 `json:"topP,omitempty"`
+	MaxOutputTokens int64    `json:"maxOutputTokens,omitempty"`
+	StopSequences   []string `json:"stopSequences,omitempty"`
+}
+
+type geminiRequest struct {
+	SystemInstruction *geminiContent         `json:"systemInstruction,omitempty"`
+	Contents          []geminiContent        `json:"contents"`
+	GenerationConfig  geminiGenerationConfig `json:"generationConfig"`
+}
+
+type geminiUsageMetadata struct {
+	PromptTokenCount        int64 `json:"promptTokenCount"`
+	CandidatesTokenCount    int64 `json:"candidatesTokenCount"`
+	ThoughtsTokenCount      int64 `json:"thoughtsTokenCount"`
+	CachedContentTokenCount int64 `json:"cachedContentTokenCount"`
+}
+
+type geminiResponse struct {
+	ResponseID string `json:"responseId"`
+	Candidates []struct {
+		Content      geminiContent `json:"content"`
+		FinishReason string        `json:"finishReason"`
+	} `json:"candidates"`
+	UsageMetadata *geminiUsageMetadata `json:"usageMetadata"`
+}
+
+// text 返回首个候选结果中的非思考文本
+func (r *geminiResponse) text() string {
+	if len(r.Candidates) == 0 {
+		return ""
+	}
+	var text strings.Builder
+	for _, part := range r.Candidates[0].Content.Parts {
+		if !part.Thought {
+			text.WriteString(part.Text)
+		}
+	}
+	return text.String()
+}
+
+// finishReason 将首个候选结果的 finishReason 转换为 OpenAI 的 finish_reason
+func (r *geminiResponse) finishReason() string {
+	if len(r.Candidates) == 0 {
+		return ""
+	}
+	switch r.Candidates[0].FinishReason {
+	case "", "FINISH_REASON_UNSPECIFIED":
+		return ""
+	case "STOP":
+		return "stop"
+	case "MAX_TOKENS":
+		return "length"
+	}
+	return "content_filter"
+}
+
+// usage 将 usageMetadata 转换为统一用量，思考 Token 计入输出
+func (m *geminiUsageMetadata) usage() Usage {
+	return Usage{
+		PromptTokens:     m.PromptTokenCount,
+		CompletionTokens: m.CandidatesTokenCount + m.ThoughtsTokenCount,
+		CachedTokens:     m.CachedContentTokenCount,
+		ReasoningTokens:  m.ThoughtsTokenCount,
+	}
+}
+
+// buildRequest 将统一请求转换为 generateContent 请求，system 消息映射为 systemInstruction
+func (p *geminiProvider) buildRequest(req *ChatRequest) *geminiRequest {
+	body := &geminiRequest{}
+	temperature := req.Temperature
+	body.GenerationConfig.Temperature = &temperature
+	body.GenerationConfig.TopP = req.TopP
+	body.GenerationConfig.MaxOutputTokens = req.MaxTokens
+	body.GenerationConfig.StopSequences = req.Stop
+
+	var systemParts []geminiPart
+	for _, m := range req.Messages {
+		switch m.Role {
+		case "system":
+			if m.Content != "" {
+				systemParts = append(systemParts, geminiPart{Text: m.Content})
+			}
+		case "assistant":
+			body.Contents = append(body.Contents, geminiContent{Role: "model", Parts: []geminiPart{{Text: m.Content}}})
+		default:
+			body.Contents = append(body.Contents, geminiContent{Role: "user", Parts: geminiUserParts(m)})
+		}
+	}
+	if len(systemParts) > 0 {
+		body.SystemInstruction = &geminiContent{Parts: systemParts}
+	}
+	return body
+}
+
+func (p *geminiProvider) headers() map[string]string {
+	return map[string]string{"x-goog-api-key": p.apiKey}
+}
+
+func (p *geminiProvider) modelURL(model, method string) string {
+	return fmt.Sprintf("%s/models/%s:%s", p.baseURL, url.PathEscape(model), method)
+}
+
+func (p *geminiProvider) Complete(ctx context.Context, req *ChatRequest) (*ChatResponse, error) {
+	if err := checkGeminiRequest(req); err != nil {
+		return nil, err
+	}
+	resp, err := doJSON(ctx, p.httpClient, http.MethodPost, p.modelURL(req.Model, "generateContent"), p.headers(), p.buildRequest(req))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result geminiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decode gemini response: %v", err)
+	}
+	if len(result.Candidates) == 0 {
+		return nil, ErrEmptyResponse
+	}
+
+	response := &ChatResponse{
+		ID:      result.ResponseID,
+		Role:    "assistant",
+		Content: result.text(),
+		Choices: textChoice(result.text(), result.finishReason()),
+	}
+	if result.UsageMetadata != nil {
+		response.Usage = result.UsageMetadata.usage()
+	}
+	return response, nil
+}
+
+func (p *geminiProvider) Stream(ctx context.Context, req *ChatRequest) (ChatStream, error) {
+	if err := checkGeminiRequest(req); err != nil {
+		return nil, err
+	}
+	resp, err := doJSON(ctx, p.httpClient, http.MethodPost, p.modelURL(req.Model, "streamGenerateContent")+"?alt=sse", p.headers(), p.buildRequest(req))
+	if err != nil {
+		return nil, err
+	}
+	return &geminiStream{body: resp.Body, reader: newSSEReader(resp.Body)}, nil
+}
+
+func (p *geminiProvider) Embed(ctx context.Context, model string, input []string) ([][]float64, error) {
+	type embedRequest struct {
+		Model   string        `json:"model"`
+		Content geminiContent `json:"content"`
+	}
+	requests := make([]embedRequest, 0, len(input))
+	for _, text := range input {
+		requests = append(requests, embedRequest{Model: "models/" + model, Content: geminiContent{Parts: []geminiPart{{Text: text}}}})
+	}
+	resp, err := doJSON(ctx, p.httpClient, http.MethodPost, p.modelURL(model, "batchEmbedContents"), p.headers(), map[string]interface{}{"requests": requests})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Embeddings []struct {
+			Values []float64 `json:"values"`
+		} `json:"embeddings"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decode gemini embeddings: %v", err)
+	}
+	if len(result.Embeddings) != len(input) {
+		return nil, ErrEmptyResponse
+	}
+
+	vectors := make([][]float64, 0, len(input))
+	for _, embedding := range result.Embeddings {
+		vectors = append(vectors, embedding.Values)
+	}
+	return vectors, nil
+}
+
+func (p *geminiProvider) ListModels(ctx context.Context) ([]string, error) {
+	resp, err := doJSON(ctx, p.httpClient, http.MethodGet, p.baseURL+"/models?pageSize=1000", p.headers(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(result.Models))
+	for _, m := range result.Models {
+		names = append(names, strings.TrimPrefix(m.Name, "models/"))
+	}
+	return names, nil
+}
+
+// geminiStream 将 streamGenerateContent 的 SSE 事件转换为 StreamChunk
+type geminiStream struct {
+	body    io.ReadCloser
+	reader  *sseReader
+	current StreamChunk
+	err     error
+}
+
+func (s *geminiStream) Next() bool {
+	if s.err != nil {
+		return false
+	}
+
+	event, err := s.reader.Next()
+	if err != nil {
+		if !errors.Is(err, io.EOF) {
+			s.err = err
+		}
+		return false
+	}
+
+	var data geminiResponse
+	if err := json.Unmarshal(event.Data, &data); err != nil {
+		s.err = fmt.Errorf("decode gemini stream event: %v", err)
+		return false
+	}
+
+	// usageMetadata 在每个事件中都是累计值，保留最后一次即可
+	s.current = StreamChunk{Content: data.text(), FinishReason: data.finishReason()}
+	if data.UsageMetadata != nil {
+		usage := data.UsageMetadata.usage()
+		s.current.Usage = &usage
+	}
+	return true
+}
+
+func (s *geminiStream) Current() StreamChunk {
+	return s.current
+}
+
+func (s *geminiStream) Err() error {
+	return s.err
+}
+
+func (s *geminiStream) Close() error {
+	return s.body.Close()
+}
@@ -0,0 +1,105 @@
+package providers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// joinURL 拼接 API 地址与路径，避免重复的 /v1 前缀
+func joinURL(base, path string) string {
+	base = strings.TrimRight(base, "/")
+	if strings.HasSuffix(base, "/v1") && strings.HasPrefix(path, "/v1/") {
+		path = strings.TrimPrefix(path, "/v1")
+	}
+	return base + path
+}
+
+// doJSON 发送 JSON 请求，状态码不是 2xx 时返回 APIError
+func doJSON(ctx context.Context, client *http.Client, method, url string, headers map[string]string, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range headers {
+		httpReq.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, newAPIError(resp)
+	}
+	return resp, nil
+}
+
+// sseEvent 是一个 Server-Sent Events 事件
+type sseEvent struct {
+	Event string
+	Data  []byte
+}
+
+// sseReader 按事件读取 SSE 响应体
+type sseReader struct {
+	scanner *bufio.Scanner
+}
+
+func newSSEReader(r io.Reader) *sseReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	return &sseReader{scanner: scanner}
+}
+
+// Next 读取下一个事件，读完时返回 io.EOF
+func (r *sseReader) Next() (*sseEvent, error) {
+	event := &sseEvent{}
+	var data [][]byte
+
+	for r.scanner.Scan() {
+		line := r.scanner.Bytes()
+		if len(line) == 0 {
+			if len(data) == 0 && event.Event == "" {
+				continue
+			}
+			event.Data = bytes.Join(data, []byte("\n"))
+			return event, nil
+		}
+
+		name, value, _ := bytes.Cut(line, []byte(":"))
+		value = bytes.TrimPrefix(value, []byte(" "))
+		switch string(name) {
+		case "event":
+			event.Event = string(value)
+		case "data":
+			data = append(data, append([]byte(nil), value...))
+		}
+	}
+
+	if err := r.scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(data) > 0 {
+		event.Data = bytes.Join(data, []byte("\n"))
+		return event, nil
+	}
+	return nil, io.EOF
+}
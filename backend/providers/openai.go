@@ -0,0 +1,295 @@
+package providers
+
+import (
+	"ai-api-platform/backend/models"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+	"github.com/openai/openai-go/packages/ssestream"
+	"github.com/openai/openai-go/shared"
+)
+
+func init() {
+	Register(models.ProviderTypeOpenAI, newOpenAIProvider)
+}
+
+// openAIProvider 适配 OpenAI chat-completions 协议的供应商
+type openAIProvider struct {
+	client openai.Client
+	// modelOptions 返回针对某个模型的额外请求选项，Azure 用它把部署名称写入 URL
+	modelOptions func(model string) []option.RequestOption
+	// thinkingFields 是否附带 enable_thinking 等兼容厂商的扩展字段
+	thinkingFields bool
+}
+
+func newOpenAIProvider(provider *models.AIProvider, httpClient *http.Client) Provider {
+	return &openAIProvider{
+		client: openai.NewClient(
+			option.WithAPIKey(provider.APIKey),
+			option.WithBaseURL(provider.APIAddress),
+			option.WithHTTPClient(httpClient),
+			// 重试由代理层统一控制，见 handlers.retryState
+			option.WithMaxRetries(0),
+		),
+		thinkingFields: true,
+	}
+}
+
+// buildParams 将统一请求转换为 OpenAI 请求参数，网关传入的扩展字段通过 ExtraFields 透传
+func (p *openAIProvider) buildParams(req *ChatRequest) openai.ChatCompletionNewParams {
+	messages := make([]openai.ChatCompletionMessageParamUnion, 0, len(req.Messages))
+	for _, m := range req.Messages {
+		messages = append(messages, openAIMessage(m))
+	}
+
+	params := openai.ChatCompletionNewParams{
+		Messages:    messages,
+		Model:       req.Model,
+		Temperature: openai.Float(req.Temperature),
+	}
+	if req.TopP != nil {
+		params.TopP = openai.Float(*req.TopP)
+	}
+	// 客户端使用 max_completion_tokens 时原样透传，不再附带 max_tokens
+	if _, ok := req.Extra["max_completion_tokens"]; req.MaxTokens > 0 && !ok {
+		params.MaxTokens = openai.Int(req.MaxTokens)
+	}
+	if len(req.Stop) > 0 {
+		params.Stop = openai.ChatCompletionNewParamsStopUnion{OfStringArray: req.Stop}
+	}
+	if req.N > 1 {
+		params.N = openai.Int(int64(req.N))
+	}
+	for _, tool := range req.Tools {
+		function := shared.FunctionDefinitionParam{Name: tool.Name}
+		if tool.Description != "" {
+			function.Description = openai.String(tool.Description)
+		}
+		if len(tool.Parameters) > 0 {
+			var parameters shared.FunctionParameters
+			if json.Unmarshal(tool.Parameters, &parameters) == nil {
+				function.Parameters = parameters
+			}
+		}
+		params.Tools = append(params.Tools, openai.ChatCompletionToolParam{Function: function})
+	}
+
+	extraFields := make(map[string]interface{})
+	if p.thinkingFields {
+		extraFields["enable_thinking"] = req.EnableThinking
+		extraFields["reasoning_split"] = false
+	}
+	if len(req.ToolChoice) > 0 {
+		extraFields["tool_choice"] = req.ToolChoice
+	}
+	if len(req.ResponseFormat) > 0 {
+		extraFields["response_format"] = req.ResponseFormat
+	}
+	for k, v := range req.Extra {
+		extraFields[k] = v
+	}
+	if len(extraFields) > 0 {
+		params.SetExtraFields(extraFields)
+	}
+
+	return params
+}
+
+// openAIMessage 将统一消息转换为 OpenAI 消息参数
+func openAIMessage(m Message) openai.ChatCompletionMessageParamUnion {
+	switch m.Role {
+	case "system":
+		message := openai.SystemMessage(m.Content)
+		if m.Name != "" {
+			message.OfSystem.Name = openai.String(m.Name)
+		}
+		return message
+	case "assistant":
+		message := openai.AssistantMessage(m.Content)
+		if m.Name != "" {
+			message.OfAssistant.Name = openai.String(m.Name)
+		}
+		for _, call := range m.ToolCalls {
+			message.OfAssistant.ToolCalls = append(message.OfAssistant.ToolCalls, openai.ChatCompletionMessageToolCallParam{
+				ID: call.ID,
+				Function: openai.ChatCompletionMessageToolCallFunctionParam{
+					Name:      call.Name,
+					Arguments: call.Arguments,
+				},
+			})
+		}
+		if m.Content == "" && len(m.ToolCalls) > 0 {
+			message.OfAssistant.Content = openai.ChatCompletionAssistantMessageParamContentUnion{}
+		}
+		return message
+	case "tool":
+		return openai.ToolMessage(m.Content, m.ToolCallID)
+	default:
+		message := openai.UserMessage(m.Content)
+		if len(m.Images) > 0 {
+			parts := make([]openai.ChatCompletionContentPartUnionParam, 0, len(m.Images)+1)
+			if m.Content != "" {
+				parts = append(parts, openai.TextContentPart(m.Content))
+			}
+			for _, image := range m.Images {
+				parts = append(parts, openai.ImageContentPart(openai.ChatCompletionContentPartImageImageURLParam{URL: image.DataURL()}))
+			}
+			message = openai.UserMessage(parts)
+		}
+		if m.Name != "" {
+			message.OfUser.Name = openai.String(m.Name)
+		}
+		return message
+	}
+}
+
+// openAIUsage 转换用量，包括 prompt_tokens_details 中的缓存 Token 与 completion_tokens_details 中的推理 Token
+func openAIUsage(usage openai.CompletionUsage) Usage {
+	return Usage{
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		CachedTokens:     usage.PromptTokensDetails.CachedTokens,
+		ReasoningTokens:  usage.CompletionTokensDetails.ReasoningTokens,
+	}
+}
+
+// requestOptions 返回本次调用的额外请求选项
+func (p *openAIProvider) requestOptions(model string) []option.RequestOption {
+	if p.modelOptions == nil {
+		return nil
+	}
+	return p.modelOptions(model)
+}
+
+func (p *openAIProvider) Complete(ctx context.Context, req *ChatRequest) (*ChatResponse, error) {
+	completion, err := p.client.Chat.Completions.New(ctx, p.buildParams(req), p.requestOptions(req.Model)...)
+	if err != nil {
+		return nil, err
+	}
+	if len(completion.Choices) == 0 {
+		return nil, ErrEmptyResponse
+	}
+
+	response := &ChatResponse{
+		ID:      completion.ID,
+		Role:    string(completion.Choices[0].Message.Role),
+		Content: completion.Choices[0].Message.Content,
+		Usage:   openAIUsage(completion.Usage),
+	}
+	for _, choice := range completion.Choices {
+		message := Message{Role: string(choice.Message.Role), Content: choice.Message.Content}
+		for i, call := range choice.Message.ToolCalls {
+			message.ToolCalls = append(message.ToolCalls, ToolCall{
+				Index:     i,
+				ID:        call.ID,
+				Name:      call.Function.Name,
+				Arguments: call.Function.Arguments,
+			})
+		}
+		response.Choices = append(response.Choices, Choice{
+			Index:        int(choice.Index),
+			Message:      message,
+			FinishReason: choice.FinishReason,
+		})
+	}
+	return response, nil
+}
+
+func (p *openAIProvider) Stream(ctx context.Context, req *ChatRequest) (ChatStream, error) {
+	params := p.buildParams(req)
+	// 要求上游在流结束前返回一个包含整次请求用量的数据块
+	params.StreamOptions = openai.ChatCompletionStreamOptionsParam{IncludeUsage: openai.Bool(true)}
+	return &openAIStream{stream: p.client.Chat.Completions.NewStreaming(ctx, params, p.requestOptions(req.Model)...)}, nil
+}
+
+func (p *openAIProvider) Embed(ctx context.Context, model string, input []string) ([][]float64, error) {
+	result, err := p.client.Embeddings.New(ctx, openai.EmbeddingNewParams{
+		Model: model,
+		Input: openai.EmbeddingNewParamsInputUnion{OfArrayOfStrings: input},
+	}, p.requestOptions(model)...)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Data) != len(input) {
+		return nil, ErrEmptyResponse
+	}
+
+	vectors := make([][]float64, len(input))
+	for _, item := range result.Data {
+		if item.Index < 0 || int(item.Index) >= len(vectors) {
+			return nil, fmt.Errorf("embedding index %d out of range", item.Index)
+		}
+		vectors[item.Index] = item.Embedding
+	}
+	return vectors, nil
+}
+
+func (p *openAIProvider) ListModels(ctx context.Context) ([]string, error) {
+	iter := p.client.Models.ListAutoPaging(ctx)
+	var names []string
+	for iter.Next() {
+		names = append(names, iter.Current().ID)
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// openAIStream 将 openai-go 的流包装为 ChatStream，一个数据块包含多个候选回复时逐个输出
+type openAIStream struct {
+	stream  *ssestream.Stream[openai.ChatCompletionChunk]
+	pending []StreamChunk
+	current StreamChunk
+}
+
+func (s *openAIStream) Next() bool {
+	for len(s.pending) == 0 {
+		if !s.stream.Next() {
+			return false
+		}
+		chunk := s.stream.Current()
+		for _, choice := range chunk.Choices {
+			item := StreamChunk{
+				Index:        int(choice.Index),
+				Content:      choice.Delta.Content,
+				FinishReason: choice.FinishReason,
+			}
+			for _, call := range choice.Delta.ToolCalls {
+				item.ToolCalls = append(item.ToolCalls, ToolCall{
+					Index:     int(call.Index),
+					ID:        call.ID,
+					Name:      call.Function.Name,
+					Arguments: call.Function.Arguments,
+				})
+			}
+			s.pending = append(s.pending, item)
+		}
+		if chunk.Usage.PromptTokens > 0 || chunk.Usage.CompletionTokens > 0 {
+			usage := openAIUsage(chunk.Usage)
+			if len(s.pending) > 0 {
+				s.pending[len(s.pending)-1].Usage = &usage
+			} else {
+				s.pending = append(s.pending, StreamChunk{Usage: &usage})
+			}
+		}
+	}
+	s.current, s.pending = s.pending[0], s.pending[1:]
+	return true
+}
+
+func (s *openAIStream) Current() StreamChunk {
+	return s.current
+}
+
+func (s *openAIStream) Err() error {
+	return s.stream.Err()
+}
+
+func (s *openAIStream) Close() error {
+	return s.stream.Close()
+}
@@ -0,0 +1,267 @@
+package providers
+
+import (
+	"ai-api-platform/backend/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrEmptyResponse 表示供应商返回了空结果
+var ErrEmptyResponse = errors.New("provider returned no choices")
+
+// ErrUnsupportedFeature 表示请求使用了该供应商适配器不支持的功能（如工具调用、n > 1），应切换到其他供应商
+var ErrUnsupportedFeature = errors.New("feature not supported by provider")
+
+// Message 表示一条对话消息，与具体供应商协议无关
+type Message struct {
+	Role       string
+	Content    string
+	Name       string     // 可选的参与者名称
+	ToolCalls  []ToolCall // assistant 消息中模型发起的工具调用
+	ToolCallID string     // tool 消息对应的工具调用 ID
+	Images     []Image    // user 消息附带的图片，排在文本之后
+}
+
+// Image 是用户消息中的一张图片，URL 与 Data 二选一
+type Image struct {
+	URL       string // http(s) 图片地址
+	MediaType string // 图片的 MIME 类型，如 image/png，使用 Data 时必填
+	Data      string // base64 编码的图片数据
+}
+
+// DataURL 返回图片的 data URL，URL 图片原样返回
+func (i Image) DataURL() string {
+	if i.URL != "" {
+		return i.URL
+	}
+	return "data:" + i.MediaType + ";base64," + i.Data
+}
+
+// ToolCall 是模型发起的一次函数调用
+type ToolCall struct {
+	Index     int // 流式增量中该调用的序号
+	ID        string
+	Name      string
+	Arguments string // JSON 字符串，流式输出时为增量片段
+}
+
+// Tool 是可供模型调用的函数
+type Tool struct {
+	Name        string
+	Description string
+	Parameters  json.RawMessage // 参数的 JSON Schema
+}
+
+// ChatRequest 是适配器统一接收的聊天请求
+type ChatRequest struct {
+	Model          string
+	Messages       []Message
+	Temperature    float64
+	EnableThinking bool
+
+	// 以下字段由 OpenAI 兼容网关传入，零值表示客户端未设置
+	TopP           *float64
+	MaxTokens      int64
+	Stop           []string
+	N              int
+	Tools          []Tool
+	ToolChoice     json.RawMessage            // "auto"、"none"、"required" 或指定函数
+	ResponseFormat json.RawMessage            // 如 {"type":"json_object"}
+	Extra          map[string]json.RawMessage // 其余字段，原样透传给 OpenAI 兼容的供应商
+}
+
+// Usage 是一次调用的 Token 用量
+type Usage struct {
+	PromptTokens     int64
+	CompletionTokens int64
+	CachedTokens     int64 // 输入 Token 中命中上游提示词缓存的部分
+	ReasoningTokens  int64 // 输出 Token 中推理（思考）的部分
+	Estimated        bool  // 上游没有返回用量，数值由 EstimateUsage 估算
+}
+
+// Add 累加另一次调用的用量，任意一次为估算值时结果也标记为估算
+func (u *Usage) Add(other Usage) {
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+	u.CachedTokens += other.CachedTokens
+	u.ReasoningTokens += other.ReasoningTokens
+	u.Estimated = u.Estimated || other.Estimated
+}
+
+// Choice 是一个候选回复
+type Choice struct {
+	Index        int
+	Message      Message
+	FinishReason string
+}
+
+// ChatResponse 是适配器统一返回的非流式结果，Role 与 Content 与第一个候选回复一致
+type ChatResponse struct {
+	ID      string
+	Role    string
+	Content string
+	Choices []Choice
+	Usage   Usage
+}
+
+// StreamChunk 是流式输出的一个增量片段，Usage 仅在供应商返回用量时非空
+type StreamChunk struct {
+	Index        int // 候选回复序号，n > 1 时使用
+	Content      string
+	ToolCalls    []ToolCall
+	FinishReason string
+	Usage        *Usage
+}
+
+// HasOutput 判断片段是否包含需要发给客户端的内容
+func (c StreamChunk) HasOutput() bool {
+	return c.Content != "" || len(c.ToolCalls) > 0 || c.FinishReason != ""
+}
+
+// requireBasic 检查请求是否只用到了纯文本对话功能，供不支持扩展字段的适配器调用
+func requireBasic(req *ChatRequest) error {
+	if len(req.Tools) > 0 || len(req.ToolChoice) > 0 {
+		return fmt.Errorf("%w: tools", ErrUnsupportedFeature)
+	}
+	if len(req.ResponseFormat) > 0 {
+		return fmt.Errorf("%w: response_format", ErrUnsupportedFeature)
+	}
+	if req.N > 1 {
+		return fmt.Errorf("%w: n > 1", ErrUnsupportedFeature)
+	}
+	for _, m := range req.Messages {
+		if m.Role == "tool" || len(m.ToolCalls) > 0 {
+			return fmt.Errorf("%w: tool messages", ErrUnsupportedFeature)
+		}
+	}
+	return nil
+}
+
+// textChoice 构造只包含一个文本回复的 Choices
+func textChoice(content, finishReason string) []Choice {
+	return []Choice{{Message: Message{Role: "assistant", Content: content}, FinishReason: finishReason}}
+}
+
+// ChatStream 是流式输出的迭代器，用法与 openai-go 的 ssestream 一致
+type ChatStream interface {
+	Next() bool
+	Current() StreamChunk
+	Err() error
+	Close() error
+}
+
+// Provider 是上游大模型供应商的适配器接口
+type Provider interface {
+	// Complete 发起一次非流式调用
+	Complete(ctx context.Context, req *ChatRequest) (*ChatResponse, error)
+	// Stream 发起一次流式调用
+	Stream(ctx context.Context, req *ChatRequest) (ChatStream, error)
+	// ListModels 列出供应商可用的模型
+	ListModels(ctx context.Context) ([]string, error)
+}
+
+// Embedder 是支持文本向量化的适配器实现的可选接口
+type Embedder interface {
+	// Embed 返回每段输入文本的向量，顺序与 input 一致
+	Embed(ctx context.Context, model string, input []string) ([][]float64, error)
+}
+
+// Embed 调用适配器的向量化接口，适配器不支持时返回 ErrUnsupportedFeature
+func Embed(ctx context.Context, provider Provider, model string, input []string) ([][]float64, error) {
+	embedder, ok := provider.(Embedder)
+	if !ok {
+		return nil, fmt.Errorf("%w: embeddings", ErrUnsupportedFeature)
+	}
+	return embedder.Embed(ctx, model, input)
+}
+
+// Factory 根据供应商配置创建适配器
+type Factory func(provider *models.AIProvider, httpClient *http.Client) Provider
+
+// RequestCheck 检查请求是否只用到了适配器支持的功能，不支持时返回包装了 ErrUnsupportedFeature 的错误
+type RequestCheck func(req *ChatRequest) error
+
+var (
+	factories     = make(map[string]Factory)
+	requestChecks = make(map[string]RequestCheck)
+	factoriesMu   sync.RWMutex
+)
+
+// Register 注册一种供应商类型的适配器
+func Register(providerType string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	factories[providerType] = factory
+}
+
+// RegisterRequestCheck 注册一种供应商类型在调用上游之前检查请求的函数
+func RegisterRequestCheck(providerType string, check RequestCheck) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	requestChecks[providerType] = check
+}
+
+// CheckRequest 检查该类型的适配器能否处理请求，未注册检查函数的类型支持所有功能。
+// 代理在构建尝试列表与每次调用上游之前检查，避免把不支持的请求计为上游故障。
+func CheckRequest(providerType string, req *ChatRequest) error {
+	factoriesMu.RLock()
+	check, ok := requestChecks[NormalizeType(providerType)]
+	factoriesMu.RUnlock()
+	if !ok {
+		return nil
+	}
+	return check(req)
+}
+
+// New 根据供应商的 Type 创建对应的适配器，Type 为空时按 OpenAI 兼容协议处理
+func New(provider *models.AIProvider, httpClient *http.Client) (Provider, error) {
+	providerType := NormalizeType(provider.Type)
+
+	factoriesMu.RLock()
+	factory, ok := factories[providerType]
+	factoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unsupported provider type: %s", providerType)
+	}
+
+	return factory(provider, httpClient), nil
+}
+
+// NormalizeType 规范化供应商类型
+func NormalizeType(providerType string) string {
+	providerType = strings.ToLower(strings.TrimSpace(providerType))
+	if providerType == "" {
+		return models.ProviderTypeOpenAI
+	}
+	return providerType
+}
+
+// IsSupported 判断供应商类型是否已注册
+func IsSupported(providerType string) bool {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	_, ok := factories[NormalizeType(providerType)]
+	return ok
+}
+
+// Types 返回所有已注册的供应商类型
+func Types() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	types := make([]string, 0, len(factories))
+	for t := range factories {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
@@ -0,0 +1,121 @@
+package services
+
+import (
+	"ai-api-platform/backend/models"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// PoolCandidate 是参与负载均衡的一个池成员
+type PoolCandidate struct {
+	Key    string // 成员标识，见 MemberKey
+	Weight int
+}
+
+var (
+	balancerMux sync.Mutex
+	// 每个 API 路径的平滑加权轮询状态：成员标识 -> 当前权重
+	roundRobinState = make(map[uint]map[string]int)
+	// 每个供应商/模型正在进行中的请求数
+	inFlight = make(map[string]int)
+)
+
+// MemberKey 返回供应商/模型组合的标识
+func MemberKey(providerID uint, modelName string) string {
+	return fmt.Sprintf("%d/%s", providerID, modelName)
+}
+
+// OrderPool 按路由模式返回池成员的尝试顺序（candidates 的下标）。
+// 第一个元素是本次选中的成员，其余成员作为后续的备用。
+func OrderPool(endpointID uint, mode string, candidates []PoolCandidate) []int {
+	order := make([]int, len(candidates))
+	for i := range order {
+		order[i] = i
+	}
+	if len(candidates) <= 1 {
+		return order
+	}
+
+	balancerMux.Lock()
+	defer balancerMux.Unlock()
+
+	switch mode {
+	case models.RoutingModeWeighted:
+		// 按权重进行不放回抽样：key = u^(1/w)，按 key 从大到小排列
+		keys := make([]float64, len(candidates))
+		for i, c := range candidates {
+			keys[i] = math.Pow(rand.Float64(), 1/float64(weightOf(c)))
+		}
+		sort.SliceStable(order, func(a, b int) bool {
+			return keys[order[a]] > keys[order[b]]
+		})
+	case models.RoutingModeRoundRobin:
+		selected := smoothRoundRobin(endpointID, candidates)
+		reordered := []int{selected}
+		for _, i := range order {
+			if i != selected {
+				reordered = append(reordered, i)
+			}
+		}
+		order = reordered
+	case models.RoutingModeLeastInFlight:
+		sort.SliceStable(order, func(a, b int) bool {
+			ca, cb := candidates[order[a]], candidates[order[b]]
+			return float64(inFlight[ca.Key])/float64(weightOf(ca)) < float64(inFlight[cb.Key])/float64(weightOf(cb))
+		})
+	}
+	return order
+}
+
+// smoothRoundRobin 平滑加权轮询（与 nginx 的实现一致），调用方需持有 balancerMux
+func smoothRoundRobin(endpointID uint, candidates []PoolCandidate) int {
+	state, ok := roundRobinState[endpointID]
+	if !ok {
+		state = make(map[string]int)
+		roundRobinState[endpointID] = state
+	}
+
+	total := 0
+	selected := 0
+	for i, c := range candidates {
+		weight := weightOf(c)
+		total += weight
+		state[c.Key] += weight
+		if state[c.Key] > state[candidates[selected].Key] {
+			selected = i
+		}
+	}
+	state[candidates[selected].Key] -= total
+	return selected
+}
+
+// weightOf 返回成员权重，未配置时视为 1
+func weightOf(c PoolCandidate) int {
+	if c.Weight <= 0 {
+		return 1
+	}
+	return c.Weight
+}
+
+// AcquireInFlight 标记一个请求开始调用指定成员
+func AcquireInFlight(key string) {
+	balancerMux.Lock()
+	defer balancerMux.Unlock()
+
+	inFlight[key]++
+}
+
+// ReleaseInFlight 标记一个请求结束调用指定成员
+func ReleaseInFlight(key string) {
+	balancerMux.Lock()
+	defer balancerMux.Unlock()
+
+	if inFlight[key] <= 1 {
+		delete(inFlight, key)
+		return
+	}
+	inFlight[key]--
+}
@@ -0,0 +1,308 @@
+package services
+
+import (
+	"ai-api-platform/backend/providers"
+	"ai-api-platform/backend/utils"
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 熔断器状态
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+)
+
+// ErrCircuitOpen 表示供应商/模型的熔断器处于打开状态，本次尝试被直接跳过
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// 最多保留的状态变化记录数
+const maxBreakerEvents = 200
+
+// BreakerStatus 是一个供应商/模型熔断器的状态快照
+type BreakerStatus struct {
+	Key        string     `json:"key"`
+	State      string     `json:"state"`
+	Requests   int        `json:"requests"`   // 滑动窗口内的调用次数
+	Failures   int        `json:"failures"`   // 滑动窗口内的失败次数
+	ErrorRate  float64    `json:"error_rate"` // 滑动窗口内的错误率
+	OpenedAt   *time.Time `json:"opened_at,omitempty"`
+	RetryAt    *time.Time `json:"retry_at,omitempty"` // 冷却结束、允许探测的时间
+	LastError  string     `json:"last_error,omitempty"`
+	LastChange time.Time  `json:"last_change"`
+}
+
+// BreakerEvent 是一次熔断器状态变化
+type BreakerEvent struct {
+	Key    string    `json:"key"`
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	Reason string    `json:"reason,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// breaker 是单个供应商/模型的熔断器
+type breaker struct {
+	state      string
+	results    []bool // 滑动窗口，true 表示失败
+	next       int    // 滑动窗口的写入位置
+	openedAt   time.Time
+	probes     int       // 半开状态下正在进行的探测请求数
+	probeAt    time.Time // 最近一次放行探测的时间
+	lastError  string
+	lastChange time.Time
+}
+
+var (
+	breakersMux   sync.Mutex
+	breakers      = make(map[string]*breaker)
+	breakerEvents []BreakerEvent
+)
+
+// breakerSettings 返回熔断配置，未配置的项使用默认值
+func breakerSettings() (window, minRequests int, errorRate float64, coolOff time.Duration, probes int) {
+	config := utils.GlobalConfig.CircuitBreaker
+	window, minRequests, errorRate, probes = config.WindowSize, config.MinRequests, config.ErrorRate, config.HalfOpenRequests
+	coolOff = time.Duration(config.CoolOff) * time.Second
+	if window <= 0 {
+		window = 20
+	}
+	if minRequests <= 0 {
+		minRequests = 5
+	}
+	if minRequests > window {
+		minRequests = window
+	}
+	if errorRate <= 0 || errorRate > 1 {
+		errorRate = 0.5
+	}
+	if coolOff <= 0 {
+		coolOff = 30 * time.Second
+	}
+	if probes <= 0 {
+		probes = 1
+	}
+	return
+}
+
+// BreakerAvailable 判断供应商/模型当前是否可能被调用（不占用半开状态的探测名额），用于构建尝试列表
+func BreakerAvailable(key string) bool {
+	if utils.GlobalConfig.CircuitBreaker.Disabled {
+		return true
+	}
+
+	breakersMux.Lock()
+	defer breakersMux.Unlock()
+
+	b, ok := breakers[key]
+	if !ok {
+		return true
+	}
+	_, _, _, coolOff, probes := breakerSettings()
+	switch b.state {
+	case BreakerOpen:
+		return !time.Now().Before(b.openedAt.Add(coolOff))
+	case BreakerHalfOpen:
+		return b.probes < probes || time.Since(b.probeAt) >= coolOff
+	}
+	return true
+}
+
+// AllowBreaker 在调用上游前检查熔断器：打开状态直接拒绝，冷却结束后转为半开并放行有限的探测请求
+func AllowBreaker(key string) bool {
+	if utils.GlobalConfig.CircuitBreaker.Disabled {
+		return true
+	}
+
+	breakersMux.Lock()
+	defer breakersMux.Unlock()
+
+	b, ok := breakers[key]
+	if !ok {
+		return true
+	}
+	_, _, _, coolOff, probes := breakerSettings()
+	now := time.Now()
+	switch b.state {
+	case BreakerOpen:
+		if now.Before(b.openedAt.Add(coolOff)) {
+			return false
+		}
+		b.transition(key, BreakerHalfOpen, "cool-off elapsed", now)
+		b.probes = 1
+		b.probeAt = now
+		return true
+	case BreakerHalfOpen:
+		// 探测请求没有回报结果（例如客户端断开）时，冷却时间后允许重新探测
+		if b.probes >= probes && now.Sub(b.probeAt) < coolOff {
+			return false
+		}
+		if b.probes >= probes {
+			b.probes = 0
+		}
+		b.probes++
+		b.probeAt = now
+		return true
+	}
+	return true
+}
+
+// ReportBreakerResult 记录一次上游调用结果。
+// 半开状态下探测成功则关闭熔断器，失败则重新打开；关闭状态下错误率超过阈值时打开熔断器。
+func ReportBreakerResult(key string, err error) {
+	if utils.GlobalConfig.CircuitBreaker.Disabled {
+		return
+	}
+	failed := isBreakerFailure(err)
+	if err != nil && !failed {
+		// 请求本身的问题（如 400）不代表上游故障
+		return
+	}
+
+	breakersMux.Lock()
+	defer breakersMux.Unlock()
+
+	window, minRequests, errorRate, _, _ := breakerSettings()
+	now := time.Now()
+	b, ok := breakers[key]
+	if !ok {
+		if !failed {
+			// 从未失败过的成员不需要创建熔断器
+			return
+		}
+		b = &breaker{state: BreakerClosed, lastChange: now}
+		breakers[key] = b
+	}
+	if failed {
+		b.lastError = err.Error()
+	}
+
+	switch b.state {
+	case BreakerHalfOpen:
+		if b.probes > 0 {
+			b.probes--
+		}
+		if failed {
+			b.transition(key, BreakerOpen, b.lastError, now)
+		} else {
+			b.transition(key, BreakerClosed, "probe succeeded", now)
+		}
+		return
+	case BreakerOpen:
+		// 打开前已发出的请求返回结果，不影响状态
+		return
+	}
+
+	if cap(b.results) != window {
+		b.results = make([]bool, 0, window)
+		b.next = 0
+	}
+	if len(b.results) < window {
+		b.results = append(b.results, failed)
+	} else {
+		b.results[b.next] = failed
+	}
+	b.next = (b.next + 1) % window
+
+	requests, failures := b.counts()
+	if requests >= minRequests && float64(failures)/float64(requests) >= errorRate {
+		b.transition(key, BreakerOpen, b.lastError, now)
+	}
+}
+
+// isBreakerFailure 判断错误是否说明上游不可用：网络错误、超时、429 和 5xx。
+// 适配器不支持请求所用的功能时没有调用上游，回复不符合 JSON Schema 时上游正常返回，都不算失败。
+func isBreakerFailure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, ErrNoHealthyKey) ||
+		errors.Is(err, providers.ErrUnsupportedFeature) || errors.Is(err, ErrInvalidOutput) {
+		return false
+	}
+	status := providers.StatusCode(err)
+	return status == 0 || status == http.StatusTooManyRequests || status >= 500
+}
+
+// transition 切换熔断器状态并记录变化，调用方需持有 breakersMux
+func (b *breaker) transition(key, state, reason string, now time.Time) {
+	if b.state == state {
+		return
+	}
+	breakerEvents = append(breakerEvents, BreakerEvent{Key: key, From: b.state, To: state, Reason: reason, Time: now})
+	if len(breakerEvents) > maxBreakerEvents {
+		breakerEvents = breakerEvents[len(breakerEvents)-maxBreakerEvents:]
+	}
+
+	b.state = state
+	b.lastChange = now
+	switch state {
+	case BreakerOpen:
+		b.openedAt = now
+		b.probes = 0
+	case BreakerClosed:
+		b.results = b.results[:0]
+		b.next = 0
+		b.probes = 0
+	}
+}
+
+// counts 返回滑动窗口内的调用次数与失败次数
+func (b *breaker) counts() (requests, failures int) {
+	for _, failed := range b.results {
+		if failed {
+			failures++
+		}
+	}
+	return len(b.results), failures
+}
+
+// GetBreakers 返回所有熔断器的状态快照与最近的状态变化记录
+func GetBreakers() ([]BreakerStatus, []BreakerEvent) {
+	breakersMux.Lock()
+	defer breakersMux.Unlock()
+
+	_, _, _, coolOff, _ := breakerSettings()
+	statuses := make([]BreakerStatus, 0, len(breakers))
+	for key, b := range breakers {
+		requests, failures := b.counts()
+		status := BreakerStatus{
+			Key:        key,
+			State:      b.state,
+			Requests:   requests,
+			Failures:   failures,
+			LastError:  b.lastError,
+			LastChange: b.lastChange,
+		}
+		if requests > 0 {
+			status.ErrorRate = float64(failures) / float64(requests)
+		}
+		if b.state != BreakerClosed {
+			openedAt, retryAt := b.openedAt, b.openedAt.Add(coolOff)
+			status.OpenedAt = &openedAt
+			status.RetryAt = &retryAt
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Key < statuses[j].Key })
+
+	events := make([]BreakerEvent, len(breakerEvents))
+	copy(events, breakerEvents)
+	return statuses, events
+}
+
+// ResetBreaker 手动关闭指定供应商/模型的熔断器，返回熔断器是否存在
+func ResetBreaker(key string) bool {
+	breakersMux.Lock()
+	defer breakersMux.Unlock()
+
+	b, ok := breakers[key]
+	if !ok {
+		return false
+	}
+	b.transition(key, BreakerClosed, "manual reset", time.Now())
+	b.lastError = ""
+	return true
+}
@@ -0,0 +1,186 @@
+package services
+
+import (
+	"ai-api-platform/backend/providers"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+var errUpstreamDown = &providers.APIError{StatusCode: 503, Message: "unavailable"}
+
+// newTestBreaker 返回一个测试专用的熔断器 Key，测试结束后删除对应的熔断器
+func newTestBreaker(t *testing.T) string {
+	t.Helper()
+	key := "test:" + t.Name()
+	t.Cleanup(func() {
+		breakersMux.Lock()
+		delete(breakers, key)
+		breakersMux.Unlock()
+	})
+	return key
+}
+
+// breakerState 返回熔断器当前的状态，不存在时返回空字符串
+func breakerState(key string) string {
+	breakersMux.Lock()
+	defer breakersMux.Unlock()
+	if b, ok := breakers[key]; ok {
+		return b.state
+	}
+	return ""
+}
+
+// ageBreaker 把熔断器的打开与探测时间提前 d，模拟冷却时间已经过去
+func ageBreaker(key string, d time.Duration) {
+	breakersMux.Lock()
+	defer breakersMux.Unlock()
+	b := breakers[key]
+	b.openedAt = b.openedAt.Add(-d)
+	b.probeAt = b.probeAt.Add(-d)
+}
+
+// openBreaker 连续回报失败直到熔断器打开（默认至少 5 次调用、错误率 50%）
+func openBreaker(t *testing.T, key string) {
+	t.Helper()
+	for i := 0; i < 5; i++ {
+		ReportBreakerResult(key, errUpstreamDown)
+	}
+	if state := breakerState(key); state != BreakerOpen {
+		t.Fatalf("state after 5 failures = %q, want %q", state, BreakerOpen)
+	}
+}
+
+func TestBreakerOpensAtErrorRate(t *testing.T) {
+	key := newTestBreaker(t)
+	ReportBreakerResult(key, nil)
+	if state := breakerState(key); state != "" {
+		t.Fatalf("successful call created a breaker in state %q", state)
+	}
+	for i := 0; i < 4; i++ {
+		ReportBreakerResult(key, errUpstreamDown)
+	}
+	// 少于 5 次调用时不打开
+	if state := breakerState(key); state != BreakerClosed {
+		t.Fatalf("state after 4 failures = %q, want %q", state, BreakerClosed)
+	}
+	ReportBreakerResult(key, errUpstreamDown)
+	if state := breakerState(key); state != BreakerOpen {
+		t.Fatalf("state after 5 failures = %q, want %q", state, BreakerOpen)
+	}
+	if AllowBreaker(key) || BreakerAvailable(key) {
+		t.Error("open breaker allowed a call during cool-off")
+	}
+}
+
+func TestBreakerHalfOpenProbeSucceeds(t *testing.T) {
+	key := newTestBreaker(t)
+	openBreaker(t, key)
+	ageBreaker(key, time.Minute)
+
+	if !BreakerAvailable(key) {
+		t.Fatal("breaker unavailable after cool-off")
+	}
+	if !AllowBreaker(key) {
+		t.Fatal("probe rejected after cool-off")
+	}
+	if state := breakerState(key); state != BreakerHalfOpen {
+		t.Fatalf("state = %q, want %q", state, BreakerHalfOpen)
+	}
+	// 默认只放行一个探测请求
+	if AllowBreaker(key) || BreakerAvailable(key) {
+		t.Error("second probe allowed while the first is in flight")
+	}
+
+	ReportBreakerResult(key, nil)
+	if state := breakerState(key); state != BreakerClosed {
+		t.Fatalf("state after successful probe = %q, want %q", state, BreakerClosed)
+	}
+	// 关闭后滑动窗口清空，再失败 4 次不会打开
+	for i := 0; i < 4; i++ {
+		ReportBreakerResult(key, errUpstreamDown)
+	}
+	if state := breakerState(key); state != BreakerClosed {
+		t.Errorf("state = %q, want %q", state, BreakerClosed)
+	}
+}
+
+func TestBreakerHalfOpenProbeFails(t *testing.T) {
+	key := newTestBreaker(t)
+	openBreaker(t, key)
+	ageBreaker(key, time.Minute)
+
+	if !AllowBreaker(key) {
+		t.Fatal("probe rejected after cool-off")
+	}
+	ReportBreakerResult(key, errUpstreamDown)
+	if state := breakerState(key); state != BreakerOpen {
+		t.Fatalf("state after failed probe = %q, want %q", state, BreakerOpen)
+	}
+	// 重新打开后重新计算冷却时间
+	if AllowBreaker(key) {
+		t.Error("call allowed right after a failed probe")
+	}
+}
+
+func TestBreakerHalfOpenLostProbe(t *testing.T) {
+	key := newTestBreaker(t)
+	openBreaker(t, key)
+	ageBreaker(key, time.Minute)
+
+	if !AllowBreaker(key) {
+		t.Fatal("probe rejected after cool-off")
+	}
+	// 探测请求没有回报结果，冷却时间后允许重新探测
+	ageBreaker(key, time.Minute)
+	if !BreakerAvailable(key) || !AllowBreaker(key) {
+		t.Error("new probe rejected after the previous probe was lost")
+	}
+	if state := breakerState(key); state != BreakerHalfOpen {
+		t.Errorf("state = %q, want %q", state, BreakerHalfOpen)
+	}
+}
+
+func TestBreakerIgnoresNonUpstreamFailures(t *testing.T) {
+	errs := []error{
+		&providers.APIError{StatusCode: 400, Message: "bad request"},
+		context.Canceled,
+		fmt.Errorf("attempt: %w", providers.ErrUnsupportedFeature),
+		fmt.Errorf("attempt: %w", ErrInvalidOutput),
+		ErrNoHealthyKey,
+	}
+	for _, err := range errs {
+		t.Run(err.Error(), func(t *testing.T) {
+			key := newTestBreaker(t)
+			for i := 0; i < 10; i++ {
+				ReportBreakerResult(key, err)
+			}
+			if state := breakerState(key); state != "" {
+				t.Errorf("state = %q, want no breaker", state)
+			}
+		})
+	}
+}
+
+func TestIsBreakerFailure(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"429", &providers.APIError{StatusCode: 429}, true},
+		{"500", &providers.APIError{StatusCode: 500}, true},
+		{"404", &providers.APIError{StatusCode: 404}, false},
+		{"timeout", context.DeadlineExceeded, true},
+		{"network", fmt.Errorf("dial tcp: connection refused"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isBreakerFailure(tt.err); got != tt.want {
+				t.Errorf("isBreakerFailure(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
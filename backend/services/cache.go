@@ -2,9 +2,24 @@ package services
 
 import (
 	"ai-api-platform/backend/models"	
+	"log"
+	"strings"
 	"sync"
 )
 
+// reservedPaths 是内置路由占用的路径，gin 会优先匹配这些路由，同名的自定义 API 路径永远不会被代理
+var reservedPaths = []string{"/v1/chat/completions", "/v1/messages", "/v1/models", "/v1/usage", "/sessions", "/usage"}
+
+// IsReservedPath 判断路径是否与内置路由冲突（/sessions 下的所有子路径也被保留）
+func IsReservedPath(path string) bool {
+	for _, reserved := range reservedPaths {
+		if path == reserved {
+			return true
+		}
+	}
+	return strings.HasPrefix(path, "/sessions/")
+}
+
 var (
 	endpointCache    map[string]*models.APIEndpoint
 	endpointCacheMux sync.RWMutex
@@ -18,11 +33,14 @@ func InitEndpointCache() error {
 	endpointCache = make(map[string]*models.APIEndpoint)
 
 	var endpoints []models.APIEndpoint
-	if err := models.DB.Preload("Provider").Find(&endpoints).Error; err != nil {
+	if err := models.PreloadEndpoint(models.DB).Find(&endpoints).Error; err != nil {
 		return err
 	}
 
 	for i := range endpoints {
+		if IsReservedPath(endpoints[i].Path) {
+			log.Printf("ERROR: endpoint %d path %s collides with a built-in route and will never be proxied", endpoints[i].ID, endpoints[i].Path)
+		}
 		endpointCache[endpoints[i].Path] = &endpoints[i]
 	}
 
@@ -38,13 +56,26 @@ func GetEndpointByPath(path string) (*models.APIEndpoint, bool) {
 	return endpoint, exists
 }
 
+// GetEndpointByID 从缓存获取指定 ID 的 API 路径配置
+func GetEndpointByID(id uint) (*models.APIEndpoint, bool) {
+	endpointCacheMux.RLock()
+	defer endpointCacheMux.RUnlock()
+
+	for _, endpoint := range endpointCache {
+		if endpoint.ID == id {
+			return endpoint, true
+		}
+	}
+	return nil, false
+}
+
 // UpdateEndpointCache 更新缓存中的 API 路径
 func UpdateEndpointCache(endpoint *models.APIEndpoint) {
 	endpointCacheMux.Lock()
 	defer endpointCacheMux.Unlock()
 
-	// 重新加载 Provider 关联数据
-	models.DB.Preload("Provider").First(endpoint, endpoint.ID)
+	// 重新加载 Provider 与备用模型关联数据
+	models.PreloadEndpoint(models.DB).First(endpoint, endpoint.ID)
 	endpointCache[endpoint.Path] = endpoint
 }
 
@@ -0,0 +1,29 @@
+package services
+
+import "testing"
+
+func TestIsReservedPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/v1/chat/completions", true},
+		{"/v1/messages", true},
+		{"/v1/models", true},
+		{"/v1/usage", true},
+		{"/usage", true},
+		{"/sessions", true},
+		{"/sessions/abc", true},
+		{"/v1/embeddings", false},
+		{"/usage/report", false},
+		{"/sessions-export", false},
+		{"/chat", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := IsReservedPath(tt.path); got != tt.want {
+				t.Errorf("IsReservedPath(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
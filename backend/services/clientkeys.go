@@ -0,0 +1,97 @@
+package services
+
+import (
+	"ai-api-platform/backend/models"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+)
+
+// 客户端 Key 校验失败的原因
+var (
+	ErrClientKeyInvalid   = errors.New("invalid API key")                          // Key 不存在、已吊销或已过期
+	ErrClientKeyEndpoint  = errors.New("API key cannot access this endpoint")      // Key 不能访问该 API 路径
+	ErrClientKeyForbidden = errors.New("API key does not have the required scope") // Key 没有所需的权限范围
+)
+
+var (
+	clientKeys    map[string][]*models.ClientKey // 前缀 -> 客户端 Key（含可访问的 API 路径）
+	clientKeysMux sync.RWMutex
+)
+
+// InitClientKeys 加载所有客户端 Key
+func InitClientKeys() error {
+	var keys []models.ClientKey
+	if err := models.DB.Preload("Endpoints").Find(&keys).Error; err != nil {
+		return err
+	}
+
+	clientKeysMux.Lock()
+	defer clientKeysMux.Unlock()
+
+	clientKeys = make(map[string][]*models.ClientKey, len(keys))
+	for i := range keys {
+		clientKeys[keys[i].Prefix] = append(clientKeys[keys[i].Prefix], &keys[i])
+	}
+	return nil
+}
+
+// RefreshClientKeys 在客户端 Key 或 API 路径变更后重新加载
+func RefreshClientKeys() error {
+	return InitClientKeys()
+}
+
+// GenerateClientKey 生成 ck- 开头的随机客户端 Key
+func GenerateClientKey() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return "ck-" + hex.EncodeToString(buf), nil
+}
+
+// ClientKeyActive 返回客户端 Key 在 now 是否有效（未吊销且未过期）
+func ClientKeyActive(key *models.ClientKey, now time.Time) bool {
+	return !key.Revoked && (key.ExpiresAt == nil || now.Before(*key.ExpiresAt))
+}
+
+// ClientKeyRootID 返回客户端 Key 所在轮换链中最初签发的 Key 的 ID，轮换后的 Key 继承它的额度与会话
+func ClientKeyRootID(key *models.ClientKey) uint {
+	if key.RootID != 0 {
+		return key.RootID
+	}
+	return key.ID
+}
+
+// AuthenticateClientKey 校验客户端 Key 能否以 scope 权限访问 API 路径。
+// 按前缀找到候选 Key 后以常数时间比较哈希，不存在、已吊销或已过期的 Key 返回 ErrClientKeyInvalid。
+func AuthenticateClientKey(key string, endpointID uint, scope string) (*models.ClientKey, error) {
+	if key == "" {
+		return nil, ErrClientKeyInvalid
+	}
+	hash := []byte(models.HashClientKey(key))
+
+	clientKeysMux.RLock()
+	defer clientKeysMux.RUnlock()
+
+	var matched *models.ClientKey
+	for _, candidate := range clientKeys[models.ClientKeyPrefix(key)] {
+		if subtle.ConstantTimeCompare(hash, []byte(candidate.KeyHash)) == 1 {
+			matched = candidate
+		}
+	}
+	if matched == nil || !ClientKeyActive(matched, time.Now()) {
+		return nil, ErrClientKeyInvalid
+	}
+	if !slices.ContainsFunc(matched.Endpoints, func(e models.APIEndpoint) bool { return e.ID == endpointID }) {
+		return nil, ErrClientKeyEndpoint
+	}
+	if !slices.Contains(matched.Scopes, scope) {
+		return nil, ErrClientKeyForbidden
+	}
+	return matched, nil
+}
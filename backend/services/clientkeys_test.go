@@ -0,0 +1,147 @@
+package services
+
+import (
+	"ai-api-platform/backend/models"
+	"errors"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestClientKeyActive(t *testing.T) {
+	now := time.Date(2026, 3, 9, 12, 0, 0, 0, time.UTC)
+	past, future := now.Add(-time.Second), now.Add(time.Hour)
+	tests := []struct {
+		name string
+		key  models.ClientKey
+		want bool
+	}{
+		{"no expiry", models.ClientKey{}, true},
+		{"expires later", models.ClientKey{ExpiresAt: &future}, true},
+		{"expired", models.ClientKey{ExpiresAt: &past}, false},
+		{"expires now", models.ClientKey{ExpiresAt: &now}, false},
+		{"revoked", models.ClientKey{Revoked: true}, false},
+		{"revoked before expiry", models.ClientKey{Revoked: true, ExpiresAt: &future}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ClientKeyActive(&tt.key, now); got != tt.want {
+				t.Errorf("ClientKeyActive = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientKeyLimitsSharedAcrossRotation(t *testing.T) {
+	limits := models.KeyLimits{RateLimitRPM: 10, DailyTokenQuota: 1000}
+	original := &models.ClientKey{Model: gorm.Model{ID: 7}, Name: "app", KeyLimits: limits}
+	rotated := &models.ClientKey{Model: gorm.Model{ID: 9}, Name: "app", RootID: 7, KeyLimits: limits}
+
+	first, second := ClientKeyLimits(original), ClientKeyLimits(rotated)
+	if first.Scope != "client_key:7" {
+		t.Errorf("original scope = %q, want client_key:7", first.Scope)
+	}
+	if second.Scope != first.Scope {
+		t.Errorf("rotated scope = %q, want %q", second.Scope, first.Scope)
+	}
+	if second.ID != 9 || second.Type != QuotaTargetClientKey {
+		t.Errorf("rotated key = {ID: %d, Type: %s}, want {ID: 9, Type: %s}", second.ID, second.Type, QuotaTargetClientKey)
+	}
+	if second.Limits != limits {
+		t.Errorf("rotated limits = %+v, want %+v", second.Limits, limits)
+	}
+}
+
+// setClientKeys 用给定的 Key 替换客户端 Key 缓存，测试结束后恢复
+func setClientKeys(t *testing.T, keys ...*models.ClientKey) {
+	t.Helper()
+	clientKeysMux.Lock()
+	saved := clientKeys
+	clientKeys = make(map[string][]*models.ClientKey)
+	for _, key := range keys {
+		clientKeys[key.Prefix] = append(clientKeys[key.Prefix], key)
+	}
+	clientKeysMux.Unlock()
+	t.Cleanup(func() {
+		clientKeysMux.Lock()
+		clientKeys = saved
+		clientKeysMux.Unlock()
+	})
+}
+
+// newTestClientKey 生成一个只能访问 endpointID 的客户端 Key，返回 Key 与其明文
+func newTestClientKey(t *testing.T, id uint, endpointID uint, scopes ...string) (*models.ClientKey, string) {
+	t.Helper()
+	plaintext, err := GenerateClientKey()
+	if err != nil {
+		t.Fatalf("GenerateClientKey: %v", err)
+	}
+	return &models.ClientKey{
+		Model:     gorm.Model{ID: id},
+		Prefix:    models.ClientKeyPrefix(plaintext),
+		KeyHash:   models.HashClientKey(plaintext),
+		Scopes:    scopes,
+		Endpoints: []models.APIEndpoint{{Model: gorm.Model{ID: endpointID}}},
+	}, plaintext
+}
+
+func TestAuthenticateClientKeyRotationGrace(t *testing.T) {
+	oldKey, oldPlaintext := newTestClientKey(t, 1, 5, models.ClientKeyScopeInvoke)
+	newKey, newPlaintext := newTestClientKey(t, 2, 5, models.ClientKeyScopeInvoke)
+	newKey.RootID = oldKey.ID
+	oldKey.ReplacedByID = newKey.ID
+	graceEnd := time.Now().Add(time.Hour)
+	oldKey.ExpiresAt = &graceEnd
+	setClientKeys(t, oldKey, newKey)
+
+	// 宽限期内新旧 Key 都有效
+	for name, plaintext := range map[string]string{"old": oldPlaintext, "new": newPlaintext} {
+		if _, err := AuthenticateClientKey(plaintext, 5, models.ClientKeyScopeInvoke); err != nil {
+			t.Errorf("%s key during grace period: %v", name, err)
+		}
+	}
+
+	// 宽限期结束后旧 Key 失效
+	graceEnd = time.Now().Add(-time.Second)
+	if _, err := AuthenticateClientKey(oldPlaintext, 5, models.ClientKeyScopeInvoke); !errors.Is(err, ErrClientKeyInvalid) {
+		t.Errorf("old key after grace period: err = %v, want ErrClientKeyInvalid", err)
+	}
+	matched, err := AuthenticateClientKey(newPlaintext, 5, models.ClientKeyScopeInvoke)
+	if err != nil {
+		t.Fatalf("new key after grace period: %v", err)
+	}
+	if matched.ID != newKey.ID {
+		t.Errorf("matched key %d, want %d", matched.ID, newKey.ID)
+	}
+}
+
+func TestAuthenticateClientKeyErrors(t *testing.T) {
+	key, plaintext := newTestClientKey(t, 1, 5, models.ClientKeyScopeInvoke)
+	revoked, revokedPlaintext := newTestClientKey(t, 2, 5, models.ClientKeyScopeInvoke)
+	revoked.Revoked = true
+	setClientKeys(t, key, revoked)
+
+	tests := []struct {
+		name       string
+		key        string
+		endpointID uint
+		scope      string
+		want       error
+	}{
+		{"valid", plaintext, 5, models.ClientKeyScopeInvoke, nil},
+		{"empty key", "", 5, models.ClientKeyScopeInvoke, ErrClientKeyInvalid},
+		{"unknown key", plaintext[:len(plaintext)-1] + "x", 5, models.ClientKeyScopeInvoke, ErrClientKeyInvalid},
+		{"revoked", revokedPlaintext, 5, models.ClientKeyScopeInvoke, ErrClientKeyInvalid},
+		{"other endpoint", plaintext, 6, models.ClientKeyScopeInvoke, ErrClientKeyEndpoint},
+		{"missing scope", plaintext, 5, models.ClientKeyScopeSessions, ErrClientKeyForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := AuthenticateClientKey(tt.key, tt.endpointID, tt.scope)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,388 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// JSONSchema 是编译后的 JSON Schema，用于校验结构化输出。
+// 支持常用的校验关键字：type、enum、const、properties、required、additionalProperties、items、
+// minItems/maxItems、minLength/maxLength、pattern、minimum/maximum、exclusiveMinimum/exclusiveMaximum、
+// allOf/anyOf/oneOf/not 以及指向文档内部的 $ref（如 #/$defs/name）。其余关键字会被忽略。
+type JSONSchema struct {
+	root     interface{}
+	patterns map[string]*regexp.Regexp
+}
+
+// ErrInvalidOutput 表示模型的回复不符合 API 路径配置的 JSON Schema。
+// 上游调用本身是成功的，不计入熔断器与上游 Key 的失败。
+var ErrInvalidOutput = errors.New("invalid structured output")
+
+var schemaTypes = map[string]bool{
+	"object": true, "array": true, "string": true, "number": true,
+	"integer": true, "boolean": true, "null": true,
+}
+
+// CompileJSONSchema 解析并检查 JSON Schema，Schema 必须是 JSON 对象
+func CompileJSONSchema(text string) (*JSONSchema, error) {
+	var root interface{}
+	if err := json.Unmarshal([]byte(text), &root); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %v", err)
+	}
+	if _, ok := root.(map[string]interface{}); !ok {
+		return nil, errors.New("schema must be a JSON object")
+	}
+
+	schema := &JSONSchema{root: root, patterns: make(map[string]*regexp.Regexp)}
+	if err := schema.check(root, "#"); err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+// Raw 返回 Schema 的 JSON
+func (s *JSONSchema) Raw() json.RawMessage {
+	raw, _ := json.Marshal(s.root)
+	return raw
+}
+
+// check 递归检查 Schema 中的关键字是否合法，并预编译 pattern
+func (s *JSONSchema) check(node interface{}, path string) error {
+	if _, ok := node.(bool); ok {
+		return nil
+	}
+	obj, ok := node.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("%s: schema must be an object or boolean", path)
+	}
+
+	switch t := obj["type"].(type) {
+	case nil:
+	case string:
+		if !schemaTypes[t] {
+			return fmt.Errorf("%s/type: unknown type %q", path, t)
+		}
+	case []interface{}:
+		for _, item := range t {
+			if name, ok := item.(string); !ok || !schemaTypes[name] {
+				return fmt.Errorf("%s/type: unknown type %v", path, item)
+			}
+		}
+	default:
+		return fmt.Errorf("%s/type: must be a string or an array of strings", path)
+	}
+
+	if ref, ok := obj["$ref"].(string); ok {
+		if _, err := s.resolve(ref); err != nil {
+			return fmt.Errorf("%s/$ref: %v", path, err)
+		}
+	}
+	if pattern, ok := obj["pattern"].(string); ok {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("%s/pattern: %v", path, err)
+		}
+		s.patterns[pattern] = re
+	}
+
+	for _, key := range []string{"properties", "$defs", "definitions"} {
+		if children, ok := obj[key].(map[string]interface{}); ok {
+			for name, child := range children {
+				if err := s.check(child, path+"/"+key+"/"+name); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for _, key := range []string{"items", "additionalProperties", "not"} {
+		if child, ok := obj[key]; ok {
+			if err := s.check(child, path+"/"+key); err != nil {
+				return err
+			}
+		}
+	}
+	for _, key := range []string{"allOf", "anyOf", "oneOf"} {
+		if list, ok := obj[key].([]interface{}); ok {
+			for i, child := range list {
+				if err := s.check(child, fmt.Sprintf("%s/%s/%d", path, key, i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// resolve 解析文档内部的 JSON Pointer 引用
+func (s *JSONSchema) resolve(ref string) (interface{}, error) {
+	if ref == "#" {
+		return s.root, nil
+	}
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("only local references are supported: %s", ref)
+	}
+	node := s.root
+	for _, token := range strings.Split(ref[2:], "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		obj, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("unresolvable reference: %s", ref)
+		}
+		if node, ok = obj[token]; !ok {
+			return nil, fmt.Errorf("unresolvable reference: %s", ref)
+		}
+	}
+	return node, nil
+}
+
+// Validate 校验 JSON 值（encoding/json 解码得到的值），返回第一个不符合 Schema 的位置与原因
+func (s *JSONSchema) Validate(value interface{}) error {
+	return s.validate(s.root, value, "$", 0)
+}
+
+// maxSchemaDepth 限制 $ref 递归的深度，避免循环引用导致栈溢出
+const maxSchemaDepth = 64
+
+func (s *JSONSchema) validate(node, value interface{}, path string, depth int) error {
+	if depth > maxSchemaDepth {
+		return fmt.Errorf("%s: schema nesting too deep", path)
+	}
+	if allow, ok := node.(bool); ok {
+		if !allow {
+			return fmt.Errorf("%s: value is not allowed", path)
+		}
+		return nil
+	}
+	obj, _ := node.(map[string]interface{})
+
+	if ref, ok := obj["$ref"].(string); ok {
+		target, err := s.resolve(ref)
+		if err != nil {
+			return err
+		}
+		if err := s.validate(target, value, path, depth+1); err != nil {
+			return err
+		}
+	}
+
+	if t, ok := obj["type"]; ok && !matchesType(t, value) {
+		return fmt.Errorf("%s: expected %s, got %s", path, typeNames(t), jsonTypeName(value))
+	}
+	if enum, ok := obj["enum"].([]interface{}); ok {
+		found := false
+		for _, option := range enum {
+			if reflect.DeepEqual(option, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: value must be one of %s", path, compactJSON(enum))
+		}
+	}
+	if constant, ok := obj["const"]; ok && !reflect.DeepEqual(constant, value) {
+		return fmt.Errorf("%s: value must be %s", path, compactJSON(constant))
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if err := s.validateObject(obj, v, path, depth); err != nil {
+			return err
+		}
+	case []interface{}:
+		if min, ok := number(obj["minItems"]); ok && float64(len(v)) < min {
+			return fmt.Errorf("%s: expected at least %v items", path, min)
+		}
+		if max, ok := number(obj["maxItems"]); ok && float64(len(v)) > max {
+			return fmt.Errorf("%s: expected at most %v items", path, max)
+		}
+		if items, ok := obj["items"]; ok {
+			for i, item := range v {
+				if err := s.validate(items, item, fmt.Sprintf("%s[%d]", path, i), depth+1); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		length := float64(utf8.RuneCountInString(v))
+		if min, ok := number(obj["minLength"]); ok && length < min {
+			return fmt.Errorf("%s: expected at least %v characters", path, min)
+		}
+		if max, ok := number(obj["maxLength"]); ok && length > max {
+			return fmt.Errorf("%s: expected at most %v characters", path, max)
+		}
+		if pattern, ok := obj["pattern"].(string); ok && s.patterns[pattern] != nil && !s.patterns[pattern].MatchString(v) {
+			return fmt.Errorf("%s: does not match pattern %q", path, pattern)
+		}
+	case float64:
+		if min, ok := number(obj["minimum"]); ok && v < min {
+			return fmt.Errorf("%s: must be >= %v", path, min)
+		}
+		if max, ok := number(obj["maximum"]); ok && v > max {
+			return fmt.Errorf("%s: must be <= %v", path, max)
+		}
+		if min, ok := number(obj["exclusiveMinimum"]); ok && v <= min {
+			return fmt.Errorf("%s: must be > %v", path, min)
+		}
+		if max, ok := number(obj["exclusiveMaximum"]); ok && v >= max {
+			return fmt.Errorf("%s: must be < %v", path, max)
+		}
+	}
+
+	return s.validateCombinators(obj, value, path, depth)
+}
+
+func (s *JSONSchema) validateObject(obj, value map[string]interface{}, path string, depth int) error {
+	if required, ok := obj["required"].([]interface{}); ok {
+		for _, name := range required {
+			if key, ok := name.(string); ok {
+				if _, exists := value[key]; !exists {
+					return fmt.Errorf("%s: missing required property %q", path, key)
+				}
+			}
+		}
+	}
+
+	properties, _ := obj["properties"].(map[string]interface{})
+	additional, hasAdditional := obj["additionalProperties"]
+	// 按键名排序，保证报错信息稳定
+	keys := make([]string, 0, len(value))
+	for key := range value {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		childPath := path + "." + key
+		if child, ok := properties[key]; ok {
+			if err := s.validate(child, value[key], childPath, depth+1); err != nil {
+				return err
+			}
+			continue
+		}
+		if hasAdditional {
+			if allow, ok := additional.(bool); ok && !allow {
+				return fmt.Errorf("%s: additional property %q is not allowed", path, key)
+			}
+			if err := s.validate(additional, value[key], childPath, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *JSONSchema) validateCombinators(obj map[string]interface{}, value interface{}, path string, depth int) error {
+	if list, ok := obj["allOf"].([]interface{}); ok {
+		for _, child := range list {
+			if err := s.validate(child, value, path, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	if list, ok := obj["anyOf"].([]interface{}); ok {
+		var firstErr error
+		matched := false
+		for _, child := range list {
+			err := s.validate(child, value, path, depth+1)
+			if err == nil {
+				matched = true
+				break
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		if !matched && firstErr != nil {
+			return fmt.Errorf("%s: does not match any schema in anyOf (%v)", path, firstErr)
+		}
+	}
+	if list, ok := obj["oneOf"].([]interface{}); ok {
+		matches := 0
+		for _, child := range list {
+			if s.validate(child, value, path, depth+1) == nil {
+				matches++
+			}
+		}
+		if matches != 1 {
+			return fmt.Errorf("%s: must match exactly one schema in oneOf, matched %d", path, matches)
+		}
+	}
+	if not, ok := obj["not"]; ok && s.validate(not, value, path, depth+1) == nil {
+		return fmt.Errorf("%s: must not match the schema in not", path)
+	}
+	return nil
+}
+
+// matchesType 判断值是否符合 type 关键字（字符串或字符串数组）
+func matchesType(t interface{}, value interface{}) bool {
+	switch t := t.(type) {
+	case string:
+		return matchesTypeName(t, value)
+	case []interface{}:
+		for _, item := range t {
+			if name, ok := item.(string); ok && matchesTypeName(name, value) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func matchesTypeName(name string, value interface{}) bool {
+	switch name {
+	case "integer":
+		f, ok := value.(float64)
+		return ok && f == math.Trunc(f)
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	}
+	return jsonTypeName(value) == name
+}
+
+func jsonTypeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func typeNames(t interface{}) string {
+	if list, ok := t.([]interface{}); ok {
+		names := make([]string, 0, len(list))
+		for _, item := range list {
+			names = append(names, fmt.Sprint(item))
+		}
+		return strings.Join(names, " or ")
+	}
+	return fmt.Sprint(t)
+}
+
+func number(v interface{}) (float64, bool) {
+	f, ok := v.(float64)
+	return f, ok
+}
+
+func compactJSON(v interface{}) string {
+	raw, _ := json.Marshal(v)
+	return string(raw)
+}
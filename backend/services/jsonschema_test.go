@@ -0,0 +1,119 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestJSONSchemaValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		schema   string
+		instance string
+		valid    bool
+	}{
+		{"string type", `{"type": "string"}`, `"hello"`, true},
+		{"string type mismatch", `{"type": "string"}`, `1`, false},
+		{"integer accepts whole number", `{"type": "integer"}`, `3`, true},
+		{"integer rejects fraction", `{"type": "integer"}`, `3.5`, false},
+		{"number accepts fraction", `{"type": "number"}`, `3.5`, true},
+		{"boolean", `{"type": "boolean"}`, `false`, true},
+		{"null", `{"type": "null"}`, `null`, true},
+		{"type list", `{"type": ["string", "null"]}`, `null`, true},
+		{"type list mismatch", `{"type": ["string", "null"]}`, `{}`, false},
+
+		{"required present", `{"type": "object", "required": ["a"]}`, `{"a": 1}`, true},
+		{"required missing", `{"type": "object", "required": ["a"]}`, `{"b": 1}`, false},
+
+		{"enum match", `{"enum": ["red", "green", 3]}`, `"green"`, true},
+		{"enum numeric match", `{"enum": ["red", "green", 3]}`, `3`, true},
+		{"enum mismatch", `{"enum": ["red", "green"]}`, `"blue"`, false},
+
+		{
+			"nested object valid",
+			`{"type": "object", "properties": {"user": {"type": "object", "properties": {"age": {"type": "integer"}}, "required": ["age"]}}}`,
+			`{"user": {"age": 30}}`,
+			true,
+		},
+		{
+			"nested object wrong type",
+			`{"type": "object", "properties": {"user": {"type": "object", "properties": {"age": {"type": "integer"}}, "required": ["age"]}}}`,
+			`{"user": {"age": "thirty"}}`,
+			false,
+		},
+		{
+			"nested object missing required",
+			`{"type": "object", "properties": {"user": {"type": "object", "properties": {"age": {"type": "integer"}}, "required": ["age"]}}}`,
+			`{"user": {}}`,
+			false,
+		},
+
+		{"array items valid", `{"type": "array", "items": {"type": "string"}}`, `["a", "b"]`, true},
+		{"array items invalid", `{"type": "array", "items": {"type": "string"}}`, `["a", 1]`, false},
+		{
+			"array of objects invalid element",
+			`{"type": "array", "items": {"type": "object", "required": ["id"]}}`,
+			`[{"id": 1}, {"name": "x"}]`,
+			false,
+		},
+		{"array minItems", `{"type": "array", "minItems": 2}`, `[1]`, false},
+		{"array maxItems", `{"type": "array", "maxItems": 1}`, `[1, 2]`, false},
+
+		{
+			"additionalProperties false allows declared",
+			`{"type": "object", "properties": {"a": {"type": "string"}}, "additionalProperties": false}`,
+			`{"a": "x"}`,
+			true,
+		},
+		{
+			"additionalProperties false rejects extra",
+			`{"type": "object", "properties": {"a": {"type": "string"}}, "additionalProperties": false}`,
+			`{"a": "x", "b": 1}`,
+			false,
+		},
+		{
+			"additionalProperties schema",
+			`{"type": "object", "additionalProperties": {"type": "integer"}}`,
+			`{"a": 1, "b": "two"}`,
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema, err := CompileJSONSchema(tt.schema)
+			if err != nil {
+				t.Fatalf("CompileJSONSchema: %v", err)
+			}
+			var instance interface{}
+			if err := json.Unmarshal([]byte(tt.instance), &instance); err != nil {
+				t.Fatalf("invalid test instance: %v", err)
+			}
+			err = schema.Validate(instance)
+			if tt.valid && err != nil {
+				t.Errorf("Validate(%s) = %v, want valid", tt.instance, err)
+			}
+			if !tt.valid && err == nil {
+				t.Errorf("Validate(%s) = nil, want error", tt.instance)
+			}
+		})
+	}
+}
+
+func TestCompileJSONSchemaRejectsInvalidSchema(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+	}{
+		{"not JSON", `{`},
+		{"not an object", `[]`},
+		{"unknown type", `{"type": "text"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := CompileJSONSchema(tt.schema); err == nil {
+				t.Errorf("CompileJSONSchema(%s) = nil error, want error", tt.schema)
+			}
+		})
+	}
+}
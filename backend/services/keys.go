@@ -0,0 +1,177 @@
+package services
+
+import (
+	"ai-api-platform/backend/models"
+	"ai-api-platform/backend/providers"
+	"ai-api-platform/backend/utils"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrNoHealthyKey 表示供应商配置了 Key 池但当前没有可用的 Key
+var ErrNoHealthyKey = errors.New("no healthy API key available for provider")
+
+var (
+	providerKeys    map[uint][]*models.ProviderKey // 供应商ID -> Key 池
+	providerKeyNext map[uint]int                   // 供应商ID -> 下一次轮换的起始位置
+	providerKeysMux sync.Mutex
+)
+
+// InitProviderKeys 加载所有供应商的 Key 池
+func InitProviderKeys() error {
+	var keys []models.ProviderKey
+	if err := models.DB.Order("id ASC").Find(&keys).Error; err != nil {
+		return err
+	}
+
+	providerKeysMux.Lock()
+	defer providerKeysMux.Unlock()
+
+	providerKeys = make(map[uint][]*models.ProviderKey)
+	providerKeyNext = make(map[uint]int)
+	for i := range keys {
+		providerKeys[keys[i].ProviderID] = append(providerKeys[keys[i].ProviderID], &keys[i])
+	}
+	return nil
+}
+
+// ReloadProviderKeys 重新加载指定供应商的 Key 池
+func ReloadProviderKeys(providerID uint) error {
+	var keys []models.ProviderKey
+	if err := models.DB.Where("provider_id = ?", providerID).Order("id ASC").Find(&keys).Error; err != nil {
+		return err
+	}
+
+	providerKeysMux.Lock()
+	defer providerKeysMux.Unlock()
+
+	pool := make([]*models.ProviderKey, 0, len(keys))
+	for i := range keys {
+		pool = append(pool, &keys[i])
+	}
+	if len(pool) == 0 {
+		delete(providerKeys, providerID)
+		return nil
+	}
+	providerKeys[providerID] = pool
+	return nil
+}
+
+// GetProviderKeys 返回指定供应商 Key 池的快照（包含内存中的最新健康状态）
+func GetProviderKeys(providerID uint) []models.ProviderKey {
+	providerKeysMux.Lock()
+	defer providerKeysMux.Unlock()
+
+	pool := providerKeys[providerID]
+	result := make([]models.ProviderKey, 0, len(pool))
+	for _, key := range pool {
+		result = append(result, *key)
+	}
+	return result
+}
+
+// PickProviderKey 轮换选择一个健康的 Key。
+// 供应商没有配置 Key 池时返回 nil，由调用方使用 AIProvider.APIKey。
+func PickProviderKey(providerID uint) (*models.ProviderKey, error) {
+	providerKeysMux.Lock()
+	defer providerKeysMux.Unlock()
+
+	pool := providerKeys[providerID]
+	if len(pool) == 0 {
+		return nil, nil
+	}
+
+	now := time.Now()
+	start := providerKeyNext[providerID]
+	for i := 0; i < len(pool); i++ {
+		index := (start + i) % len(pool)
+		key := pool[index]
+		if key.Status != models.ProviderKeyActive {
+			continue
+		}
+		if key.CooldownUntil != nil && now.Before(*key.CooldownUntil) {
+			continue
+		}
+		providerKeyNext[providerID] = index + 1
+		snapshot := *key
+		return &snapshot, nil
+	}
+	return nil, ErrNoHealthyKey
+}
+
+// ReportKeyResult 记录一次调用结果：401/403/429 会让 Key 暂停使用一段时间，成功调用会清零失败次数。
+// 没有调用上游（适配器不支持请求功能）或回复不符合 JSON Schema 的错误与 Key 无关，不做记录。
+// 缓存中的 Key 在持有锁时更新，释放锁后再写入数据库，避免数据库写入阻塞其他请求选择 Key。
+func ReportKeyResult(key *models.ProviderKey, err error) {
+	if key == nil || errors.Is(err, providers.ErrUnsupportedFeature) || errors.Is(err, ErrInvalidOutput) {
+		return
+	}
+	if updates := updateCachedKey(key, err); len(updates) > 0 {
+		models.DB.Model(&models.ProviderKey{}).Where("id = ?", key.ID).Updates(updates)
+	}
+}
+
+// updateCachedKey 按调用结果更新缓存中的 Key，返回需要写入数据库的字段，无需写入时返回 nil
+func updateCachedKey(key *models.ProviderKey, err error) map[string]interface{} {
+	providerKeysMux.Lock()
+	defer providerKeysMux.Unlock()
+
+	var cached *models.ProviderKey
+	for _, k := range providerKeys[key.ProviderID] {
+		if k.ID == key.ID {
+			cached = k
+			break
+		}
+	}
+	if cached == nil {
+		return nil
+	}
+
+	if err == nil {
+		if cached.FailureCount > 0 {
+			cached.FailureCount = 0
+			return map[string]interface{}{"failure_count": 0}
+		}
+		return nil
+	}
+
+	now := time.Now()
+	cached.FailureCount++
+	cached.LastError = err.Error()
+	cached.LastErrorAt = &now
+	updates := map[string]interface{}{
+		"failure_count": cached.FailureCount,
+		"last_error":    cached.LastError,
+		"last_error_at": now,
+	}
+
+	if cooldown := keyCooldown(err); cooldown > 0 {
+		until := now.Add(cooldown)
+		cached.CooldownUntil = &until
+		updates["cooldown_until"] = until
+	}
+	return updates
+}
+
+// keyCooldown 根据上游错误决定 Key 的暂停时间，0 表示无需暂停
+func keyCooldown(err error) time.Duration {
+	config := utils.GlobalConfig.ProviderKeys
+	switch providers.StatusCode(err) {
+	case http.StatusTooManyRequests:
+		if retryAfter := providers.RetryAfter(err); retryAfter > 0 {
+			return retryAfter
+		}
+		if config.RateLimitCooldown > 0 {
+			return time.Duration(config.RateLimitCooldown) * time.Second
+		}
+		return time.Minute
+	case http.StatusUnauthorized, http.StatusForbidden:
+		if config.AuthErrorCooldown > 0 {
+			return time.Duration(config.AuthErrorCooldown) * time.Second
+		}
+		return 30 * time.Minute
+	}
+	return 0
+}
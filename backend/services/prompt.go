@@ -0,0 +1,87 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// MissingVariableError 表示提示词模板引用了请求中没有提供的变量
+type MissingVariableError struct {
+	Name string
+}
+
+func (e *MissingVariableError) Error() string {
+	return fmt.Sprintf("missing template variable: %s", e.Name)
+}
+
+// missingKeyPattern 匹配 text/template 在 missingkey=error 下对 map 缺少键的报错
+var missingKeyPattern = regexp.MustCompile(`map has no entry for key "([^"]*)"`)
+
+// PromptRenderError 表示以请求中的 variables 渲染提示词模板失败，如变量的类型与模板中的用法不符
+type PromptRenderError struct {
+	Err error
+}
+
+func (e *PromptRenderError) Error() string {
+	return "failed to render prompt template: " + e.Err.Error()
+}
+
+func (e *PromptRenderError) Unwrap() error { return e.Err }
+
+// PromptContext 是渲染提示词模板时可用的内置数据
+type PromptContext struct {
+	Path    string // API 路径
+	Content string // 请求中的用户输入
+}
+
+// promptFuncs 返回模板中可用的内置函数。解析时只需要函数签名，渲染时绑定本次请求的数据。
+func promptFuncs(ctx PromptContext) template.FuncMap {
+	return template.FuncMap{
+		"now":     time.Now,
+		"date":    func() string { return time.Now().Format("2006-01-02") },
+		"path":    func() string { return ctx.Path },
+		"content": func() string { return ctx.Content },
+	}
+}
+
+func parsePrompt(text string, ctx PromptContext) (*template.Template, error) {
+	return template.New("prompt").Funcs(promptFuncs(ctx)).Option("missingkey=error").Parse(text)
+}
+
+// ValidatePromptTemplate 检查提示词模板的语法，供创建和修改 API 路径时调用
+func ValidatePromptTemplate(text string) error {
+	if !strings.Contains(text, "{{") {
+		return nil
+	}
+	_, err := parsePrompt(text, PromptContext{})
+	return err
+}
+
+// RenderPrompt 以请求中的 variables 渲染提示词模板。
+// 模板中通过 {{.name}} 引用变量，通过 {{date}}、{{now}}、{{path}}、{{content}} 使用内置数据；
+// 引用了未提供的变量时返回 *MissingVariableError，变量无法按模板渲染时返回 *PromptRenderError。
+func RenderPrompt(text string, variables map[string]interface{}, ctx PromptContext) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+	tmpl, err := parsePrompt(text, ctx)
+	if err != nil {
+		return "", err
+	}
+	if variables == nil {
+		variables = map[string]interface{}{}
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, variables); err != nil {
+		if match := missingKeyPattern.FindStringSubmatch(err.Error()); match != nil {
+			return "", &MissingVariableError{Name: match[1]}
+		}
+		return "", &PromptRenderError{Err: err}
+	}
+	return buf.String(), nil
+}
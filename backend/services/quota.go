@@ -0,0 +1,441 @@
+package services
+
+import (
+	"ai-api-platform/backend/models"
+	"ai-api-platform/backend/providers"
+	"ai-api-platform/backend/utils"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 配额周期
+const (
+	QuotaDaily   = "daily"
+	QuotaMonthly = "monthly"
+)
+
+// 配额的计量方式
+const (
+	QuotaTokens = "tokens"
+	QuotaCost   = "cost"
+)
+
+// 配额对象的类型
+const (
+	QuotaTargetEndpoint   = "endpoint"
+	QuotaTargetVirtualKey = "virtual_key"
+	QuotaTargetClientKey  = "client_key"
+)
+
+// quotaPeriods 是检查与统计配额的周期
+var quotaPeriods = []string{QuotaDaily, QuotaMonthly}
+
+// defaultQuotaWarnThresholds 是未配置时的警告阈值（配额的百分比）
+var defaultQuotaWarnThresholds = []int{80, 100}
+
+// quotaWebhookClient 发送配额警告通知
+var quotaWebhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// QuotaTarget 是需要统计用量并遵守配额的对象（API 路径、虚拟 Key 或客户端 Key）及其配额，配额为 0 表示不限制
+type QuotaTarget struct {
+	Scope         string // 用量记录的 Key，如 endpoint:1、key:1、client_key:1
+	Type          string // 见 QuotaTarget* 常量
+	ID            uint
+	Name          string // API 路径或 Key 的名称
+	DailyTokens   int64
+	MonthlyTokens int64
+	DailyCost     float64
+	MonthlyCost   float64
+}
+
+// limits 返回周期内的 Token 数与费用上限
+func (t QuotaTarget) limits(period string) (int64, float64) {
+	if period == QuotaDaily {
+		return t.DailyTokens, t.DailyCost
+	}
+	return t.MonthlyTokens, t.MonthlyCost
+}
+
+// hasQuota 返回是否配置了任意一项配额
+func (t QuotaTarget) hasQuota() bool {
+	return t.DailyTokens > 0 || t.MonthlyTokens > 0 || t.DailyCost > 0 || t.MonthlyCost > 0
+}
+
+// EndpointQuotaTarget 返回 API 路径的配额对象
+func EndpointQuotaTarget(endpoint *models.APIEndpoint) QuotaTarget {
+	return QuotaTarget{
+		Scope:         fmt.Sprintf("endpoint:%d", endpoint.ID),
+		Type:          QuotaTargetEndpoint,
+		ID:            endpoint.ID,
+		Name:          endpoint.Path,
+		DailyTokens:   endpoint.DailyTokenQuota,
+		MonthlyTokens: endpoint.MonthlyTokenQuota,
+		DailyCost:     endpoint.DailyCostQuota,
+		MonthlyCost:   endpoint.MonthlyCostQuota,
+	}
+}
+
+// LimitedKey 是调用方使用的、需要遵守自身限流与配额的 Key（虚拟 Key 或客户端 Key）
+type LimitedKey struct {
+	Scope  string // 限流桶与用量记录的 Key，如 key:1、client_key:1
+	Type   string // 见 QuotaTarget* 常量
+	ID     uint
+	Name   string
+	Limits models.KeyLimits
+}
+
+// VirtualKeyLimits 返回虚拟 Key 的限流与配额
+func VirtualKeyLimits(key *models.VirtualKey) *LimitedKey {
+	return &LimitedKey{Scope: fmt.Sprintf("key:%d", key.ID), Type: QuotaTargetVirtualKey, ID: key.ID, Name: key.Name, Limits: key.KeyLimits}
+}
+
+// ClientKeyLimits 返回客户端 Key 的限流与配额，轮换出的新 Key 与宽限期内的旧 Key 共用最初的 Key 的额度
+func ClientKeyLimits(key *models.ClientKey) *LimitedKey {
+	return &LimitedKey{Scope: fmt.Sprintf("client_key:%d", ClientKeyRootID(key)), Type: QuotaTargetClientKey, ID: key.ID, Name: key.Name, Limits: key.KeyLimits}
+}
+
+// KeyQuotaTarget 返回 Key 的配额对象
+func KeyQuotaTarget(key *LimitedKey) QuotaTarget {
+	return QuotaTarget{
+		Scope:         key.Scope,
+		Type:          key.Type,
+		ID:            key.ID,
+		Name:          key.Name,
+		DailyTokens:   key.Limits.DailyTokenQuota,
+		MonthlyTokens: key.Limits.MonthlyTokenQuota,
+		DailyCost:     key.Limits.DailyCostQuota,
+		MonthlyCost:   key.Limits.MonthlyCostQuota,
+	}
+}
+
+// EndpointQuotaTargets 返回一次调用需要统计用量的 API 路径与调用方的 Key（可为空）。
+// 未配置配额的对象同样统计用量，之后配置的配额从当前周期的已用量开始计算。
+func EndpointQuotaTargets(endpoint *models.APIEndpoint, key *LimitedKey) []QuotaTarget {
+	targets := []QuotaTarget{EndpointQuotaTarget(endpoint)}
+	if key != nil {
+		targets = append(targets, KeyQuotaTarget(key))
+	}
+	return targets
+}
+
+// quotaPeriodKey 返回 now 所在周期的标识：按天为 YYYY-MM-DD，按月为 YYYY-MM
+func quotaPeriodKey(period string, now time.Time) string {
+	if period == QuotaDaily {
+		return now.Format("2006-01-02")
+	}
+	return now.Format("2006-01")
+}
+
+// quotaResetAt 返回 now 所在周期的结束时间，即用量清零的时间
+func quotaResetAt(period string, now time.Time) time.Time {
+	year, month, day := now.Date()
+	if period == QuotaDaily {
+		return time.Date(year, month, day+1, 0, 0, 0, 0, now.Location())
+	}
+	return time.Date(year, month+1, 1, 0, 0, 0, 0, now.Location())
+}
+
+// UsageCost 按 pricing 配置计算一次调用的费用（美元），未配置价格的模型返回 0
+func UsageCost(model string, usage providers.Usage) float64 {
+	price, ok := utils.GlobalConfig.Pricing[model]
+	if !ok {
+		return 0
+	}
+	cachedPrice := price.CachedInput
+	if cachedPrice <= 0 {
+		cachedPrice = price.Input
+	}
+	cost := float64(usage.PromptTokens-usage.CachedTokens)*price.Input +
+		float64(usage.CachedTokens)*cachedPrice +
+		float64(usage.CompletionTokens)*price.Output
+	return cost / 1e6
+}
+
+// QuotaExceededError 表示配额对象在当前周期的某项配额已用尽
+type QuotaExceededError struct {
+	Target  QuotaTarget
+	Period  string
+	Metric  string
+	ResetAt time.Time
+}
+
+func (e *QuotaExceededError) Error() string {
+	metric := "token"
+	if e.Metric == QuotaCost {
+		metric = "cost"
+	}
+	target := "endpoint"
+	switch e.Target.Type {
+	case QuotaTargetVirtualKey:
+		target = "virtual key"
+	case QuotaTargetClientKey:
+		target = "API key"
+	}
+	return fmt.Sprintf("%s %s quota of %s %q exhausted, resets at %s", e.Period, metric, target, e.Target.Name, e.ResetAt.Format(time.RFC3339))
+}
+
+// QuotaStatus 是配额对象在一个周期内的配额与用量，上限为 0 表示不限制，此时不返回剩余额度
+type QuotaStatus struct {
+	Period          string    `json:"period"` // daily 或 monthly
+	ResetAt         time.Time `json:"reset_at"`
+	TokensUsed      int64     `json:"tokens_used"`
+	TokenLimit      int64     `json:"token_limit"`
+	TokensRemaining *int64    `json:"tokens_remaining,omitempty"`
+	CostUsed        float64   `json:"cost_used"`
+	CostLimit       float64   `json:"cost_limit"`
+	CostRemaining   *float64  `json:"cost_remaining,omitempty"`
+}
+
+// QuotaOverview 是管理后台展示的一个配额对象
+type QuotaOverview struct {
+	Type   string        `json:"type"`
+	ID     uint          `json:"id"`
+	Name   string        `json:"name"`
+	Quotas []QuotaStatus `json:"quotas"`
+}
+
+// quotaAlert 是发送到 quota.webhook_url 的警告通知
+type quotaAlert struct {
+	Event     string    `json:"event"` // quota.warning，达到 100% 时为 quota.exhausted
+	Type      string    `json:"type"`  // endpoint、virtual_key 或 client_key
+	ID        uint      `json:"id"`
+	Name      string    `json:"name"`
+	Period    string    `json:"period"`     // daily 或 monthly
+	PeriodKey string    `json:"period_key"` // 周期标识，如 2026-01-02 或 2026-01
+	Metric    string    `json:"metric"`     // tokens 或 cost
+	Threshold int       `json:"threshold"`  // 达到的警告阈值（百分比）
+	Used      float64   `json:"used"`
+	Limit     float64   `json:"limit"`
+	ResetAt   time.Time `json:"reset_at"`
+}
+
+// loadQuotaUsage 从数据库读取配额对象在周期内的用量，还没有用量的周期返回零值
+func loadQuotaUsage(scope, periodKey string) (models.QuotaUsage, error) {
+	usage := models.QuotaUsage{Scope: scope, Period: periodKey}
+	err := models.DB.Where("scope = ? AND period = ?", scope, periodKey).Limit(1).Find(&usage).Error
+	return usage, err
+}
+
+// CheckQuotas 检查配额对象在当前周期的用量，返回第一个已用尽的配额（*QuotaExceededError）。
+// 用量保存在数据库中，多个实例共享；读取失败时记录日志并放行。
+func CheckQuotas(targets []QuotaTarget) error {
+	now := time.Now()
+	for _, target := range targets {
+		for _, period := range quotaPeriods {
+			tokenLimit, costLimit := target.limits(period)
+			if tokenLimit <= 0 && costLimit <= 0 {
+				continue
+			}
+			usage, err := loadQuotaUsage(target.Scope, quotaPeriodKey(period, now))
+			if err != nil {
+				log.Printf("check quota %s: %v", target.Scope, err)
+				continue
+			}
+			if tokenLimit > 0 && usage.Tokens >= tokenLimit {
+				return &QuotaExceededError{Target: target, Period: period, Metric: QuotaTokens, ResetAt: quotaResetAt(period, now)}
+			}
+			if costLimit > 0 && usage.Cost >= costLimit {
+				return &QuotaExceededError{Target: target, Period: period, Metric: QuotaCost, ResetAt: quotaResetAt(period, now)}
+			}
+		}
+	}
+	return nil
+}
+
+// ChargeQuotas 把一次调用的 Token 数与费用以原子增量计入配额对象的每日与每月用量，
+// 用量达到新的警告阈值时异步发送通知
+func ChargeQuotas(targets []QuotaTarget, tokens int64, cost float64) {
+	if tokens <= 0 && cost <= 0 {
+		return
+	}
+
+	now := time.Now()
+	for _, target := range targets {
+		for _, period := range quotaPeriods {
+			periodKey := quotaPeriodKey(period, now)
+			err := models.DB.Clauses(clause.OnConflict{
+				Columns: []clause.Column{{Name: "scope"}, {Name: "period"}},
+				DoUpdates: clause.Assignments(map[string]interface{}{
+					"tokens":     gorm.Expr("tokens + ?", tokens),
+					"cost":       gorm.Expr("cost + ?", cost),
+					"updated_at": now,
+				}),
+			}).Create(&models.QuotaUsage{Scope: target.Scope, Period: periodKey, Tokens: tokens, Cost: cost, UpdatedAt: now}).Error
+			if err != nil {
+				log.Printf("charge quota %s %s: %v", target.Scope, periodKey, err)
+				continue
+			}
+
+			tokenLimit, costLimit := target.limits(period)
+			if tokenLimit <= 0 && costLimit <= 0 {
+				continue
+			}
+			usage, err := loadQuotaUsage(target.Scope, periodKey)
+			if err != nil {
+				log.Printf("check quota alert %s %s: %v", target.Scope, periodKey, err)
+				continue
+			}
+			alert := quotaAlert{
+				Type:      target.Type,
+				ID:        target.ID,
+				Name:      target.Name,
+				Period:    period,
+				PeriodKey: periodKey,
+				ResetAt:   quotaResetAt(period, now),
+			}
+			if tokenLimit > 0 {
+				if threshold := crossedQuotaThreshold(float64(usage.Tokens), float64(tokenLimit), usage.TokensWarned); threshold > 0 && claimQuotaAlert(usage, "tokens_warned", threshold) {
+					alert.Metric, alert.Threshold, alert.Used, alert.Limit = QuotaTokens, threshold, float64(usage.Tokens), float64(tokenLimit)
+					go sendQuotaAlert(alert)
+				}
+			}
+			if costLimit > 0 {
+				if threshold := crossedQuotaThreshold(usage.Cost, costLimit, usage.CostWarned); threshold > 0 && claimQuotaAlert(usage, "cost_warned", threshold) {
+					alert.Metric, alert.Threshold, alert.Used, alert.Limit = QuotaCost, threshold, usage.Cost, costLimit
+					go sendQuotaAlert(alert)
+				}
+			}
+		}
+	}
+}
+
+// claimQuotaAlert 把用量记录上已通知的阈值提高到 threshold，返回是否由本次调用提高（即是否需要发送通知），
+// 保证多个实例同时越过同一阈值时只通知一次
+func claimQuotaAlert(usage models.QuotaUsage, column string, threshold int) bool {
+	result := models.DB.Model(&models.QuotaUsage{}).
+		Where("id = ? AND "+column+" < ?", usage.ID, threshold).
+		Update(column, threshold)
+	if result.Error != nil {
+		log.Printf("claim quota alert %s %s: %v", usage.Scope, usage.Period, result.Error)
+		return false
+	}
+	return result.RowsAffected == 1
+}
+
+// crossedQuotaThreshold 返回用量达到的、高于 warned 的最高警告阈值，没有时返回 0
+func crossedQuotaThreshold(used, limit float64, warned int) int {
+	thresholds := utils.GlobalConfig.Quota.WarnThresholds
+	if len(thresholds) == 0 {
+		thresholds = defaultQuotaWarnThresholds
+	}
+	percent := used / limit * 100
+	crossed := 0
+	for _, threshold := range thresholds {
+		if threshold > warned && threshold > crossed && percent >= float64(threshold) {
+			crossed = threshold
+		}
+	}
+	return crossed
+}
+
+// sendQuotaAlert 把配额警告 POST 到 quota.webhook_url，失败时只记录日志
+func sendQuotaAlert(alert quotaAlert) {
+	alert.Event = "quota.warning"
+	if alert.Used >= alert.Limit {
+		alert.Event = "quota.exhausted"
+	}
+	log.Printf("%s: %s %q %s %s %.2f%% (%v/%v)", alert.Event, alert.Type, alert.Name, alert.Period, alert.Metric, alert.Used/alert.Limit*100, alert.Used, alert.Limit)
+
+	webhookURL := utils.GlobalConfig.Quota.WebhookURL
+	if webhookURL == "" {
+		return
+	}
+	payload, _ := json.Marshal(alert)
+	resp, err := quotaWebhookClient.Post(webhookURL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		log.Printf("send quota alert: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		log.Printf("send quota alert: webhook returned HTTP %d", resp.StatusCode)
+	}
+}
+
+// QuotaStatuses 返回配额对象在当前每日与每月周期的配额与用量
+func QuotaStatuses(target QuotaTarget) []QuotaStatus {
+	now := time.Now()
+	statuses := make([]QuotaStatus, 0, len(quotaPeriods))
+	for _, period := range quotaPeriods {
+		tokenLimit, costLimit := target.limits(period)
+		status := QuotaStatus{
+			Period:     period,
+			ResetAt:    quotaResetAt(period, now),
+			TokenLimit: tokenLimit,
+			CostLimit:  costLimit,
+		}
+		usage, err := loadQuotaUsage(target.Scope, quotaPeriodKey(period, now))
+		if err != nil {
+			log.Printf("load quota usage %s: %v", target.Scope, err)
+		}
+		status.TokensUsed, status.CostUsed = usage.Tokens, usage.Cost
+		if tokenLimit > 0 {
+			remaining := max(tokenLimit-status.TokensUsed, 0)
+			status.TokensRemaining = &remaining
+		}
+		if costLimit > 0 {
+			remaining := max(costLimit-status.CostUsed, 0)
+			status.CostRemaining = &remaining
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// QuotaOverviews 返回配置了配额的 API 路径、虚拟 Key 与客户端 Key 及其当前用量。
+// 客户端 Key 只列出有效且没有被轮换替代的 Key，轮换链共用的额度只出现一次。
+func QuotaOverviews() []QuotaOverview {
+	var targets []QuotaTarget
+	for _, endpoint := range GetAllCachedEndpoints() {
+		if target := EndpointQuotaTarget(endpoint); target.hasQuota() {
+			targets = append(targets, target)
+		}
+	}
+	virtualKeysMux.RLock()
+	for _, candidates := range virtualKeys {
+		for _, key := range candidates {
+			if target := KeyQuotaTarget(VirtualKeyLimits(key)); target.hasQuota() {
+				targets = append(targets, target)
+			}
+		}
+	}
+	virtualKeysMux.RUnlock()
+	now := time.Now()
+	clientKeysMux.RLock()
+	for _, candidates := range clientKeys {
+		for _, key := range candidates {
+			if key.ReplacedByID != 0 || !ClientKeyActive(key, now) {
+				continue
+			}
+			if target := KeyQuotaTarget(ClientKeyLimits(key)); target.hasQuota() {
+				targets = append(targets, target)
+			}
+		}
+	}
+	clientKeysMux.RUnlock()
+
+	sort.Slice(targets, func(i, j int) bool {
+		if targets[i].Type != targets[j].Type {
+			return targets[i].Type < targets[j].Type
+		}
+		return targets[i].ID < targets[j].ID
+	})
+	overviews := make([]QuotaOverview, 0, len(targets))
+	for _, target := range targets {
+		overviews = append(overviews, QuotaOverview{
+			Type:   target.Type,
+			ID:     target.ID,
+			Name:   target.Name,
+			Quotas: QuotaStatuses(target),
+		})
+	}
+	return overviews
+}
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuotaPeriodKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		period string
+		now    time.Time
+		want   string
+	}{
+		{"daily", QuotaDaily, time.Date(2026, 3, 9, 15, 4, 5, 0, time.UTC), "2026-03-09"},
+		{"daily last second", QuotaDaily, time.Date(2026, 3, 9, 23, 59, 59, 999, time.UTC), "2026-03-09"},
+		{"daily next day", QuotaDaily, time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC), "2026-03-10"},
+		{"monthly", QuotaMonthly, time.Date(2026, 3, 31, 23, 59, 59, 0, time.UTC), "2026-03"},
+		{"monthly next month", QuotaMonthly, time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC), "2026-04"},
+		{"monthly new year", QuotaMonthly, time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC), "2027-01"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := quotaPeriodKey(tt.period, tt.now); got != tt.want {
+				t.Errorf("quotaPeriodKey(%s, %v) = %q, want %q", tt.period, tt.now, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuotaPeriodKeyUsesLocalDate(t *testing.T) {
+	// 周期按 now 所在时区的日期划分，UTC 16:00 在东八区已经是第二天
+	shanghai := time.FixedZone("UTC+8", 8*60*60)
+	now := time.Date(2026, 3, 31, 16, 0, 0, 0, time.UTC).In(shanghai)
+	if got := quotaPeriodKey(QuotaDaily, now); got != "2026-04-01" {
+		t.Errorf("daily key = %q, want 2026-04-01", got)
+	}
+	if got := quotaPeriodKey(QuotaMonthly, now); got != "2026-04" {
+		t.Errorf("monthly key = %q, want 2026-04", got)
+	}
+}
+
+func TestQuotaResetAt(t *testing.T) {
+	tests := []struct {
+		name   string
+		period string
+		now    time.Time
+		want   time.Time
+	}{
+		{"daily", QuotaDaily, time.Date(2026, 3, 9, 15, 4, 5, 0, time.UTC), time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC)},
+		{"daily at midnight", QuotaDaily, time.Date(2026, 3, 9, 0, 0, 0, 0, time.UTC), time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC)},
+		{"daily end of month", QuotaDaily, time.Date(2026, 4, 30, 12, 0, 0, 0, time.UTC), time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC)},
+		{"daily end of year", QuotaDaily, time.Date(2026, 12, 31, 23, 59, 59, 0, time.UTC), time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"daily leap day", QuotaDaily, time.Date(2028, 2, 28, 8, 0, 0, 0, time.UTC), time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"monthly", QuotaMonthly, time.Date(2026, 3, 9, 15, 4, 5, 0, time.UTC), time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"monthly on the 31st", QuotaMonthly, time.Date(2026, 1, 31, 10, 0, 0, 0, time.UTC), time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"monthly december", QuotaMonthly, time.Date(2026, 12, 15, 0, 0, 0, 0, time.UTC), time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := quotaResetAt(tt.period, tt.now)
+			if !got.Equal(tt.want) {
+				t.Errorf("quotaResetAt(%s, %v) = %v, want %v", tt.period, tt.now, got, tt.want)
+			}
+			// 重置时间属于下一个周期
+			if quotaPeriodKey(tt.period, got) == quotaPeriodKey(tt.period, tt.now) {
+				t.Errorf("reset time %v is in the same period as %v", got, tt.now)
+			}
+			if quotaPeriodKey(tt.period, got.Add(-time.Nanosecond)) != quotaPeriodKey(tt.period, tt.now) {
+				t.Errorf("instant before reset %v is not in the period of %v", got, tt.now)
+			}
+		})
+	}
+}
+
+func TestQuotaResetAtKeepsLocation(t *testing.T) {
+	shanghai := time.FixedZone("UTC+8", 8*60*60)
+	now := time.Date(2026, 3, 9, 23, 0, 0, 0, shanghai)
+	want := time.Date(2026, 3, 10, 0, 0, 0, 0, shanghai)
+	if got := quotaResetAt(QuotaDaily, now); !got.Equal(want) {
+		t.Errorf("quotaResetAt = %v, want %v", got, want)
+	}
+}
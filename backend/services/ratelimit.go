@@ -0,0 +1,334 @@
+package services
+
+import (
+	"ai-api-platform/backend/models"
+	"ai-api-platform/backend/utils"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 限流状态的存储方式
+const (
+	RateLimitMemory   = "memory"
+	RateLimitDatabase = "database"
+)
+
+// 限流的计量方式
+const (
+	RateLimitRequests = "requests" // 每分钟请求数，请求开始时扣除
+	RateLimitTokens   = "tokens"   // 每分钟 Token 数，请求成功后按实际用量扣除
+)
+
+// RateLimit 是请求需要遵守的一个限流桶，Key 标识限流对象（如 API 路径或虚拟 Key）
+type RateLimit struct {
+	Key   string
+	Kind  string // 见 RateLimit* 计量方式常量
+	Limit int64  // 每分钟的上限，同时是令牌桶的容量
+}
+
+// bucketKey 返回令牌桶在存储中的 Key
+func (l RateLimit) bucketKey() string {
+	return l.Kind + ":" + l.Key
+}
+
+// RateLimitResult 是一个令牌桶的检查结果
+type RateLimitResult struct {
+	Limit      RateLimit
+	Allowed    bool
+	Remaining  int64         // 检查后剩余的令牌数
+	Reset      time.Duration // 令牌桶恢复满额所需的时间
+	RetryAfter time.Duration // 未放行时需要等待的时间
+}
+
+// RateLimitStore 是令牌桶的存储，实现需要并发安全。
+// 令牌桶容量为 limit，每分钟匀速补充 limit 个令牌，新建的桶是满的。
+type RateLimitStore interface {
+	// Take 在令牌数不少于 cost 且大于 0 时扣除 cost 个令牌并放行，否则不扣除
+	Take(limit RateLimit, cost float64) RateLimitResult
+	// Charge 无条件扣除令牌，令牌数可以为负，负数相当于欠下的额度，需要等待补充后才会再次放行
+	Charge(limit RateLimit, cost float64)
+}
+
+var (
+	rateLimitStore      RateLimitStore
+	rateLimitStoreMutex sync.RWMutex
+)
+
+// InitRateLimit 按配置创建限流状态的存储，database 方式在多实例部署时共享限流状态
+func InitRateLimit() error {
+	var store RateLimitStore
+	switch utils.GlobalConfig.RateLimit.Store {
+	case "", RateLimitMemory:
+		store = NewMemoryRateLimitStore()
+	case RateLimitDatabase:
+		store = NewDBRateLimitStore(models.DB)
+	default:
+		return errors.New("unsupported rate limit store: " + utils.GlobalConfig.RateLimit.Store)
+	}
+	SetRateLimitStore(store)
+	return nil
+}
+
+// SetRateLimitStore 替换限流状态的存储
+func SetRateLimitStore(store RateLimitStore) {
+	rateLimitStoreMutex.Lock()
+	defer rateLimitStoreMutex.Unlock()
+
+	rateLimitStore = store
+}
+
+func currentRateLimitStore() RateLimitStore {
+	rateLimitStoreMutex.RLock()
+	defer rateLimitStoreMutex.RUnlock()
+
+	return rateLimitStore
+}
+
+// EndpointRateLimits 返回 API 路径与调用方的 Key（可为空）上配置的限流桶
+func EndpointRateLimits(endpoint *models.APIEndpoint, key *LimitedKey) []RateLimit {
+	var limits []RateLimit
+	add := func(scope string, kind string, limit int64) {
+		if limit > 0 {
+			limits = append(limits, RateLimit{Key: scope, Kind: kind, Limit: limit})
+		}
+	}
+	endpointScope := fmt.Sprintf("endpoint:%d", endpoint.ID)
+	add(endpointScope, RateLimitRequests, endpoint.RateLimitRPM)
+	add(endpointScope, RateLimitTokens, endpoint.RateLimitTPM)
+	if key != nil {
+		add(key.Scope, RateLimitRequests, key.Limits.RateLimitRPM)
+		add(key.Scope, RateLimitTokens, key.Limits.RateLimitTPM)
+	}
+	return limits
+}
+
+// CheckRateLimits 检查全部限流桶：请求数桶扣除一个令牌，Token 数桶只要求还有剩余额度。
+// 任意一个桶未放行时退还已扣除的令牌，返回 false 与各个桶的检查结果。
+func CheckRateLimits(limits []RateLimit) (bool, []RateLimitResult) {
+	store := currentRateLimitStore()
+	if store == nil || len(limits) == 0 {
+		return true, nil
+	}
+
+	results := make([]RateLimitResult, 0, len(limits))
+	allowed := true
+	for _, limit := range limits {
+		result := store.Take(limit, rateLimitCost(limit))
+		results = append(results, result)
+		allowed = allowed && result.Allowed
+	}
+	if !allowed {
+		for _, result := range results {
+			if result.Allowed && result.Limit.Kind == RateLimitRequests {
+				store.Charge(result.Limit, -1)
+			}
+		}
+	}
+	return allowed, results
+}
+
+// rateLimitCost 返回请求开始时扣除的令牌数
+func rateLimitCost(limit RateLimit) float64 {
+	if limit.Kind == RateLimitRequests {
+		return 1
+	}
+	return 0
+}
+
+// ChargeRateLimitTokens 按一次成功调用的实际 Token 用量扣除 Token 数桶
+func ChargeRateLimitTokens(limits []RateLimit, tokens int64) {
+	store := currentRateLimitStore()
+	if store == nil || tokens <= 0 {
+		return
+	}
+	for _, limit := range limits {
+		if limit.Kind == RateLimitTokens {
+			store.Charge(limit, float64(tokens))
+		}
+	}
+}
+
+// refillBucket 按经过的时间补充令牌，不超过容量
+func refillBucket(tokens float64, updatedAt, now time.Time, limit int64) float64 {
+	elapsed := now.Sub(updatedAt).Seconds()
+	if elapsed <= 0 {
+		return tokens
+	}
+	return math.Min(float64(limit), tokens+elapsed*float64(limit)/60)
+}
+
+// takeFromBucket 在补充后的令牌数上执行 Take，返回扣除后的令牌数与检查结果
+func takeFromBucket(limit RateLimit, tokens, cost float64) (float64, RateLimitResult) {
+	allowed := tokens >= cost && tokens > 0
+	if allowed {
+		tokens -= cost
+	}
+	return tokens, bucketResult(limit, tokens, cost, allowed)
+}
+
+// bucketResult 返回令牌数为 tokens（已扣除放行的请求）时的检查结果
+func bucketResult(limit RateLimit, tokens, cost float64, allowed bool) RateLimitResult {
+	rate := float64(limit.Limit) / 60 // 每秒补充的令牌数
+	result := RateLimitResult{Limit: limit, Allowed: allowed}
+	if !allowed {
+		// 等到令牌数达到 cost（至少要大于 0）
+		need := math.Max(cost, 1) - tokens
+		result.RetryAfter = time.Duration(math.Max(need, 0) / rate * float64(time.Second))
+	}
+	result.Remaining = int64(math.Max(0, math.Floor(tokens)))
+	result.Reset = time.Duration((float64(limit.Limit) - tokens) / rate * float64(time.Second))
+	return result
+}
+
+// memoryRateLimitStore 是进程内的令牌桶，只在单个实例内生效
+type memoryRateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]*memoryBucket
+}
+
+type memoryBucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+// NewMemoryRateLimitStore 创建进程内的令牌桶存储
+func NewMemoryRateLimitStore() RateLimitStore {
+	return &memoryRateLimitStore{buckets: make(map[string]*memoryBucket)}
+}
+
+// bucket 返回补充令牌后的桶，调用方需持有 mu
+func (s *memoryRateLimitStore) bucket(limit RateLimit, now time.Time) *memoryBucket {
+	b, ok := s.buckets[limit.bucketKey()]
+	if !ok {
+		b = &memoryBucket{tokens: float64(limit.Limit), updatedAt: now}
+		s.buckets[limit.bucketKey()] = b
+	}
+	b.tokens = refillBucket(b.tokens, b.updatedAt, now, limit.Limit)
+	b.updatedAt = now
+	return b
+}
+
+func (s *memoryRateLimitStore) Take(limit RateLimit, cost float64) RateLimitResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := s.bucket(limit, time.Now())
+	var result RateLimitResult
+	b.tokens, result = takeFromBucket(limit, b.tokens, cost)
+	return result
+}
+
+func (s *memoryRateLimitStore) Charge(limit RateLimit, cost float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := s.bucket(limit, time.Now())
+	b.tokens = math.Min(float64(limit.Limit), b.tokens-cost)
+}
+
+// dbRateLimitStore 把令牌桶保存在数据库中，多个实例共享限流状态。
+// 补充与扣除令牌在一条条件 UPDATE 中完成，令牌不足时不更新任何行，并发的请求不会超额放行。
+type dbRateLimitStore struct {
+	db *gorm.DB
+}
+
+// NewDBRateLimitStore 创建数据库令牌桶存储
+func NewDBRateLimitStore(db *gorm.DB) RateLimitStore {
+	return &dbRateLimitStore{db: db}
+}
+
+// refilledTokensExpr 返回按经过的时间补充令牌后（不超过容量）的令牌数的 SQL 表达式，
+// 其他实例的时钟较快导致 refilled_at 晚于 now 时不补充
+func refilledTokensExpr(limit RateLimit, now int64) clause.Expr {
+	rate := float64(limit.Limit) / 60000 // 每毫秒补充的令牌数
+	elapsed := "(CASE WHEN refilled_at < ? THEN ? - refilled_at ELSE 0 END)"
+	return gorm.Expr("(CASE WHEN tokens + "+elapsed+" * ? > ? THEN ? ELSE tokens + "+elapsed+" * ? END)",
+		now, now, rate, limit.Limit, limit.Limit, now, now, rate)
+}
+
+// consume 以一条 UPDATE 补充令牌并扣除 cost 个令牌（不超过容量），conditional 为 true 时只在令牌数不少于 cost 且大于 0 时扣除。
+// 返回是否扣除；桶不存在时先创建满的桶再扣除。
+func (s *dbRateLimitStore) consume(limit RateLimit, cost float64, now time.Time, conditional bool) (bool, error) {
+	nowMs := now.UnixMilli()
+	for created := false; ; created = true {
+		refilled := refilledTokensExpr(limit, nowMs)
+		query := s.db.Model(&models.RateLimitBucket{}).Where(&models.RateLimitBucket{Key: limit.bucketKey()})
+		if conditional {
+			query = query.Where("? >= ? AND ? > 0", refilled, cost, refilled)
+		}
+		// tokens 必须在 refilled_at 之前赋值：MySQL 按从左到右的顺序执行赋值，后面的表达式会读到新值
+		result := query.Clauses(clause.Set{
+			{Column: clause.Column{Name: "tokens"}, Value: gorm.Expr("CASE WHEN ? - ? > ? THEN ? ELSE ? - ? END", refilled, cost, limit.Limit, limit.Limit, refilled, cost)},
+			{Column: clause.Column{Name: "refilled_at"}, Value: nowMs},
+		}).Updates(map[string]interface{}{})
+		if result.Error != nil {
+			return false, result.Error
+		}
+		if result.RowsAffected > 0 || created {
+			return result.RowsAffected > 0, nil
+		}
+
+		// 没有更新任何行：桶不存在时创建满的桶后重试，桶已存在（令牌不足）时创建被唯一索引忽略
+		insert := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.RateLimitBucket{Key: limit.bucketKey(), Tokens: float64(limit.Limit), RefilledAt: nowMs})
+		if insert.Error != nil {
+			return false, insert.Error
+		}
+		if insert.RowsAffected == 0 {
+			return false, nil
+		}
+	}
+}
+
+// tokens 返回桶在 now 补充后的令牌数，桶不存在时为容量
+func (s *dbRateLimitStore) tokens(limit RateLimit, now time.Time) (float64, error) {
+	var bucket models.RateLimitBucket
+	result := s.db.Where(&models.RateLimitBucket{Key: limit.bucketKey()}).Limit(1).Find(&bucket)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return float64(limit.Limit), nil
+	}
+	return refillBucket(bucket.Tokens, time.UnixMilli(bucket.RefilledAt), now, limit.Limit), nil
+}
+
+// Take 的放行由条件 UPDATE 决定，随后读取的令牌数只用于返回剩余额度与等待时间。
+// cost 为 0（Token 数桶）时只检查是否还有剩余额度，不写入数据库。
+func (s *dbRateLimitStore) Take(limit RateLimit, cost float64) RateLimitResult {
+	now := time.Now()
+	allowed := false
+	if cost > 0 {
+		consumed, err := s.consume(limit, cost, now, true)
+		if err != nil {
+			return s.failOpen(limit, err)
+		}
+		allowed = consumed
+	}
+	tokens, err := s.tokens(limit, now)
+	if err != nil {
+		return s.failOpen(limit, err)
+	}
+	if cost <= 0 {
+		allowed = tokens > 0
+	}
+	return bucketResult(limit, tokens, cost, allowed)
+}
+
+// failOpen 在存储不可用时记录错误并放行，避免限流故障导致服务不可用
+func (s *dbRateLimitStore) failOpen(limit RateLimit, err error) RateLimitResult {
+	log.Printf("rate limit %s: store unavailable, allowing request: %v", limit.bucketKey(), err)
+	return RateLimitResult{Limit: limit, Allowed: true, Remaining: limit.Limit}
+}
+
+func (s *dbRateLimitStore) Charge(limit RateLimit, cost float64) {
+	if _, err := s.consume(limit, cost, time.Now(), false); err != nil {
+		log.Printf("rate limit %s: failed to charge %v tokens: %v", limit.bucketKey(), cost, err)
+	}
+}
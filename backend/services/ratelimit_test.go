@@ -0,0 +1,137 @@
+package services
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestRefillBucket(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		tokens  float64
+		elapsed time.Duration
+		limit   int64
+		want    float64
+	}{
+		{"no time elapsed", 5, 0, 60, 5},
+		{"clock went backwards", 5, -time.Second, 60, 5},
+		{"one token per second at 60 rpm", 5, 3 * time.Second, 60, 8},
+		{"partial tokens", 0, 500 * time.Millisecond, 60, 0.5},
+		{"capped at capacity", 50, time.Minute, 60, 60},
+		{"debt is repaid first", -30, 30 * time.Second, 60, 0},
+		{"slow bucket", 0, 20 * time.Second, 3, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := refillBucket(tt.tokens, start, start.Add(tt.elapsed), tt.limit)
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("refillBucket(%v, +%v, %d) = %v, want %v", tt.tokens, tt.elapsed, tt.limit, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTakeFromBucket(t *testing.T) {
+	limit := RateLimit{Key: "endpoint:1", Kind: RateLimitRequests, Limit: 60}
+	tests := []struct {
+		name           string
+		tokens, cost   float64
+		wantTokens     float64
+		wantAllowed    bool
+		wantRemaining  int64
+		wantRetryAfter time.Duration
+		wantReset      time.Duration
+	}{
+		{"full bucket", 60, 1, 59, true, 59, 0, time.Second},
+		{"last token", 1, 1, 0, true, 0, 0, time.Minute},
+		{"fraction short", 0.5, 1, 0.5, false, 0, 500 * time.Millisecond, 59500 * time.Millisecond},
+		{"empty bucket", 0, 1, 0, false, 0, time.Second, time.Minute},
+		{"in debt", -10, 1, -10, false, 0, 11 * time.Second, 70 * time.Second},
+		// 按 Token 计量时请求开始时 cost 为 0，只要令牌数大于 0 就放行
+		{"zero cost allowed", 0.5, 0, 0.5, true, 0, 0, 59500 * time.Millisecond},
+		{"zero cost waits for a whole token", -2, 0, -2, false, 0, 3 * time.Second, 62 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, result := takeFromBucket(limit, tt.tokens, tt.cost)
+			if tokens != tt.wantTokens {
+				t.Errorf("tokens = %v, want %v", tokens, tt.wantTokens)
+			}
+			if result.Allowed != tt.wantAllowed {
+				t.Errorf("Allowed = %v, want %v", result.Allowed, tt.wantAllowed)
+			}
+			if result.Remaining != tt.wantRemaining {
+				t.Errorf("Remaining = %d, want %d", result.Remaining, tt.wantRemaining)
+			}
+			if result.RetryAfter != tt.wantRetryAfter {
+				t.Errorf("RetryAfter = %v, want %v", result.RetryAfter, tt.wantRetryAfter)
+			}
+			if result.Reset != tt.wantReset {
+				t.Errorf("Reset = %v, want %v", result.Reset, tt.wantReset)
+			}
+		})
+	}
+}
+
+func TestBucketResultRetryAfterScalesWithLimit(t *testing.T) {
+	// 3 RPM 每 20 秒补充一个令牌
+	limit := RateLimit{Key: "key:1", Kind: RateLimitRequests, Limit: 3}
+	result := bucketResult(limit, 0, 1, false)
+	if result.RetryAfter != 20*time.Second {
+		t.Errorf("RetryAfter = %v, want 20s", result.RetryAfter)
+	}
+	if result.Reset != time.Minute {
+		t.Errorf("Reset = %v, want 1m", result.Reset)
+	}
+
+	// 1000 TPM 欠下 500 个令牌时需要等 30 秒才回到 0，再等到 1 个令牌
+	limit = RateLimit{Key: "key:1", Kind: RateLimitTokens, Limit: 1000}
+	result = bucketResult(limit, -500, 0, false)
+	if want := 30060 * time.Millisecond; (result.RetryAfter - want).Abs() > time.Millisecond {
+		t.Errorf("RetryAfter = %v, want %v", result.RetryAfter, want)
+	}
+}
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	limit := RateLimit{Key: "endpoint:1", Kind: RateLimitRequests, Limit: 3}
+
+	for i := 0; i < 3; i++ {
+		if result := store.Take(limit, 1); !result.Allowed {
+			t.Fatalf("request %d rejected, want allowed", i+1)
+		}
+	}
+	result := store.Take(limit, 1)
+	if result.Allowed {
+		t.Fatal("fourth request allowed, want rejected")
+	}
+	if result.RetryAfter <= 0 || result.RetryAfter > 20*time.Second {
+		t.Errorf("RetryAfter = %v, want within (0, 20s]", result.RetryAfter)
+	}
+
+	// 其他限流对象使用独立的令牌桶
+	other := RateLimit{Key: "endpoint:2", Kind: RateLimitRequests, Limit: 3}
+	if result := store.Take(other, 1); !result.Allowed {
+		t.Error("other bucket rejected, want allowed")
+	}
+}
+
+func TestMemoryRateLimitStoreChargeCreatesDebt(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	limit := RateLimit{Key: "endpoint:1", Kind: RateLimitTokens, Limit: 1000}
+
+	if result := store.Take(limit, 0); !result.Allowed {
+		t.Fatal("first request rejected, want allowed")
+	}
+	store.Charge(limit, 1500)
+	result := store.Take(limit, 0)
+	if result.Allowed {
+		t.Fatal("request allowed while in debt, want rejected")
+	}
+	// 欠下约 500 个令牌，按每秒约 16.7 个补充需要 30 秒以上
+	if result.RetryAfter < 29*time.Second || result.RetryAfter > 31*time.Second {
+		t.Errorf("RetryAfter = %v, want about 30s", result.RetryAfter)
+	}
+}
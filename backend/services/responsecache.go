@@ -0,0 +1,278 @@
+package services
+
+import (
+	"ai-api-platform/backend/models"
+	"ai-api-platform/backend/utils"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 响应缓存的存储方式
+const (
+	ResponseCacheMemory   = "memory"
+	ResponseCacheDatabase = "database"
+)
+
+// 未配置时的默认上限
+const (
+	defaultCacheMaxEntries    = 10000
+	defaultCacheMaxEntryBytes = 1 << 20
+)
+
+// ResponseCacheStore 是响应缓存的存储，实现需要并发安全
+type ResponseCacheStore interface {
+	// Get 返回未过期的缓存值
+	Get(key string) ([]byte, bool)
+	// Set 写入缓存，超出条目数上限时淘汰旧条目
+	Set(key string, endpointID uint, value []byte, ttl time.Duration)
+	// DeleteEndpoint 删除 API 路径的全部缓存
+	DeleteEndpoint(endpointID uint)
+}
+
+var (
+	responseCache      ResponseCacheStore
+	responseCacheMutex sync.RWMutex
+)
+
+// InitResponseCache 按配置创建响应缓存的存储，语义缓存的索引使用相同的存储方式
+func InitResponseCache() error {
+	config := utils.GlobalConfig.ResponseCache
+	maxEntries := config.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = defaultCacheMaxEntries
+	}
+
+	var store ResponseCacheStore
+	switch config.Store {
+	case "", ResponseCacheMemory:
+		store = NewMemoryCacheStore(maxEntries)
+	case ResponseCacheDatabase:
+		store = NewDBCacheStore(models.DB, maxEntries)
+	default:
+		return errors.New("unsupported response cache store: " + config.Store)
+	}
+	SetResponseCacheStore(store)
+	SetSemanticCacheIndex(newSemanticCacheIndex(config.Store))
+	return nil
+}
+
+// SetResponseCacheStore 替换响应缓存的存储
+func SetResponseCacheStore(store ResponseCacheStore) {
+	responseCacheMutex.Lock()
+	defer responseCacheMutex.Unlock()
+
+	responseCache = store
+}
+
+func currentCacheStore() ResponseCacheStore {
+	responseCacheMutex.RLock()
+	defer responseCacheMutex.RUnlock()
+
+	return responseCache
+}
+
+// ResponseCacheKey 计算缓存 Key：对 API 路径、配置版本与请求内容（模型、参数、消息）的 JSON 取 SHA-256。
+// 更新 API 路径后 UpdatedAt 改变，旧的缓存不会再被命中。
+func ResponseCacheKey(endpoint *models.APIEndpoint, request interface{}) string {
+	payload, _ := json.Marshal(struct {
+		EndpointID uint
+		Version    int64
+		Request    interface{}
+	}{endpoint.ID, endpoint.UpdatedAt.UnixNano(), request})
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+// GetCachedResponse 读取缓存，未初始化缓存时总是未命中
+func GetCachedResponse(key string) ([]byte, bool) {
+	store := currentCacheStore()
+	if store == nil {
+		return nil, false
+	}
+	return store.Get(key)
+}
+
+// SetCachedResponse 写入缓存，超过 max_entry_bytes 的响应不缓存
+func SetCachedResponse(key string, endpointID uint, value []byte, ttl time.Duration) {
+	store := currentCacheStore()
+	if store == nil || ttl <= 0 {
+		return
+	}
+	maxBytes := utils.GlobalConfig.ResponseCache.MaxEntryBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultCacheMaxEntryBytes
+	}
+	if len(value) > maxBytes {
+		return
+	}
+	store.Set(key, endpointID, value, ttl)
+}
+
+// PurgeResponseCache 删除 API 路径的全部缓存，包括语义缓存
+func PurgeResponseCache(endpointID uint) {
+	if store := currentCacheStore(); store != nil {
+		store.DeleteEndpoint(endpointID)
+	}
+	if index := currentSemanticIndex(); index != nil {
+		index.DeleteEndpoint(endpointID)
+	}
+}
+
+// memoryCacheStore 是进程内的 LRU 缓存
+type memoryCacheStore struct {
+	mu         sync.Mutex
+	maxEntries int
+	entries    map[string]*list.Element
+	order      *list.List // 队首为最近使用的条目
+}
+
+type memoryCacheEntry struct {
+	key        string
+	endpointID uint
+	value      []byte
+	expiresAt  time.Time
+}
+
+// NewMemoryCacheStore 创建最多保存 maxEntries 条的进程内缓存
+func NewMemoryCacheStore(maxEntries int) ResponseCacheStore {
+	return &memoryCacheStore{
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+func (s *memoryCacheStore) Get(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	element, ok := s.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*memoryCacheEntry)
+	if time.Now().After(entry.expiresAt) {
+		s.remove(element)
+		return nil, false
+	}
+	s.order.MoveToFront(element)
+	return entry.value, true
+}
+
+func (s *memoryCacheStore) Set(key string, endpointID uint, value []byte, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry := &memoryCacheEntry{key: key, endpointID: endpointID, value: value, expiresAt: time.Now().Add(ttl)}
+	if element, ok := s.entries[key]; ok {
+		element.Value = entry
+		s.order.MoveToFront(element)
+		return
+	}
+	s.entries[key] = s.order.PushFront(entry)
+	for s.order.Len() > s.maxEntries {
+		s.remove(s.order.Back())
+	}
+}
+
+func (s *memoryCacheStore) DeleteEndpoint(endpointID uint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for element := s.order.Front(); element != nil; {
+		next := element.Next()
+		if element.Value.(*memoryCacheEntry).endpointID == endpointID {
+			s.remove(element)
+		}
+		element = next
+	}
+}
+
+// remove 删除条目，调用方需持有 mu
+func (s *memoryCacheStore) remove(element *list.Element) {
+	s.order.Remove(element)
+	delete(s.entries, element.Value.(*memoryCacheEntry).key)
+}
+
+// dbCacheStore 把缓存保存在数据库中，多个实例可以共享。
+// 过期条目与超出上限的条目由后台协程定期清理。
+type dbCacheStore struct {
+	db         *gorm.DB
+	maxEntries int
+}
+
+// dbCacheCleanupInterval 是清理过期与超量缓存的间隔
+const dbCacheCleanupInterval = time.Minute
+
+// NewDBCacheStore 创建数据库缓存并启动清理协程
+func NewDBCacheStore(db *gorm.DB, maxEntries int) ResponseCacheStore {
+	store := &dbCacheStore{db: db, maxEntries: maxEntries}
+	go func() {
+		ticker := time.NewTicker(dbCacheCleanupInterval)
+		for range ticker.C {
+			store.cleanup()
+		}
+	}()
+	return store
+}
+
+func (s *dbCacheStore) Get(key string) ([]byte, bool) {
+	var entry models.ResponseCacheEntry
+	if err := s.db.Where(&models.ResponseCacheEntry{Key: key}).Where("expires_at > ?", time.Now()).First(&entry).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("read response cache: %v", err)
+		}
+		return nil, false
+	}
+	return []byte(entry.Value), true
+}
+
+func (s *dbCacheStore) Set(key string, endpointID uint, value []byte, ttl time.Duration) {
+	entry := models.ResponseCacheEntry{
+		Key:           key,
+		APIEndpointID: endpointID,
+		Value:         string(value),
+		ExpiresAt:     time.Now().Add(ttl),
+		CreatedAt:     time.Now(),
+	}
+	err := s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"api_endpoint_id", "value", "expires_at", "created_at"}),
+	}).Create(&entry).Error
+	if err != nil {
+		log.Printf("write response cache: %v", err)
+	}
+}
+
+func (s *dbCacheStore) DeleteEndpoint(endpointID uint) {
+	if err := s.db.Where("api_endpoint_id = ?", endpointID).Delete(&models.ResponseCacheEntry{}).Error; err != nil {
+		log.Printf("purge response cache: %v", err)
+	}
+}
+
+// cleanup 删除过期条目，条目数超过上限时删除最早写入的条目
+func (s *dbCacheStore) cleanup() {
+	if err := s.db.Where("expires_at <= ?", time.Now()).Delete(&models.ResponseCacheEntry{}).Error; err != nil {
+		log.Printf("cleanup response cache: %v", err)
+		return
+	}
+
+	var count int64
+	s.db.Model(&models.ResponseCacheEntry{}).Count(&count)
+	if excess := int(count) - s.maxEntries; excess > 0 {
+		var ids []uint
+		s.db.Model(&models.ResponseCacheEntry{}).Order("created_at").Limit(excess).Pluck("id", &ids)
+		if len(ids) > 0 {
+			s.db.Delete(&models.ResponseCacheEntry{}, ids)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"ai-api-platform/backend/models"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestMemoryCacheStoreGetSet(t *testing.T) {
+	store := NewMemoryCacheStore(10)
+	if _, ok := store.Get("a"); ok {
+		t.Fatal("empty store returned a hit")
+	}
+
+	store.Set("a", 1, []byte("first"), time.Minute)
+	if value, ok := store.Get("a"); !ok || string(value) != "first" {
+		t.Fatalf("Get(a) = %q, %v, want first, true", value, ok)
+	}
+	store.Set("a", 1, []byte("second"), time.Minute)
+	if value, ok := store.Get("a"); !ok || string(value) != "second" {
+		t.Errorf("Get(a) after overwrite = %q, %v, want second, true", value, ok)
+	}
+}
+
+func TestMemoryCacheStoreExpiry(t *testing.T) {
+	store := NewMemoryCacheStore(10)
+	store.Set("a", 1, []byte("value"), time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if _, ok := store.Get("a"); ok {
+		t.Error("expired entry returned a hit")
+	}
+	if n := store.(*memoryCacheStore).order.Len(); n != 0 {
+		t.Errorf("expired entry not removed, %d entries left", n)
+	}
+}
+
+func TestMemoryCacheStoreEvictsLeastRecentlyUsed(t *testing.T) {
+	store := NewMemoryCacheStore(2)
+	store.Set("a", 1, []byte("a"), time.Minute)
+	store.Set("b", 1, []byte("b"), time.Minute)
+	// 读取 a 后 b 成为最久未使用的条目
+	store.Get("a")
+	store.Set("c", 1, []byte("c"), time.Minute)
+
+	if _, ok := store.Get("b"); ok {
+		t.Error("least recently used entry b was not evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := store.Get(key); !ok {
+			t.Errorf("entry %s was evicted", key)
+		}
+	}
+}
+
+func TestMemoryCacheStoreDeleteEndpoint(t *testing.T) {
+	store := NewMemoryCacheStore(10)
+	store.Set("a", 1, []byte("a"), time.Minute)
+	store.Set("b", 2, []byte("b"), time.Minute)
+	store.Set("c", 1, []byte("c"), time.Minute)
+
+	store.DeleteEndpoint(1)
+	for _, key := range []string{"a", "c"} {
+		if _, ok := store.Get(key); ok {
+			t.Errorf("entry %s of the purged endpoint still cached", key)
+		}
+	}
+	if _, ok := store.Get("b"); !ok {
+		t.Error("entry of another endpoint was purged")
+	}
+}
+
+func TestResponseCacheKey(t *testing.T) {
+	updatedAt := time.Date(2026, 3, 9, 12, 0, 0, 0, time.UTC)
+	endpoint := &models.APIEndpoint{Model: gorm.Model{ID: 1, UpdatedAt: updatedAt}}
+	request := map[string]interface{}{"model": "m1", "content": "hello"}
+
+	key := ResponseCacheKey(endpoint, request)
+	if len(key) != 64 {
+		t.Errorf("key length = %d, want 64", len(key))
+	}
+	if again := ResponseCacheKey(endpoint, map[string]interface{}{"content": "hello", "model": "m1"}); again != key {
+		t.Error("same request produced a different key")
+	}
+	if other := ResponseCacheKey(endpoint, map[string]interface{}{"model": "m1", "content": "bye"}); other == key {
+		t.Error("different content produced the same key")
+	}
+	if other := ResponseCacheKey(&models.APIEndpoint{Model: gorm.Model{ID: 2, UpdatedAt: updatedAt}}, request); other == key {
+		t.Error("different endpoint produced the same key")
+	}
+	// 修改 API 路径后旧的缓存不再命中
+	updated := &models.APIEndpoint{Model: gorm.Model{ID: 1, UpdatedAt: updatedAt.Add(time.Second)}}
+	if other := ResponseCacheKey(updated, request); other == key {
+		t.Error("updated endpoint produced the same key")
+	}
+}
+
+func TestSetCachedResponseLimits(t *testing.T) {
+	saved := currentCacheStore()
+	t.Cleanup(func() { SetResponseCacheStore(saved) })
+	SetResponseCacheStore(NewMemoryCacheStore(10))
+
+	SetCachedResponse("small", 1, []byte("ok"), time.Minute)
+	if _, ok := GetCachedResponse("small"); !ok {
+		t.Error("small response not cached")
+	}
+	SetCachedResponse("no-ttl", 1, []byte("ok"), 0)
+	if _, ok := GetCachedResponse("no-ttl"); ok {
+		t.Error("response with zero TTL was cached")
+	}
+	// 未配置 max_entry_bytes 时上限为 1MB
+	SetCachedResponse("large", 1, []byte(strings.Repeat("x", defaultCacheMaxEntryBytes+1)), time.Minute)
+	if _, ok := GetCachedResponse("large"); ok {
+		t.Error("response over max_entry_bytes was cached")
+	}
+}
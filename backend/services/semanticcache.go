@@ -0,0 +1,274 @@
+package services
+
+import (
+	"ai-api-platform/backend/models"
+	"ai-api-platform/backend/utils"
+	"encoding/binary"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// defaultSemanticMaxEntries 是未配置时每个 API 路径的语义缓存条目数上限
+const defaultSemanticMaxEntries = 1000
+
+// SemanticCacheIndex 是语义缓存的向量索引，向量在写入前已归一化，余弦相似度即点积。实现需要并发安全
+type SemanticCacheIndex interface {
+	// Search 在 API 路径相同上下文的未过期条目中查找与 vector 最相似的一条
+	Search(endpointID uint, scope string, vector []float32) (value []byte, similarity float64, ok bool)
+	// Add 写入条目，API 路径的条目数超出上限时淘汰最早写入的条目
+	Add(endpointID uint, scope string, vector []float32, value []byte, ttl time.Duration)
+	// DeleteEndpoint 删除 API 路径的全部条目
+	DeleteEndpoint(endpointID uint)
+}
+
+var (
+	semanticIndex      SemanticCacheIndex
+	semanticIndexMutex sync.RWMutex
+)
+
+// newSemanticCacheIndex 按响应缓存的存储方式创建语义缓存的索引
+func newSemanticCacheIndex(store string) SemanticCacheIndex {
+	maxEntries := utils.GlobalConfig.ResponseCache.SemanticMaxEntries
+	if maxEntries <= 0 {
+		maxEntries = defaultSemanticMaxEntries
+	}
+	if store == ResponseCacheDatabase {
+		return NewDBSemanticIndex(models.DB, maxEntries)
+	}
+	return NewMemorySemanticIndex(maxEntries)
+}
+
+// SetSemanticCacheIndex 替换语义缓存的索引
+func SetSemanticCacheIndex(index SemanticCacheIndex) {
+	semanticIndexMutex.Lock()
+	defer semanticIndexMutex.Unlock()
+
+	semanticIndex = index
+}
+
+func currentSemanticIndex() SemanticCacheIndex {
+	semanticIndexMutex.RLock()
+	defer semanticIndexMutex.RUnlock()
+
+	return semanticIndex
+}
+
+// NormalizeVector 把向量转换为 float32 并归一化为单位长度，零向量返回 nil
+func NormalizeVector(vector []float64) []float32 {
+	var norm float64
+	for _, v := range vector {
+		norm += v * v
+	}
+	if norm == 0 {
+		return nil
+	}
+	norm = math.Sqrt(norm)
+
+	result := make([]float32, len(vector))
+	for i, v := range vector {
+		result[i] = float32(v / norm)
+	}
+	return result
+}
+
+// cosineSimilarity 计算两个单位向量的余弦相似度，维度不同时返回 -1
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return -1
+	}
+	var dot float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+	}
+	return dot
+}
+
+// SearchSemanticCache 查找相似度不低于 threshold 的缓存回答，未初始化索引时总是未命中
+func SearchSemanticCache(endpointID uint, scope string, vector []float32, threshold float64) ([]byte, float64, bool) {
+	index := currentSemanticIndex()
+	if index == nil || len(vector) == 0 {
+		return nil, 0, false
+	}
+	value, similarity, ok := index.Search(endpointID, scope, vector)
+	if !ok || similarity < threshold {
+		return nil, similarity, false
+	}
+	return value, similarity, true
+}
+
+// AddSemanticCache 写入语义缓存，与响应缓存一样不缓存超过 max_entry_bytes 的回答
+func AddSemanticCache(endpointID uint, scope string, vector []float32, value []byte, ttl time.Duration) {
+	index := currentSemanticIndex()
+	if index == nil || len(vector) == 0 || ttl <= 0 {
+		return
+	}
+	maxBytes := utils.GlobalConfig.ResponseCache.MaxEntryBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultCacheMaxEntryBytes
+	}
+	if len(value) > maxBytes {
+		return
+	}
+	index.Add(endpointID, scope, vector, value, ttl)
+}
+
+// memorySemanticIndex 是进程内的向量索引，按 API 路径分组线性扫描
+type memorySemanticIndex struct {
+	mu         sync.RWMutex
+	maxEntries int
+	entries    map[uint][]*semanticEntry // 按写入时间排序
+}
+
+type semanticEntry struct {
+	scope     string
+	vector    []float32
+	value     []byte
+	expiresAt time.Time
+}
+
+// NewMemorySemanticIndex 创建每个 API 路径最多保存 maxEntries 条的进程内索引
+func NewMemorySemanticIndex(maxEntries int) SemanticCacheIndex {
+	return &memorySemanticIndex{
+		maxEntries: maxEntries,
+		entries:    make(map[uint][]*semanticEntry),
+	}
+}
+
+func (s *memorySemanticIndex) Search(endpointID uint, scope string, vector []float32) ([]byte, float64, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	var best *semanticEntry
+	bestSimilarity := -1.0
+	for _, entry := range s.entries[endpointID] {
+		if entry.scope != scope || now.After(entry.expiresAt) {
+			continue
+		}
+		if similarity := cosineSimilarity(vector, entry.vector); similarity > bestSimilarity {
+			best, bestSimilarity = entry, similarity
+		}
+	}
+	if best == nil {
+		return nil, 0, false
+	}
+	return best.value, bestSimilarity, true
+}
+
+func (s *memorySemanticIndex) Add(endpointID uint, scope string, vector []float32, value []byte, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	entries := s.entries[endpointID][:0:0]
+	for _, entry := range s.entries[endpointID] {
+		if now.Before(entry.expiresAt) {
+			entries = append(entries, entry)
+		}
+	}
+	entries = append(entries, &semanticEntry{scope: scope, vector: vector, value: value, expiresAt: now.Add(ttl)})
+	if excess := len(entries) - s.maxEntries; excess > 0 {
+		entries = entries[excess:]
+	}
+	s.entries[endpointID] = entries
+}
+
+func (s *memorySemanticIndex) DeleteEndpoint(endpointID uint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, endpointID)
+}
+
+// dbSemanticIndex 把向量保存在数据库中，多个实例可以共享。
+// 查询时读出 API 路径相同上下文的条目在进程内计算相似度，过期条目由后台协程定期清理。
+type dbSemanticIndex struct {
+	db         *gorm.DB
+	maxEntries int
+}
+
+// NewDBSemanticIndex 创建数据库索引并启动清理协程
+func NewDBSemanticIndex(db *gorm.DB, maxEntries int) SemanticCacheIndex {
+	index := &dbSemanticIndex{db: db, maxEntries: maxEntries}
+	go func() {
+		ticker := time.NewTicker(dbCacheCleanupInterval)
+		for range ticker.C {
+			if err := db.Where("expires_at <= ?", time.Now()).Delete(&models.SemanticCacheEntry{}).Error; err != nil {
+				log.Printf("cleanup semantic cache: %v", err)
+			}
+		}
+	}()
+	return index
+}
+
+// encodeVector 以 float32 小端序编码向量
+func encodeVector(vector []float32) []byte {
+	data := make([]byte, 4*len(vector))
+	for i, v := range vector {
+		binary.LittleEndian.PutUint32(data[4*i:], math.Float32bits(v))
+	}
+	return data
+}
+
+func decodeVector(data []byte) []float32 {
+	vector := make([]float32, len(data)/4)
+	for i := range vector {
+		vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[4*i:]))
+	}
+	return vector
+}
+
+func (s *dbSemanticIndex) Search(endpointID uint, scope string, vector []float32) ([]byte, float64, bool) {
+	var entries []models.SemanticCacheEntry
+	if err := s.db.Where("api_endpoint_id = ? AND scope = ? AND expires_at > ?", endpointID, scope, time.Now()).Find(&entries).Error; err != nil {
+		log.Printf("read semantic cache: %v", err)
+		return nil, 0, false
+	}
+
+	best := -1
+	bestSimilarity := -1.0
+	for i := range entries {
+		if similarity := cosineSimilarity(vector, decodeVector(entries[i].Vector)); similarity > bestSimilarity {
+			best, bestSimilarity = i, similarity
+		}
+	}
+	if best < 0 {
+		return nil, 0, false
+	}
+	return []byte(entries[best].Value), bestSimilarity, true
+}
+
+func (s *dbSemanticIndex) Add(endpointID uint, scope string, vector []float32, value []byte, ttl time.Duration) {
+	entry := models.SemanticCacheEntry{
+		APIEndpointID: endpointID,
+		Scope:         scope,
+		Vector:        encodeVector(vector),
+		Value:         string(value),
+		ExpiresAt:     time.Now().Add(ttl),
+		CreatedAt:     time.Now(),
+	}
+	if err := s.db.Create(&entry).Error; err != nil {
+		log.Printf("write semantic cache: %v", err)
+		return
+	}
+
+	var count int64
+	s.db.Model(&models.SemanticCacheEntry{}).Where("api_endpoint_id = ?", endpointID).Count(&count)
+	if excess := int(count) - s.maxEntries; excess > 0 {
+		var ids []uint
+		s.db.Model(&models.SemanticCacheEntry{}).Where("api_endpoint_id = ?", endpointID).Order("id").Limit(excess).Pluck("id", &ids)
+		if len(ids) > 0 {
+			s.db.Delete(&models.SemanticCacheEntry{}, ids)
+		}
+	}
+}
+
+func (s *dbSemanticIndex) DeleteEndpoint(endpointID uint) {
+	if err := s.db.Where("api_endpoint_id = ?", endpointID).Delete(&models.SemanticCacheEntry{}).Error; err != nil {
+		log.Printf("purge semantic cache: %v", err)
+	}
+}
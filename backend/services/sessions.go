@@ -0,0 +1,157 @@
+package services
+
+import (
+	"ai-api-platform/backend/models"
+	"ai-api-platform/backend/providers"
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// MaxSessionIDLength 是 session_id 的最大长度，与 ChatSession.SessionID 的列宽一致
+const MaxSessionIDLength = 128
+
+// DefaultSessionMaxTokens 是 API 路径没有设置 SessionMaxTokens 时回放历史的 Token 上限，避免长会话的历史无限增长
+const DefaultSessionMaxTokens = 4000
+
+// ErrInvalidSessionID 表示 session_id 为空或过长
+var ErrInvalidSessionID = errors.New("session_id must be 1-128 characters")
+
+// NormalizeSessionID 去掉 session_id 两端的空白并检查长度
+func NormalizeSessionID(sessionID string) (string, error) {
+	sessionID = strings.TrimSpace(sessionID)
+	if sessionID == "" || len(sessionID) > MaxSessionIDLength {
+		return "", ErrInvalidSessionID
+	}
+	return sessionID, nil
+}
+
+// FindSession 查找 API 路径上属于 ownerKeyID（客户端 Key 轮换链中最初的 Key）的会话，不存在时返回 gorm.ErrRecordNotFound
+func FindSession(endpointID, ownerKeyID uint, sessionID string) (*models.ChatSession, error) {
+	var session models.ChatSession
+	if err := models.DB.Where("api_endpoint_id = ? AND owner_key_id = ? AND session_id = ?", endpointID, ownerKeyID, sessionID).First(&session).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// SessionHistory 返回需要回放给模型的历史消息（按时间顺序）。
+// 按整轮截取最近的对话，受 API 路径的 SessionMaxTurns 与 SessionMaxTokens（未设置时为 DefaultSessionMaxTokens）限制；会话不存在时返回空。
+func SessionHistory(endpoint *models.APIEndpoint, ownerKeyID uint, sessionID string) ([]models.SessionMessage, error) {
+	session, err := FindSession(endpoint.ID, ownerKeyID, sessionID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	query := models.DB.Where("chat_session_id = ?", session.ID).Order("id DESC")
+	if endpoint.SessionMaxTurns > 0 {
+		// 每轮通常是一问一答两条消息
+		query = query.Limit(endpoint.SessionMaxTurns * 2)
+	}
+	var messages []models.SessionMessage
+	if err := query.Find(&messages).Error; err != nil {
+		return nil, err
+	}
+
+	maxTokens := int64(endpoint.SessionMaxTokens)
+	if maxTokens <= 0 {
+		maxTokens = DefaultSessionMaxTokens
+	}
+
+	// 从最新的消息往前按轮截取，每轮以 user 消息开始，不完整的轮次不回放
+	var turns, tokens, turnTokens int64
+	start := 0
+	for i, m := range messages {
+		turnTokens += m.Tokens
+		if m.Role != "user" {
+			continue
+		}
+		if endpoint.SessionMaxTurns > 0 && turns >= int64(endpoint.SessionMaxTurns) {
+			break
+		}
+		if tokens+turnTokens > maxTokens {
+			break
+		}
+		turns++
+		tokens += turnTokens
+		turnTokens = 0
+		start = i + 1
+	}
+	messages = messages[:start]
+
+	// 恢复时间顺序
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages, nil
+}
+
+// AppendSessionTurn 在 ownerKeyID 的会话中保存一轮对话，会话不存在时自动创建
+func AppendSessionTurn(endpointID, ownerKeyID uint, sessionID, userContent, assistantContent string) error {
+	return models.DB.Transaction(func(tx *gorm.DB) error {
+		session := models.ChatSession{APIEndpointID: endpointID, OwnerKeyID: ownerKeyID, SessionID: sessionID}
+		if err := tx.Where("api_endpoint_id = ? AND owner_key_id = ? AND session_id = ?", endpointID, ownerKeyID, sessionID).FirstOrCreate(&session).Error; err != nil {
+			return err
+		}
+
+		messages := []models.SessionMessage{
+			{ChatSessionID: session.ID, Role: "user", Content: userContent, Tokens: providers.EstimateTokens(userContent)},
+			{ChatSessionID: session.ID, Role: "assistant", Content: assistantContent, Tokens: providers.EstimateTokens(assistantContent)},
+		}
+		if err := tx.Create(&messages).Error; err != nil {
+			return err
+		}
+		return tx.Model(&session).Updates(map[string]interface{}{
+			"message_count": gorm.Expr("message_count + ?", len(messages)),
+			"updated_at":    time.Now(),
+		}).Error
+	})
+}
+
+// ListSessions 按最近更新时间倒序列出会话，endpointID 为 0 时列出所有 API 路径的会话，
+// ownerKeyID 不为空时只列出属于该客户端 Key 轮换链的会话
+func ListSessions(endpointID uint, ownerKeyID *uint, page, pageSize int) ([]models.ChatSession, int64, error) {
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	query := models.DB.Model(&models.ChatSession{})
+	if endpointID != 0 {
+		query = query.Where("api_endpoint_id = ?", endpointID)
+	}
+	if ownerKeyID != nil {
+		query = query.Where("owner_key_id = ?", *ownerKeyID)
+	}
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var sessions []models.ChatSession
+	if err := query.Order("updated_at DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&sessions).Error; err != nil {
+		return nil, 0, err
+	}
+	return sessions, total, nil
+}
+
+// LoadSessionMessages 加载会话的全部消息
+func LoadSessionMessages(session *models.ChatSession) error {
+	return models.DB.Where("chat_session_id = ?", session.ID).Order("id ASC").Find(&session.Messages).Error
+}
+
+// DeleteSession 删除会话及其消息
+func DeleteSession(session *models.ChatSession) error {
+	return models.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("chat_session_id = ?", session.ID).Delete(&models.SessionMessage{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.ChatSession{}, session.ID).Error
+	})
+}
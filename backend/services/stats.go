@@ -45,10 +45,8 @@ func loadStatsFromDB() {
 	}
 }
 
-func AddStats(endpointID uint, inputTokens, outputTokens, cacheHitTokens int64) {
-	statsMutex.Lock()
-	defer statsMutex.Unlock()
-
+// currentStat 返回端点当天的内存统计，调用方需持有 statsMutex
+func currentStat(endpointID uint) *models.APIStats {
 	today := time.Now().Format("2006-01-02")
 	stat, ok := memoryStats[endpointID]
 	if !ok || stat.Date != today {
@@ -64,11 +62,35 @@ func AddStats(endpointID uint, inputTokens, outputTokens, cacheHitTokens int64)
 		stat = &dbStat
 		memoryStats[endpointID] = stat
 	}
+	return stat
+}
+
+// AddStats 记录一次成功调用的用量，cachedPromptTokens 与 reasoningTokens 分别包含在输入与输出 Token 中
+func AddStats(endpointID uint, inputTokens, outputTokens, cachedPromptTokens, reasoningTokens int64) {
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+
+	stat := currentStat(endpointID)
 
 	stat.CallCount++
 	stat.InputTokens += inputTokens
 	stat.OutputTokens += outputTokens
-	stat.CacheHitTokens += cacheHitTokens
+	stat.CachedPromptTokens += cachedPromptTokens
+	stat.ReasoningTokens += reasoningTokens
+	stat.LastUpdated = time.Now()
+}
+
+// AddFailedUsageStats 记录失败的尝试已经消耗的 Token（如回复不符合 JSON Schema 的调用与纠正调用），不计入调用次数
+func AddFailedUsageStats(endpointID uint, inputTokens, outputTokens, cachedPromptTokens, reasoningTokens int64) {
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+
+	stat := currentStat(endpointID)
+
+	stat.InputTokens += inputTokens
+	stat.OutputTokens += outputTokens
+	stat.CachedPromptTokens += cachedPromptTokens
+	stat.ReasoningTokens += reasoningTokens
 	stat.LastUpdated = time.Now()
 }
 
@@ -76,38 +98,103 @@ func AddFailedStats(endpointID uint, providerName, failedModel string) {
 	statsMutex.Lock()
 	defer statsMutex.Unlock()
 
-	today := time.Now().Format("2006-01-02")
-	stat, ok := memoryStats[endpointID]
-	if !ok || stat.Date != today {
-		// 如果内存中没有，或者日期变了，尝试从数据库获取或新建
-		var dbStat models.APIStats
-		err := models.DB.Where("api_endpoint_id = ? AND date = ?", endpointID, today).First(&dbStat).Error
-		if err == gorm.ErrRecordNotFound {
-			dbStat = models.APIStats{
-				APIEndpointID: endpointID,
-				Date:          today,
-			}
-		}
-		stat = &dbStat
-		memoryStats[endpointID] = stat
-	}
+	stat := currentStat(endpointID)
 
 	stat.FailedCallCount++
 	stat.LastFailedModel = failedModel
 
 	// 更新失败模型统计，使用 "供应商/模型名" 格式
-	failedModels := make(map[string]int64)
-	if stat.FailedModels != "" {
-		json.Unmarshal([]byte(stat.FailedModels), &failedModels)
-	}
-	modelKey := providerName + "/" + failedModel
-	failedModels[modelKey]++
-	failedModelsJSON, _ := json.Marshal(failedModels)
-	stat.FailedModels = string(failedModelsJSON)
+	stat.FailedModels = incrementModelCount(stat.FailedModels, providerName+"/"+failedModel)
+
+	stat.LastUpdated = time.Now()
+}
+
+// AddCacheHitStats 记录一次命中响应缓存的调用，tokens 为缓存回答原本消耗的输入与输出 Token
+func AddCacheHitStats(endpointID uint, tokens int64) {
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+
+	stat := currentStat(endpointID)
+	stat.CallCount++
+	stat.CacheHitTokens += tokens
+	stat.LastUpdated = time.Now()
+}
+
+// AddSemanticCacheHitStats 记录一次命中语义缓存的调用，同时计入 CallCount 与 CacheHitTokens
+func AddSemanticCacheHitStats(endpointID uint, tokens int64) {
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+
+	stat := currentStat(endpointID)
+	stat.CallCount++
+	stat.CacheHitTokens += tokens
+	stat.SemanticCacheHits++
+	stat.LastUpdated = time.Now()
+}
+
+// AddEstimatedStats 记录一次 Token 数为本地估算的调用，需与 AddStats 配合使用
+func AddEstimatedStats(endpointID uint) {
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+
+	stat := currentStat(endpointID)
+	stat.EstimatedCalls++
+	stat.LastUpdated = time.Now()
+}
+
+// AddRetryStats 记录一次同一供应商/模型上的重试
+func AddRetryStats(endpointID uint, providerName, modelName string) {
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+
+	stat := currentStat(endpointID)
+	stat.RetryCount++
+	stat.RetriedModels = incrementModelCount(stat.RetriedModels, providerName+"/"+modelName)
+	stat.LastUpdated = time.Now()
+}
+
+// AddPoolMemberStats 记录负载均衡池成员成功处理的一次调用
+func AddPoolMemberStats(endpointID uint, providerName, modelName string) {
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+
+	stat := currentStat(endpointID)
+	stat.PoolMemberCalls = incrementModelCount(stat.PoolMemberCalls, providerName+"/"+modelName)
+	stat.LastUpdated = time.Now()
+}
+
+// AddHedgeStats 记录一次发起过对冲的调用及胜出的供应商/模型
+func AddHedgeStats(endpointID uint, providerName, modelName string) {
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+
+	stat := currentStat(endpointID)
+	stat.HedgedCalls++
+	stat.HedgeWinners = incrementModelCount(stat.HedgeWinners, providerName+"/"+modelName)
+	stat.LastUpdated = time.Now()
+}
+
+// AddHedgeExtraTokens 记录对冲调用中落选尝试消耗的 Token
+func AddHedgeExtraTokens(endpointID uint, tokens int64) {
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
 
+	stat := currentStat(endpointID)
+	stat.HedgeExtraTokens += tokens
 	stat.LastUpdated = time.Now()
 }
 
+// incrementModelCount 将 JSON 计数表中指定 key 的计数加一，返回新的 JSON
+func incrementModelCount(countsJSON, key string) string {
+	counts := make(map[string]int64)
+	if countsJSON != "" {
+		json.Unmarshal([]byte(countsJSON), &counts)
+	}
+	counts[key]++
+	data, _ := json.Marshal(counts)
+	return string(data)
+}
+
 func SyncStatsToDB() {
 	statsMutex.Lock()
 	defer statsMutex.Unlock()
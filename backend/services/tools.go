@@ -0,0 +1,70 @@
+package services
+
+import (
+	"ai-api-platform/backend/models"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// EndpointTool 是 API 路径配置的一个工具
+type EndpointTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`  // 参数的 JSON Schema，需为 JSON 对象
+	WebhookURL  string          `json:"webhook_url,omitempty"` // server 模式下由代理调用的地址
+	Timeout     int             `json:"timeout,omitempty"`     // 调用 webhook 的超时时间（秒），0 表示使用默认值
+}
+
+var toolNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// maxToolTimeout 是单次 webhook 调用超时时间的上限（秒）
+const maxToolTimeout = 120
+
+// ParseEndpointTools 解析并检查 API 路径的工具定义，text 为空时返回 nil。
+// 工具名称需唯一且符合上游的命名规则；server 模式下每个工具都必须配置 http(s) 的 webhook_url。
+func ParseEndpointTools(text, mode string) ([]EndpointTool, error) {
+	switch mode {
+	case "", models.ToolModePassthrough, models.ToolModeServer:
+	default:
+		return nil, fmt.Errorf("unsupported tool mode: %s", mode)
+	}
+	if strings.TrimSpace(text) == "" {
+		return nil, nil
+	}
+
+	var tools []EndpointTool
+	if err := json.Unmarshal([]byte(text), &tools); err != nil {
+		return nil, fmt.Errorf("tools must be a JSON array: %v", err)
+	}
+
+	names := make(map[string]bool, len(tools))
+	for i, tool := range tools {
+		if !toolNamePattern.MatchString(tool.Name) {
+			return nil, fmt.Errorf("tools[%d]: name must match %s", i, toolNamePattern.String())
+		}
+		if names[tool.Name] {
+			return nil, fmt.Errorf("tools[%d]: duplicate name %q", i, tool.Name)
+		}
+		names[tool.Name] = true
+
+		if len(tool.Parameters) > 0 {
+			var params map[string]interface{}
+			if err := json.Unmarshal(tool.Parameters, &params); err != nil || params == nil {
+				return nil, fmt.Errorf("tools[%d]: parameters must be a JSON object", i)
+			}
+		}
+		if tool.Timeout < 0 || tool.Timeout > maxToolTimeout {
+			return nil, fmt.Errorf("tools[%d]: timeout must be between 0 and %d", i, maxToolTimeout)
+		}
+		if mode == models.ToolModeServer {
+			u, err := url.Parse(tool.WebhookURL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return nil, fmt.Errorf("tools[%d]: webhook_url must be an http(s) URL in server mode", i)
+			}
+		}
+	}
+	return tools, nil
+}
@@ -0,0 +1,106 @@
+package services
+
+import (
+	"ai-api-platform/backend/models"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	virtualKeys    map[string][]*models.VirtualKey // 前缀 -> 虚拟 Key（含可访问的 API 路径）
+	virtualKeysMux sync.RWMutex
+)
+
+// InitVirtualKeys 加载所有虚拟 Key
+func InitVirtualKeys() error {
+	var keys []models.VirtualKey
+	if err := models.DB.Preload("Endpoints").Find(&keys).Error; err != nil {
+		return err
+	}
+
+	virtualKeysMux.Lock()
+	defer virtualKeysMux.Unlock()
+
+	virtualKeys = make(map[string][]*models.VirtualKey, len(keys))
+	for i := range keys {
+		virtualKeys[keys[i].Prefix] = append(virtualKeys[keys[i].Prefix], &keys[i])
+	}
+	return nil
+}
+
+// RefreshVirtualKeys 在虚拟 Key 或 API 路径变更后重新加载
+func RefreshVirtualKeys() error {
+	return InitVirtualKeys()
+}
+
+// GenerateVirtualKey 生成 sk- 开头的随机虚拟 Key
+func GenerateVirtualKey() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return "sk-" + hex.EncodeToString(buf), nil
+}
+
+// GetVirtualKey 查找启用中的虚拟 Key，按前缀找到候选 Key 后以常数时间比较哈希
+func GetVirtualKey(key string) (*models.VirtualKey, bool) {
+	if key == "" {
+		return nil, false
+	}
+	hash := []byte(models.HashClientKey(key))
+
+	virtualKeysMux.RLock()
+	defer virtualKeysMux.RUnlock()
+
+	var matched *models.VirtualKey
+	for _, candidate := range virtualKeys[models.ClientKeyPrefix(key)] {
+		if subtle.ConstantTimeCompare(hash, []byte(candidate.KeyHash)) == 1 {
+			matched = candidate
+		}
+	}
+	if matched == nil || matched.Status == models.VirtualKeyDisabled {
+		return nil, false
+	}
+	return matched, true
+}
+
+// EndpointModelName 返回 API 路径在网关中对应的模型名称，即去掉开头 / 的路径
+func EndpointModelName(endpoint *models.APIEndpoint) string {
+	return strings.TrimPrefix(endpoint.Path, "/")
+}
+
+// VirtualKeyEndpoints 返回虚拟 Key 可访问的 API 路径（取自路径缓存，按模型名称排序）
+func VirtualKeyEndpoints(vk *models.VirtualKey) []*models.APIEndpoint {
+	endpoints := make([]*models.APIEndpoint, 0, len(vk.Endpoints))
+	for _, e := range vk.Endpoints {
+		if endpoint, ok := GetEndpointByID(e.ID); ok {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	sort.Slice(endpoints, func(i, j int) bool {
+		return endpoints[i].Path < endpoints[j].Path
+	})
+	return endpoints
+}
+
+// ResolveVirtualKeyEndpoint 根据请求中的 model 找到虚拟 Key 可访问的 API 路径，没有匹配时使用默认路径
+func ResolveVirtualKeyEndpoint(vk *models.VirtualKey, model string) (*models.APIEndpoint, bool) {
+	model = strings.TrimPrefix(strings.TrimSpace(model), "/")
+	for _, endpoint := range VirtualKeyEndpoints(vk) {
+		if EndpointModelName(endpoint) == model {
+			return endpoint, true
+		}
+	}
+	if vk.DefaultEndpointID != 0 {
+		for _, e := range vk.Endpoints {
+			if e.ID == vk.DefaultEndpointID {
+				return GetEndpointByID(e.ID)
+			}
+		}
+	}
+	return nil, false
+}
@@ -32,6 +32,47 @@ type Config struct {
 	Proxy struct {
 		Timeout int `yaml:"timeout"` // 超时时间（秒）
 	} `yaml:"proxy"`
+	ProviderKeys struct {
+		RateLimitCooldown int `yaml:"rate_limit_cooldown"` // 收到 429 且上游未返回 Retry-After 时的暂停时间（秒）
+		AuthErrorCooldown int `yaml:"auth_error_cooldown"` // 收到 401/403 时的暂停时间（秒）
+	} `yaml:"provider_keys"`
+	CircuitBreaker struct {
+		Disabled         bool    `yaml:"disabled"`           // 是否关闭熔断
+		WindowSize       int     `yaml:"window_size"`        // 统计错误率的滑动窗口大小（最近 N 次调用）
+		MinRequests      int     `yaml:"min_requests"`       // 窗口内至少有多少次调用才计算错误率
+		ErrorRate        float64 `yaml:"error_rate"`         // 打开熔断的错误率阈值（0-1）
+		CoolOff          int     `yaml:"cool_off"`           // 熔断打开后的冷却时间（秒），之后进入半开状态
+		HalfOpenRequests int     `yaml:"half_open_requests"` // 半开状态下允许同时进行的探测请求数
+	} `yaml:"circuit_breaker"`
+	Retry struct {
+		MaxRetries    int `yaml:"max_retries"`     // 同一个尝试遇到暂时性错误时的最大重试次数，0 表示不重试
+		BaseDelay     int `yaml:"base_delay"`      // 首次重试的退避时间（毫秒），之后每次翻倍
+		MaxDelay      int `yaml:"max_delay"`       // 单次退避时间上限（毫秒）
+		MaxRetryAfter int `yaml:"max_retry_after"` // 上游要求的 Retry-After 超过该值（秒）时不再等待，直接切换下一个尝试
+	} `yaml:"retry"`
+	ResponseCache struct {
+		Store         string `yaml:"store"`           // 存储方式：memory（默认）或 database
+		MaxEntries    int    `yaml:"max_entries"`     // 缓存条目数上限，超出后淘汰最久未使用（database 为最早写入）的条目
+		MaxEntryBytes int    `yaml:"max_entry_bytes"` // 单条缓存的大小上限（字节），更大的响应不缓存
+		// 语义缓存每个 API 路径的条目数上限，查询时需要逐条计算相似度，不宜过大
+		SemanticMaxEntries int `yaml:"semantic_max_entries"`
+	} `yaml:"response_cache"`
+	RateLimit struct {
+		Store string `yaml:"store"` // 限流状态的存储方式：memory（默认，单实例）或 database（多实例共享）
+	} `yaml:"rate_limit"`
+	// Pricing 是各模型每百万 Token 的价格（美元），按模型名称匹配，用于计算费用配额；未配置价格的模型不计费用
+	Pricing map[string]ModelPrice `yaml:"pricing"`
+	Quota   struct {
+		WebhookURL     string `yaml:"webhook_url"`     // 用量达到警告阈值时 POST 通知的地址，为空时不通知
+		WarnThresholds []int  `yaml:"warn_thresholds"` // 警告阈值（配额的百分比），未配置时为 80 与 100
+	} `yaml:"quota"`
+}
+
+// ModelPrice 是模型每百万 Token 的价格（美元）
+type ModelPrice struct {
+	Input       float64 `yaml:"input"`
+	Output      float64 `yaml:"output"`
+	CachedInput float64 `yaml:"cached_input"` // 命中供应商提示词缓存的输入 Token 的价格，0 表示按 input 计价
 }
 
 var GlobalConfig Config
@@ -25,6 +25,11 @@ const routes = [
         name: 'Endpoints',
         component: () => import('../views/Endpoints.vue')
       },
+      {
+        path: 'client-keys',
+        name: 'ClientKeys',
+        component: () => import('../views/ClientKeys.vue')
+      },
       {
         path: 'stats',
         name: 'Stats',
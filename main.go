@@ -41,6 +41,31 @@ func main() {
 	}
 	fmt.Println("API endpoint cache initialized successfully")
 
+	// 5.5. 加载供应商上游 Key 池
+	if err := services.InitProviderKeys(); err != nil {
+		log.Fatalf("Init provider keys failed: %v", err)
+	}
+
+	// 5.6. 加载 OpenAI 兼容网关的虚拟 Key
+	if err := services.InitVirtualKeys(); err != nil {
+		log.Fatalf("Init virtual keys failed: %v", err)
+	}
+
+	// 5.7. 初始化响应缓存
+	if err := services.InitResponseCache(); err != nil {
+		log.Fatalf("Init response cache failed: %v", err)
+	}
+
+	// 5.8. 初始化限流
+	if err := services.InitRateLimit(); err != nil {
+		log.Fatalf("Init rate limit failed: %v", err)
+	}
+
+	// 5.9. 加载自定义 API 路径的客户端 Key
+	if err := services.InitClientKeys(); err != nil {
+		log.Fatalf("Init client keys failed: %v", err)
+	}
+
 	// 6. 设置路由
 	r := gin.Default()
 
@@ -54,6 +79,12 @@ func main() {
 		auth.Use(middleware.AuthMiddleware())
 		{
 			auth.GET("/providers", handlers.GetProviders)
+			auth.GET("/provider-types", handlers.GetProviderTypes)
+			auth.GET("/providers/:id/models", handlers.GetProviderModels)
+			auth.GET("/providers/:id/keys", handlers.GetProviderKeys)
+			auth.POST("/providers/:id/keys", handlers.CreateProviderKey)
+			auth.PUT("/providers/:id/keys/:keyId", handlers.UpdateProviderKey)
+			auth.DELETE("/providers/:id/keys/:keyId", handlers.DeleteProviderKey)
 			auth.POST("/providers", handlers.CreateProvider)
 			auth.PUT("/providers/:id", handlers.UpdateProvider)
 			auth.DELETE("/providers/:id", handlers.DeleteProvider)
@@ -62,9 +93,28 @@ func main() {
 			auth.POST("/endpoints", handlers.CreateEndpoint)
 			auth.PUT("/endpoints/:id", handlers.UpdateEndpoint)
 			auth.DELETE("/endpoints/:id", handlers.DeleteEndpoint)
+			auth.DELETE("/endpoints/:id/cache", handlers.PurgeEndpointResponseCache)
+
+			auth.GET("/virtual-keys", handlers.GetVirtualKeys)
+			auth.POST("/virtual-keys", handlers.CreateVirtualKey)
+			auth.PUT("/virtual-keys/:id", handlers.UpdateVirtualKey)
+			auth.DELETE("/virtual-keys/:id", handlers.DeleteVirtualKey)
+
+			auth.GET("/client-keys", handlers.GetClientKeys)
+			auth.POST("/client-keys", handlers.IssueClientKey)
+			auth.PUT("/client-keys/:id", handlers.UpdateClientKey)
+			auth.POST("/client-keys/:id/revoke", handlers.RevokeClientKey)
+			auth.POST("/client-keys/:id/rotate", handlers.RotateClientKey)
+
+			auth.GET("/sessions", handlers.GetSessions)
+			auth.GET("/sessions/:id", handlers.GetSession)
+			auth.DELETE("/sessions/:id", handlers.DeleteSession)
 
 			auth.GET("/stats", handlers.GetStats)
 
+			auth.GET("/breakers", handlers.GetBreakers)
+			auth.POST("/breakers/reset", handlers.ResetBreaker)
+
 			// 用户管理接口
 			auth.GET("/user/info", handlers.GetUserInfo)
 			auth.PUT("/user/password", handlers.UpdatePassword)
@@ -72,6 +122,26 @@ func main() {
 		}
 	}
 
+	// OpenAI 与 Anthropic 兼容网关，使用虚拟 Key 鉴权
+	v1 := r.Group("/v1")
+	{
+		v1.POST("/chat/completions", handlers.OpenAIChatCompletions)
+		v1.POST("/messages", handlers.AnthropicMessages)
+		v1.GET("/models", handlers.OpenAIListModels)
+		v1.GET("/usage", handlers.OpenAIUsage)
+	}
+
+	// 客户端会话接口，使用客户端 Key（X-API-Key）鉴权，通过 ?path= 指定路径
+	sessions := r.Group("/sessions")
+	{
+		sessions.GET("", handlers.ListClientSessions)
+		sessions.GET("/:session_id", handlers.GetClientSession)
+		sessions.DELETE("/:session_id", handlers.DeleteClientSession)
+	}
+
+	// 客户端配额用量接口，使用客户端 Key（X-API-Key）鉴权，通过 ?path= 指定路径
+	r.GET("/usage", handlers.GetClientUsage)
+
 	// 静态资源与代理逻辑
 	// 注意：ProxyHandler 内部会检查路径是否存在于数据库中
 	// 如果不匹配，则尝试作为静态资源服务